@@ -0,0 +1,219 @@
+//go:build lzobench
+
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/pierrec/lz4/v4"
+	"github.com/rhnvrm/lzo1z"
+)
+
+// errStored is returned by a codec that would store its input uncompressed.
+var errStored = errors.New("input stored uncompressed")
+
+// codec adapts a block compressor to a common signature.
+type codec struct {
+	name       string
+	bound      func(n int) int
+	compress   func(dst, src []byte) (int, error)
+	decompress func(dst, src []byte) (int, error)
+}
+
+var codecs = []codec{
+	{
+		name:       "lzo1z",
+		bound:      lzo1z.MaxCompressedSize,
+		compress:   func(dst, src []byte) (int, error) { return lzo1z.Compress(src, dst) },
+		decompress: func(dst, src []byte) (int, error) { return lzo1z.Decompress(src, dst) },
+	},
+	{
+		name:  "lzo1z-level3",
+		bound: lzo1z.MaxCompressedSize,
+		compress: func(dst, src []byte) (int, error) {
+			return lzo1z.CompressLevel(src, dst, 3)
+		},
+		decompress: func(dst, src []byte) (int, error) { return lzo1z.Decompress(src, dst) },
+	},
+	{
+		name:  "s2",
+		bound: s2.MaxEncodedLen,
+		compress: func(dst, src []byte) (int, error) {
+			return len(s2.Encode(dst, src)), nil
+		},
+		decompress: func(dst, src []byte) (int, error) {
+			out, err := s2.Decode(dst, src)
+			return len(out), err
+		},
+	},
+	{
+		name:  "lz4",
+		bound: lz4.CompressBlockBound,
+		compress: func(dst, src []byte) (int, error) {
+			var c lz4.Compressor
+			n, err := c.CompressBlock(src, dst)
+			if err == nil && n == 0 {
+				err = errStored
+			}
+			return n, err
+		},
+		decompress: lz4.UncompressBlock,
+	},
+}
+
+const corpusSize = 1 << 20
+
+// corpora returns inputs of corpusSize bytes, generated so that every run
+// and every codec sees the same data.
+func corpora() []struct {
+	name string
+	data []byte
+} {
+	return []struct {
+		name string
+		data []byte
+	}{
+		{"text", textCorpus()},
+		{"binary", binaryCorpus()},
+		{"incompressible", noiseCorpus()},
+	}
+}
+
+// xorshift returns a deterministic pseudo-random sequence.
+func xorshift(seed uint32) func() uint32 {
+	x := seed
+	return func() uint32 {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		return x
+	}
+}
+
+// textCorpus returns sentences of words drawn unevenly from a small
+// vocabulary, like prose or logs.
+func textCorpus() []byte {
+	words := bytes.Fields([]byte(`the of and to in is was that for on with as
+		by at from his her they this which be are or had not but an have
+		compression block stream offset match literal buffer decoder
+		request response server client error timeout retry connection`))
+	next := xorshift(1)
+	var buf bytes.Buffer
+	for buf.Len() < corpusSize {
+		n := 5 + next()%12
+		for i := uint32(0); i < n; i++ {
+			r := next()
+			// Squaring skews the choice toward the first, common words
+			w := words[(r%256)*(r%256)*uint32(len(words))/(256*256)]
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.Write(w)
+		}
+		buf.WriteString(".\n")
+	}
+	return buf.Bytes()[:corpusSize]
+}
+
+// binaryCorpus returns fixed-size little-endian records with counters,
+// small integers and flags, like a table dump or telemetry feed.
+func binaryCorpus() []byte {
+	next := xorshift(2)
+	out := make([]byte, 0, corpusSize+32)
+	for i := uint32(0); len(out) < corpusSize; i++ {
+		r := next()
+		out = binary.LittleEndian.AppendUint32(out, i)
+		out = binary.LittleEndian.AppendUint64(out, 1700000000000+uint64(i)*1000+uint64(r%50))
+		out = binary.LittleEndian.AppendUint16(out, uint16(r%1000))
+		out = append(out, byte(r>>16)&3, 0, 0, 0)
+		out = binary.LittleEndian.AppendUint32(out, (r>>20)%64)
+	}
+	return out[:corpusSize]
+}
+
+// noiseCorpus returns pseudo-random bytes, like already-compressed or
+// encrypted data.
+func noiseCorpus() []byte {
+	next := xorshift(3)
+	out := make([]byte, corpusSize)
+	for i := 0; i < len(out); i += 4 {
+		binary.LittleEndian.PutUint32(out[i:], next())
+	}
+	return out
+}
+
+func TestRoundtrip(t *testing.T) {
+	for _, corpus := range corpora() {
+		for _, c := range codecs {
+			comp := make([]byte, c.bound(len(corpus.data)))
+			n, err := c.compress(comp, corpus.data)
+			if errors.Is(err, errStored) {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s/%s: compress failed: %v", corpus.name, c.name, err)
+			}
+			out := make([]byte, len(corpus.data))
+			m, err := c.decompress(out, comp[:n])
+			if err != nil || !bytes.Equal(out[:m], corpus.data) {
+				t.Errorf("%s/%s: roundtrip failed: %v", corpus.name, c.name, err)
+			}
+			t.Logf("%s/%s: %d -> %d bytes", corpus.name, c.name, len(corpus.data), n)
+		}
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	for _, corpus := range corpora() {
+		for _, c := range codecs {
+			b.Run(corpus.name+"/"+c.name, func(b *testing.B) {
+				src := corpus.data
+				dst := make([]byte, c.bound(len(src)))
+				b.SetBytes(int64(len(src)))
+				b.ResetTimer()
+				var n int
+				for i := 0; i < b.N; i++ {
+					var err error
+					if n, err = c.compress(dst, src); errors.Is(err, errStored) {
+						n = len(src)
+					} else if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(src))/float64(n), "ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, corpus := range corpora() {
+		for _, c := range codecs {
+			b.Run(corpus.name+"/"+c.name, func(b *testing.B) {
+				src := corpus.data
+				comp := make([]byte, c.bound(len(src)))
+				n, err := c.compress(comp, src)
+				if errors.Is(err, errStored) {
+					b.Skip("stored uncompressed, nothing to decode")
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				comp = comp[:n]
+				dst := make([]byte, len(src))
+				b.SetBytes(int64(len(src)))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.decompress(dst, comp); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(src))/float64(n), "ratio")
+			})
+		}
+	}
+}
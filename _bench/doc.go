@@ -0,0 +1,13 @@
+// Package bench compares the throughput and ratio of lzo1z with other LZ
+// block compressors, s2 and LZ4, on text, binary and incompressible data.
+//
+// It is a separate module, so its dependencies never reach users of
+// lzo1z, in a directory the go tool skips for ./... patterns. The
+// benchmarks also need the lzobench build tag:
+//
+//	cd _bench
+//	go mod tidy
+//	go test -tags lzobench -bench . -benchmem
+//
+// Each benchmark reports the compression ratio as the "ratio" metric.
+package bench
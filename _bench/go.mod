@@ -0,0 +1,11 @@
+module github.com/rhnvrm/lzo1z/_bench
+
+go 1.21
+
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/pierrec/lz4/v4 v4.1.21
+	github.com/rhnvrm/lzo1z v0.0.0
+)
+
+replace github.com/rhnvrm/lzo1z => ../
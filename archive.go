@@ -0,0 +1,229 @@
+package lzo1z
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// archiveMagic ends every archive written by ArchiveWriter.
+var archiveMagic = [4]byte{'L', 'Z', '1', 'A'}
+
+// archiveTrailerLen is the size of the trailer ending an archive:
+//
+//	uint64 big-endian: offset of the directory
+//	uint32 big-endian: number of entries
+//	[4]byte:           magic "LZ1A"
+//
+// The archive starts with one frame per entry, in the format of
+// WriteFrame, followed by the directory, which lists the entries in order:
+//
+//	uint16 big-endian: name length
+//	name bytes
+//	uint64 big-endian: offset of the entry's frame
+//
+// An entry's frame extends to the next entry's frame, or to the directory.
+const archiveTrailerLen = 16
+
+// archiveDirEntryMin is the size of a directory entry with an empty name.
+const archiveDirEntryMin = 2 + 8
+
+// ErrNameTooLong is returned by ArchiveWriter.Add for a name longer than
+// 65535 bytes.
+var ErrNameTooLong = errors.New("lzo1z: archive entry name too long")
+
+// ArchiveWriter packs named payloads into a single archive: each is
+// compressed into a frame of its own, and Close appends a directory of
+// names and offsets, so an ArchiveReader can read any entry without
+// decoding the others.
+type ArchiveWriter struct {
+	w      countWriter
+	dir    []byte // directory entries so far
+	count  int
+	err    error // sticky error from the underlying writer
+	closed bool
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewArchiveWriter returns an ArchiveWriter writing to w.
+func NewArchiveWriter(w io.Writer) *ArchiveWriter {
+	return &ArchiveWriter{w: countWriter{w: w}}
+}
+
+// Add compresses data and writes it as the next entry, under name. Names
+// need not be unique; ArchiveReader.Index finds the first entry with a
+// name.
+func (a *ArchiveWriter) Add(name string, data []byte) error {
+	if a.closed {
+		return ErrClosed
+	}
+	if a.err != nil {
+		return a.err
+	}
+	if len(name) > math.MaxUint16 {
+		return ErrNameTooLong
+	}
+	if uint64(len(data)) > math.MaxUint32 {
+		return ErrFrameTooLarge
+	}
+
+	off := a.w.n
+	if err := WriteFrame(&a.w, data); err != nil {
+		a.err = err
+		return err
+	}
+	a.dir = binary.BigEndian.AppendUint16(a.dir, uint16(len(name)))
+	a.dir = append(a.dir, name...)
+	a.dir = binary.BigEndian.AppendUint64(a.dir, uint64(off))
+	a.count++
+	return nil
+}
+
+// Close writes the directory and trailer and marks the ArchiveWriter
+// closed. It does not close the underlying writer.
+func (a *ArchiveWriter) Close() error {
+	if a.closed {
+		return a.err
+	}
+	a.closed = true
+	if a.err != nil {
+		return a.err
+	}
+
+	buf := a.dir
+	buf = binary.BigEndian.AppendUint64(buf, uint64(a.w.n))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(a.count))
+	buf = append(buf, archiveMagic[:]...)
+	if _, err := a.w.Write(buf); err != nil {
+		a.err = err
+		return err
+	}
+	return nil
+}
+
+// ArchiveReader reads the entries of an archive written by ArchiveWriter.
+// Entries are numbered from 0 in the order they were added and can be
+// read in any order.
+type ArchiveReader struct {
+	r       io.ReaderAt
+	names   []string
+	offsets []int64 // frame offsets, followed by the directory offset
+}
+
+// NewArchiveReader reads the directory of the archive of the given size
+// held by r. A missing trailer or a directory that does not fit the
+// archive returns ErrCorrupted.
+func NewArchiveReader(r io.ReaderAt, size int64) (*ArchiveReader, error) {
+	if size < archiveTrailerLen {
+		return nil, ErrCorrupted
+	}
+	var trailer [archiveTrailerLen]byte
+	if err := readFullAt(r, trailer[:], size-archiveTrailerLen); err != nil {
+		return nil, err
+	}
+	if [4]byte(trailer[12:]) != archiveMagic {
+		return nil, ErrCorrupted
+	}
+	dirOff := binary.BigEndian.Uint64(trailer[0:])
+	count := binary.BigEndian.Uint32(trailer[8:])
+
+	// The directory lies between the last frame and the trailer, and
+	// its length bounds the entry count a corrupt trailer can claim
+	dirEnd := uint64(size - archiveTrailerLen)
+	if dirOff > dirEnd || uint64(count) > (dirEnd-dirOff)/archiveDirEntryMin {
+		return nil, ErrCorrupted
+	}
+	dir := make([]byte, dirEnd-dirOff)
+	if err := readFullAt(r, dir, int64(dirOff)); err != nil {
+		return nil, err
+	}
+
+	a := &ArchiveReader{
+		r:       r,
+		names:   make([]string, count),
+		offsets: make([]int64, count+1),
+	}
+	prev := uint64(0)
+	for i := range a.names {
+		if len(dir) < 2 {
+			return nil, ErrCorrupted
+		}
+		n := int(binary.BigEndian.Uint16(dir))
+		if len(dir) < 2+n+8 {
+			return nil, ErrCorrupted
+		}
+		off := binary.BigEndian.Uint64(dir[2+n:])
+		if off < prev || off > dirOff {
+			return nil, ErrCorrupted
+		}
+		a.names[i] = string(dir[2 : 2+n])
+		a.offsets[i] = int64(off)
+		prev = off
+		dir = dir[2+n+8:]
+	}
+	if len(dir) != 0 {
+		return nil, ErrCorrupted
+	}
+	a.offsets[count] = int64(dirOff)
+	return a, nil
+}
+
+// readFullAt reads len(p) bytes at off, accepting the io.EOF a ReaderAt
+// may return along with the last bytes. Fewer bytes return
+// ErrInputOverrun.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	return readErr(err)
+}
+
+// Len returns the number of entries in the archive.
+func (a *ArchiveReader) Len() int {
+	return len(a.names)
+}
+
+// Name returns the name of entry i.
+func (a *ArchiveReader) Name(i int) string {
+	return a.names[i]
+}
+
+// Index returns the number of the first entry named name, or -1 if there
+// is none. It compares every name in turn.
+func (a *ArchiveReader) Index(name string) int {
+	for i, n := range a.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Entry reads and decompresses entry i, verifying it like ReadFrame.
+func (a *ArchiveReader) Entry(i int) ([]byte, error) {
+	off, end := a.offsets[i], a.offsets[i+1]
+	frame := make([]byte, end-off)
+	if err := readFullAt(a.r, frame, off); err != nil {
+		return nil, err
+	}
+	// The frame must fill its section exactly, which also keeps a corrupt
+	// length from making ReadFrame allocate beyond the archive's size
+	if len(frame) < frameHeaderLen || int64(binary.BigEndian.Uint32(frame[12:])) != end-off-frameHeaderLen {
+		return nil, ErrCorrupted
+	}
+	return ReadFrame(bytes.NewReader(frame))
+}
@@ -0,0 +1,150 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRoundtrip(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{"readme.txt", bytes.Repeat([]byte("archives hold many records "), 200)},
+		{"empty", nil},
+		{"random.bin", random},
+		{"", []byte("unnamed")},
+		{"logs/2024-05-01", []byte(strings.Repeat("INFO request served\n", 50))},
+		{"readme.txt", []byte("a second entry with the same name")},
+	}
+
+	var buf bytes.Buffer
+	w := NewArchiveWriter(&buf)
+	for _, e := range entries {
+		if err := w.Add(e.name, e.data); err != nil {
+			t.Fatalf("Add(%q) failed: %v", e.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := NewArchiveReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("NewArchiveReader failed: %v", err)
+	}
+	if r.Len() != len(entries) {
+		t.Fatalf("Len() = %d, want %d", r.Len(), len(entries))
+	}
+
+	// In order, then in reverse
+	for pass, order := range [][]int{{0, 1, 2, 3, 4, 5}, {5, 4, 3, 2, 1, 0}} {
+		for _, i := range order {
+			if r.Name(i) != entries[i].name {
+				t.Errorf("pass %d: Name(%d) = %q, want %q", pass, i, r.Name(i), entries[i].name)
+			}
+			data, err := r.Entry(i)
+			if err != nil || !bytes.Equal(data, entries[i].data) {
+				t.Errorf("pass %d: Entry(%d) = %d bytes, %v; want %d bytes", pass, i, len(data), err, len(entries[i].data))
+			}
+		}
+	}
+
+	// By name: the first entry wins, missing names give -1
+	for name, want := range map[string]int{"random.bin": 2, "readme.txt": 0, "": 3, "missing": -1} {
+		if i := r.Index(name); i != want {
+			t.Errorf("Index(%q) = %d, want %d", name, i, want)
+		}
+	}
+}
+
+func TestArchiveEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewArchiveWriter(&buf).Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if buf.Len() != archiveTrailerLen {
+		t.Errorf("empty archive is %d bytes, want %d", buf.Len(), archiveTrailerLen)
+	}
+	r, err := NewArchiveReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || r.Len() != 0 {
+		t.Errorf("empty archive: got %v, %v", r, err)
+	}
+}
+
+func TestArchiveWriterErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewArchiveWriter(&buf)
+	if err := w.Add(strings.Repeat("n", 1<<16), nil); !errors.Is(err, ErrNameTooLong) {
+		t.Errorf("long name: expected ErrNameTooLong, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := w.Add("late", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Add after Close: expected ErrClosed, got %v", err)
+	}
+
+	errBoom := errors.New("boom")
+	w = NewArchiveWriter(failWriter{errBoom})
+	if err := w.Add("x", []byte("data")); err != errBoom {
+		t.Errorf("expected errBoom, got %v", err)
+	}
+	if err := w.Close(); err != errBoom {
+		t.Errorf("Close: expected errBoom, got %v", err)
+	}
+}
+
+func TestArchiveReaderCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewArchiveWriter(&buf)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := w.Add(name, bytes.Repeat([]byte(name), 100)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	archive := buf.Bytes()
+
+	// Every truncation loses the trailer
+	for cut := 0; cut < len(archive); cut++ {
+		if _, err := NewArchiveReader(bytes.NewReader(archive), int64(cut)); err == nil {
+			t.Errorf("cut %d: expected an error", cut)
+		}
+	}
+
+	// Flipping any byte is caught by the directory checks or the frame
+	// CRC, and never panics
+	for i := range archive {
+		bad := bytes.Clone(archive)
+		bad[i] ^= 0x80
+		if readArchive(bad) == "a:aaa b:bbb c:ccc" {
+			t.Errorf("flip at %d: archive read back unchanged", i)
+		}
+	}
+}
+
+// readArchive summarizes an archive as name:data-prefix pairs, or the first
+// error.
+func readArchive(archive []byte) string {
+	r, err := NewArchiveReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return err.Error()
+	}
+	var s []string
+	for i := 0; i < r.Len(); i++ {
+		data, err := r.Entry(i)
+		if err != nil {
+			return err.Error()
+		}
+		s = append(s, r.Name(i)+":"+string(data[:min(3, len(data))]))
+	}
+	return strings.Join(s, " ")
+}
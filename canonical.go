@@ -0,0 +1,104 @@
+package lzo1z
+
+import "encoding/binary"
+
+// CanonicalVersion identifies the canonical form produced by Canonicalize.
+// The canonical bytes of a given input are the same in every release that
+// reports the same CanonicalVersion; a release that has to change them
+// increments it, so content-addressing keys can record the version that
+// produced them.
+const CanonicalVersion = 1
+
+// Canonicalize validates an LZO1Z stream and re-encodes it in canonical form.
+//
+// The stream is fully decoded (rejecting truncated, corrupted or
+// over-long input exactly as Decompress does) and the decoded bytes are
+// re-encoded by a fixed canonical encoder. Any two streams that decode to
+// the same output canonicalize to identical bytes, which makes the result
+// suitable as a content-addressing key.
+//
+// maxLen caps the decompressed size, as for DecompressAppendMax: a stream
+// that would decode to more fails with ErrOutputTooLarge, having allocated
+// at most maxLen bytes. Zero or less means no limit, which is only safe
+// for trusted input.
+//
+// The canonical form uses minimal extended-length encodings, a single
+// literal run between matches, and greedy matches of at least 3 bytes
+// found with a fixed hash. It is not necessarily the smallest encoding,
+// and it does not follow improvements to Compress: it only changes along
+// with CanonicalVersion.
+func Canonicalize(src []byte, maxLen int) ([]byte, error) {
+	if len(src) == 0 {
+		return []byte{}, nil
+	}
+
+	out, err := DecompressAppendMax(nil, src, maxLen)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := make([]byte, MaxCompressedSize(len(out)))
+	n, err := canonicalEncode(out, dst)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
+
+// canonicalHashBits is the hash width of canonicalEncode. Like everything
+// else in canonicalEncode it is part of the canonical form.
+const canonicalHashBits = 14
+
+// canonicalEncode is the encoder behind CanonicalVersion 1. It is frozen:
+// TestCanonicalizePinned fails on any change to its output, which must
+// come with a new CanonicalVersion. It deliberately shares no match
+// search with Compress, so that Compress stays free to change.
+func canonicalEncode(src, dst []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, nil
+	}
+	if len(src) <= 3 {
+		return compressLiteralsOnly(src, dst)
+	}
+
+	// Positions stored as pos+1, so zero means empty
+	var hashTable [1 << canonicalHashBits]int
+	hash := func(p int) int {
+		return int((binary.LittleEndian.Uint32(src[p:]) * 0x1e35a7bd) >> (32 - canonicalHashBits))
+	}
+
+	var s compressState
+	for s.ip+4 <= len(src) {
+		h := hash(s.ip)
+		ref := hashTable[h] - 1
+		hashTable[h] = s.ip + 1
+
+		offset := s.ip - ref
+		if ref < 0 || offset > maxOffset || commonLen(src, ref, s.ip, 3) < 3 {
+			s.ip++
+			continue
+		}
+		matchLen := 3 + commonLen(src, ref+3, s.ip+3, len(src)-s.ip-3)
+
+		if s.ip > s.litStart {
+			n, err := emitPendingLiterals(src[s.litStart:s.ip], dst[s.op:], s.state)
+			if err != nil {
+				return s.op, err
+			}
+			s.op += n
+		}
+		n, err := emitMatch(dst[s.op:], offset, matchLen)
+		if err != nil {
+			return s.op, err
+		}
+		s.op += n
+		s.state = &dst[s.op-1]
+
+		for i := s.ip + 1; i < s.ip+matchLen && i+4 <= len(src); i++ {
+			hashTable[hash(i)] = i + 1
+		}
+		s.ip += matchLen
+		s.litStart = s.ip
+	}
+	return compressFinish(src, dst, &s, nil)
+}
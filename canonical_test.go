@@ -0,0 +1,152 @@
+package lzo1z
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestCanonicalizeDifferentEncodings(t *testing.T) {
+	// Both streams decode to "AAAAAAAAAA"
+	literalOnly := []byte{0x1b, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x11, 0x00, 0x00}
+	withMatch := []byte{
+		0x12, 0x41, // 1 literal 'A'
+		0x27, 0x00, 0x00, // M3: length 9, offset 1
+		0x11, 0x00, 0x00, // EOF
+	}
+
+	c1, err := Canonicalize(literalOnly, 0)
+	if err != nil {
+		t.Fatalf("Canonicalize(literalOnly, 0) failed: %v", err)
+	}
+	c2, err := Canonicalize(withMatch, 0)
+	if err != nil {
+		t.Fatalf("Canonicalize(withMatch, 0) failed: %v", err)
+	}
+	if !bytes.Equal(c1, c2) {
+		t.Errorf("canonical forms differ:\n%x\n%x", c1, c2)
+	}
+
+	out := make([]byte, 20)
+	n, err := Decompress(c1, out)
+	if err != nil {
+		t.Fatalf("Decompress(canonical) failed: %v", err)
+	}
+	if string(out[:n]) != "AAAAAAAAAA" {
+		t.Errorf("canonical form decodes to %q", out[:n])
+	}
+}
+
+func TestCanonicalizeCVectors(t *testing.T) {
+	// liblzo2 and the Go compressors encode differently; both must
+	// canonicalize to the same bytes
+	for _, tc := range interopTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			canon, err := Canonicalize(tc.compressed, 0)
+			if err != nil {
+				t.Fatalf("Canonicalize failed: %v", err)
+			}
+			out := make([]byte, len(tc.input))
+			if n, err := Decompress(canon, out); err != nil || !bytes.Equal(out[:n], tc.input) {
+				t.Fatalf("canonical form does not decode to the input: %v", err)
+			}
+
+			for level := 1; level <= 3; level++ {
+				dst := make([]byte, MaxCompressedSize(len(tc.input)))
+				n, err := CompressLevel(tc.input, dst, level)
+				if err != nil {
+					t.Fatalf("CompressLevel failed: %v", err)
+				}
+				other, err := Canonicalize(dst[:n], 0)
+				if err != nil || !bytes.Equal(canon, other) {
+					t.Errorf("level %d output canonicalizes differently (%v)", level, err)
+				}
+			}
+
+			again, err := Canonicalize(canon, 0)
+			if err != nil {
+				t.Fatalf("Canonicalize(canonical) failed: %v", err)
+			}
+			if !bytes.Equal(canon, again) {
+				t.Errorf("Canonicalize is not idempotent")
+			}
+		})
+	}
+}
+
+func TestCanonicalizePinned(t *testing.T) {
+	// The canonical form of CanonicalVersion 1 is pinned: a change to
+	// these bytes needs a new CanonicalVersion, not a new hash
+	const want = "618289b2f166792293ddcf3fbec7c771be94a0812940378c3bcae8b1a76ff651"
+	corpus := deterministicCorpus()
+	for _, tc := range interopTestCases {
+		corpus = append(corpus, tc.input)
+	}
+
+	h := sha256.New()
+	for _, input := range corpus {
+		comp := MustCompress(input, nil)
+		canon, err := Canonicalize(comp, 0)
+		if err != nil {
+			t.Fatalf("Canonicalize failed: %v", err)
+		}
+		if len(canon) > MaxCompressedSize(len(input)) {
+			t.Errorf("canonical form of %d bytes exceeds MaxCompressedSize", len(input))
+		}
+		h.Write(canon)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		t.Errorf("canonical corpus hash = %s, want %s", got, want)
+	}
+}
+
+func TestCanonicalizeMaxLen(t *testing.T) {
+	// One literal, then a single extended-length match repeating it 16 MiB
+	const matchLen = 16 << 20
+	stream := make([]byte, MaxCompressedSize(matchLen))
+	n, _ := emitPendingLiterals([]byte{'a'}, stream, nil)
+	m, err := emitMatch(stream[n:], 1, matchLen)
+	if err != nil {
+		t.Fatalf("emitMatch failed: %v", err)
+	}
+	stream = append(stream[:n+m], 0x11, 0x00, 0x00)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = Canonicalize(stream, 1<<20)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Errorf("expected ErrOutputTooLarge, got %v", err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 2<<20 {
+		t.Errorf("allocated %d bytes under a 1 MiB limit", alloc)
+	}
+
+	if _, err := Canonicalize(stream, 1+matchLen); err != nil {
+		t.Errorf("limit equal to the output: %v", err)
+	}
+}
+
+func TestCanonicalizeRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"truncated", []byte{0x15, 0x41, 0x42}, ErrInputOverrun},
+		{"lookbehind", []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}, ErrLookbehindOverrun},
+		{"trailing_garbage", []byte{0x12, 0x41, 0x11, 0x00, 0x00, 0x00}, ErrInputNotConsumed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Canonicalize(tc.src, 0)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package lzo1z
+
+import "crypto/sha256"
+
+// DecompressVerifyHash decompresses src into dst like Decompress and checks
+// that the SHA-256 of the output equals want, returning ErrChecksumMismatch
+// otherwise. This suits content-addressed storage where the expected hash
+// is known up front.
+//
+// The output is hashed in one pass once decoding completes, while it is
+// still hot in cache; on mismatch the decoded length is still returned.
+func DecompressVerifyHash(src, dst []byte, want [32]byte) (int, error) {
+	n, err := Decompress(src, dst)
+	if err != nil {
+		return n, err
+	}
+	if sha256.Sum256(dst[:n]) != want {
+		return n, ErrChecksumMismatch
+	}
+	return n, nil
+}
@@ -0,0 +1,43 @@
+package lzo1z
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDecompressVerifyHash(t *testing.T) {
+	src, err := hex.DecodeString(postLiteralMatchCompressedHex)
+	if err != nil {
+		t.Fatalf("decode compressed vector: %v", err)
+	}
+	var want [32]byte
+	if _, err := hex.Decode(want[:], []byte("5f65ac37285d37b6e0a4d6196ad92997e90a887f3e90831a9de43c925eee0f4a")); err != nil {
+		t.Fatalf("decode hash: %v", err)
+	}
+
+	dst := make([]byte, 4096)
+	n, err := DecompressVerifyHash(src, dst, want)
+	if err != nil {
+		t.Fatalf("DecompressVerifyHash failed: %v", err)
+	}
+	if n != 574 {
+		t.Errorf("decompressed length = %d, want 574", n)
+	}
+
+	// Flip a literal byte: the stream still decodes but the hash differs
+	tampered := append([]byte{}, src...)
+	tampered[1] ^= 0x01
+	n, err = DecompressVerifyHash(tampered, dst, want)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if n != 574 {
+		t.Errorf("tampered length = %d, want 574", n)
+	}
+
+	// Decode errors take precedence over the checksum
+	if _, err := DecompressVerifyHash(src[:len(src)-3], dst, want); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("expected ErrInputOverrun, got %v", err)
+	}
+}
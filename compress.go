@@ -1,58 +1,221 @@
 package lzo1z
 
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+// Compressor tuning constants
+const (
+	hashBits = 14
+	hashSize = 1 << hashBits
+
+	// Inputs of up to smallInputLen bytes are hashed into a table of
+	// smallHashSize entries instead, which is far cheaper to clear and
+	// still has several slots per position
+	smallHashBits = 10
+	smallHashSize = 1 << smallHashBits
+	smallInputLen = 256
+	maxOffset     = 0xbfff // M4 max offset: 49151
+	m1MaxOffset   = 0x400  // 2-byte M1 max offset: 1024
+	minMatch      = 3
+
+	// Hash chains link each position to the previous one with the same
+	// hash. The window covers maxOffset, so links of positions still in
+	// range are never overwritten.
+	windowBits = 16
+	windowSize = 1 << windowBits
+	windowMask = windowSize - 1
+)
+
+// ErrAliasedBuffers is returned when the destination of a compression
+// overlaps its source. The compressor reads src behind the position it is
+// writing, so it cannot work in place.
+var ErrAliasedBuffers = errors.New("lzo1z: src and dst overlap")
+
 // Compress compresses src using LZO1Z algorithm and writes to dst.
 // Returns the number of bytes written to dst.
 // dst must be large enough to hold the compressed data.
 // Worst case size is: len(src) + len(src)/16 + 64 + 3
 //
 // This is a greedy compressor optimized for speed over compression ratio.
+// src and dst must not overlap; ErrAliasedBuffers is returned if they do.
+//
+// The output is deterministic: the same input always compresses to the
+// same bytes, on every platform and Go version, as it does with
+// CompressLevel and a Compressor for the same settings. The match search
+// uses only fixed-width integer arithmetic and no randomness or map
+// iteration, so compressed output can be part of a reproducible build.
 func Compress(src, dst []byte) (int, error) {
+	return compressNew(src, dst, compressConfig{})
+}
+
+// compressNew runs compressBlock with a fresh hash table sized for src.
+// Positions are stored as pos+1, so the zero value means "empty" and the
+// table needs no fill loop.
+func compressNew(src, dst []byte, cfg compressConfig) (int, error) {
+	if len(src) <= smallInputLen {
+		var hashTable [smallHashSize]int
+		return compressBlock(src, dst, hashTable[:], 1, cfg)
+	}
+	var hashTable [hashSize]int
+	return compressBlock(src, dst, hashTable[:], 1, cfg)
+}
+
+// hashTableLen returns the hash table length used for an input of n bytes.
+// The output depends on it, so every compressor matching Compress picks
+// its table this way.
+func hashTableLen(n int) int {
+	if n <= smallInputLen {
+		return smallHashSize
+	}
+	return hashSize
+}
+
+// compressConfig selects the optional match-search strategies of
+// compressBlock. The zero value is the greedy compressor used by Compress.
+type compressConfig struct {
+	lazy  bool  // defer a match by one byte when the next position matches longer
+	chain []int // hash chain links (windowSize entries, pos+base), nil for single-slot search
+	depth int   // candidates examined per position when chain is set
+	accel int   // initial step after a miss, growing as misses repeat; 0 steps one byte
+
+	// minMatch4 skips the 2-byte M1 matches after short literal runs
+	minMatch4 bool
+
+	// fastDecode skips matches that are slow to decode, see slowToDecode
+	fastDecode bool
+
+	// hash5 hashes 5 bytes per position instead of 4
+	hash5 bool
+
+	ctx context.Context // checked every ctxCheckInterval input bytes, nil to never check
+
+	stats *Stats // receives a count of every emitted opcode, nil to skip
+
+	// encodable, if set, further restricts the matches matchEncodable
+	// accepts. It lets tests force the literal fallback for matches the
+	// search would otherwise emit.
+	encodable func(offset, length int) bool
+}
+
+// accelShift sets how fast the step of compressConfig.accel grows: by one
+// every 1<<accelShift consecutive misses.
+const accelShift = 6
+
+// ctxCheckInterval is how many input bytes compressBlock processes between
+// checks of compressConfig.ctx.
+const ctxCheckInterval = 64 << 10
+
+// compressBlock implements Compress using the caller's hash table, whose
+// length, smallHashSize or hashSize, selects the hash width.
+// Positions are stored in the table as pos+base; entries below base are
+// treated as empty, which lets a reused table be invalidated by raising
+// base instead of clearing it. base must be at least 1.
+func compressBlock(src, dst []byte, hashTable []int, base int, cfg compressConfig) (int, error) {
 	if len(src) == 0 {
 		return 0, nil
 	}
+	if overlaps(src, dst) {
+		return 0, ErrAliasedBuffers
+	}
 
 	// For very short inputs, just store as literals
 	if len(src) <= 3 {
+		if cfg.stats != nil {
+			cfg.stats.addLiterals(len(src))
+		}
 		return compressLiteralsOnly(src, dst)
 	}
 
-	const (
-		hashBits  = 14
-		hashSize  = 1 << hashBits
-		hashMask  = hashSize - 1
-		maxOffset = 0xbfff // M4 max offset: 49151
-		minMatch  = 3
-	)
-
-	// Hash table: maps 4-byte sequences to positions
-	var hashTable [hashSize]int
+	var s compressState
+	if err := compressScan(src, dst, hashTable, base, cfg, &s, len(src)-minMatch); err != nil {
+		return s.op, err
+	}
+	return compressFinish(src, dst, &s, cfg.stats)
+}
 
-	// Initialize hash table to -maxOffset to avoid false matches
-	for i := range hashTable {
-		hashTable[i] = -maxOffset
+// overlaps reports whether a and b share any bytes.
+func overlaps(a, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
 	}
+	pa := uintptr(unsafe.Pointer(unsafe.SliceData(a)))
+	pb := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	return pa < pb+uintptr(len(b)) && pb < pa+uintptr(len(a))
+}
+
+// compressState is the position of compressScan between calls.
+type compressState struct {
+	ip       int   // input position
+	op       int   // output position
+	litStart int   // start of pending literals
+	state    *byte // last offset byte of the previous match, nil before the first
+	lastOff  int   // offset of the previous match
+}
 
-	ip := 0               // input position
-	op := 0               // output position
-	litStart := 0         // start of pending literals
-	isFirstOutput := true // whether we're at the start of output
+// compressScan runs the match search of compressBlock from s.ip until the
+// input position reaches stop, which must be at most len(src)-minMatch,
+// and records where it got to in s. Matches may extend past stop up to
+// the end of src. On error only s.op is updated.
+func compressScan(src, dst []byte, hashTable []int, base int, cfg compressConfig, s *compressState, stop int) error {
+	ip := s.ip
+	op := s.op
+	litStart := s.litStart
+	state := s.state
+	lastOff := s.lastOff
+	misses := 0 // positions since the last match, for cfg.accel
 	inLen := len(src)
-	outLen := len(dst)
 
-	// Hash function for 4 bytes
+	// Hash function for 4 or 5 bytes, keeping as many bits as the table
+	// needs
+	shift := 32 - hashBits
+	if len(hashTable) == smallHashSize {
+		shift = 32 - smallHashBits
+	}
+	five := cfg.hash5
 	hash := func(p int) int {
-		if p+4 > inLen {
-			return 0
+		if five {
+			return hash5(src, p, shift)
+		}
+		return hash4(src, p, shift)
+	}
+
+	// insert records p as the newest candidate for hash slot h
+	chain := cfg.chain
+	insert := func(p, h int) {
+		if chain != nil {
+			chain[p&windowMask] = hashTable[h]
 		}
-		v := uint32(src[p]) | uint32(src[p+1])<<8 | uint32(src[p+2])<<16 | uint32(src[p+3])<<24
-		return int((v * 0x1e35a7bd) >> (32 - hashBits) & hashMask)
+		hashTable[h] = p + base
+	}
+
+	// Next input position at which to check for cancellation
+	nextCheck := math.MaxInt
+	if cfg.ctx != nil {
+		nextCheck = ip
 	}
 
 	// Main compression loop
-	for ip < inLen-minMatch {
+	for ip < stop {
+		if ip >= nextCheck {
+			if err := cfg.ctx.Err(); err != nil {
+				s.op = op
+				return err
+			}
+			nextCheck = ip + ctxCheckInterval
+		}
+
 		h := hash(ip)
-		ref := hashTable[h]
-		hashTable[h] = ip
+		ref := hashTable[h] - base
+		insert(ip, h)
+		if chain != nil {
+			ref = longestCandidate(src, ip, ref, chain, base, cfg.depth)
+		}
 
 		offset := ip - ref
 
@@ -60,77 +223,179 @@ func Compress(src, dst []byte) (int, error) {
 		if offset > 0 && offset <= maxOffset && ref >= 0 && ip+4 <= inLen {
 			if src[ref] == src[ip] && src[ref+1] == src[ip+1] && src[ref+2] == src[ip+2] {
 				// Found a match - determine length
-				matchLen := 3
 				maxLen := inLen - ip
-				if maxLen > 264 { // Reasonable max for single match encoding
-					maxLen = 264
-				}
-				for matchLen < maxLen && src[ref+matchLen] == src[ip+matchLen] {
-					matchLen++
-				}
+				matchLen := 3 + commonLen(src, ref+3, ip+3, maxLen-3)
 
-				// Check if we can emit the pending literals before this match
-				// Mid-stream literal runs must be >= 4 bytes (or 0)
-				litLen := ip - litStart
-				if !isFirstOutput && litLen > 0 && litLen < 4 {
-					// Can't encode 1-3 literals mid-stream, skip this match
+				// Fall back to literals for matches the format cannot
+				// represent, so only a genuine overrun aborts compression
+				if !matchEncodable(offset, matchLen) || (cfg.encodable != nil && !cfg.encodable(offset, matchLen)) ||
+					(cfg.fastDecode && slowToDecode(offset, matchLen)) {
 					ip++
 					continue
 				}
 
-				// Check if emitting this match would leave 1-3 trailing literals
-				// Mid-stream literal runs must be >= 4 bytes (encoded as 1-15)
-				remainingAfterMatch := inLen - (ip + matchLen)
-				if remainingAfterMatch > 0 && remainingAfterMatch < 4 {
-					// Skip this match - include these bytes in the literal run
-					ip++
-					continue
+				// Lazy matching: if the next position starts a longer
+				// match, emit this byte as a literal and take that one
+				if cfg.lazy && ip+1 < inLen-minMatch {
+					next := hashTable[hash(ip+1)] - base
+					if next >= 0 && next < ip+1 && ip+1-next <= maxOffset &&
+						commonLen(src, next, ip+1, maxLen-1) > matchLen {
+						ip++
+						continue
+					}
 				}
 
 				// Emit pending literals first
-				if litLen > 0 {
-					n, err := emitLiterals(src[litStart:ip], dst[op:], isFirstOutput)
+				if ip > litStart {
+					n, err := emitPendingLiterals(src[litStart:ip], dst[op:], state)
 					if err != nil {
-						return op, err
+						s.op = op
+						return err
 					}
 					op += n
+					if cfg.stats != nil {
+						cfg.stats.addLiterals(ip - litStart)
+					}
 				}
 
 				// Emit match
 				n, err := emitMatch(dst[op:], offset, matchLen)
 				if err != nil {
-					return op, err
+					s.op = op
+					return err
 				}
 				op += n
-				isFirstOutput = false // After any output (literals or match)
+				if cfg.stats != nil {
+					cfg.stats.addMatch(offset, matchLen)
+				}
+				state = &dst[op-1]
+				lastOff = offset
+				misses = 0
 
 				// Advance past the match
 				ip += matchLen
 				litStart = ip
 
-				// Update hash table for positions within the match
-				for i := ip - matchLen + 1; i < ip && i < inLen-4; i++ {
-					hashTable[hash(i)] = i
+				// Update hash table for positions within the match. In a
+				// match overlapping its source, such as a run of zeros,
+				// the 4 bytes at each position repeat offset bytes later,
+				// whose entry replaces it: without chains, only the last
+				// offset+3 positions can change the table
+				i, end := ip-matchLen+1, min(ip, inLen-4)
+				if chain == nil && offset < matchLen {
+					i = max(i, end-offset-3)
+				}
+				for ; i < end; i++ {
+					insert(i, hash(i))
+				}
+				continue
+			}
+		}
+
+		// No 3-byte match: directly after 1-3 literals a 2-byte M1 match
+		// costs no more than the literals and ends their run early
+		if litLen := ip - litStart; litLen > 0 && litLen < 4 && !cfg.minMatch4 {
+			if off := m1Offset(src, ip, offset, lastOff); off > 0 {
+				n, err := emitPendingLiterals(src[litStart:ip], dst[op:], state)
+				if err != nil {
+					s.op = op
+					return err
+				}
+				op += n
+
+				n, err = emitMatch(dst[op:], off, 2)
+				if err != nil {
+					s.op = op
+					return err
+				}
+				op += n
+				if cfg.stats != nil {
+					cfg.stats.addLiterals(litLen)
+					cfg.stats.addMatch(off, 2)
 				}
+				state = &dst[op-1]
+				lastOff = off
+				misses = 0
+
+				ip += 2
+				litStart = ip
+				insert(ip-1, hash(ip-1))
 				continue
 			}
 		}
 
 		ip++
+		if cfg.accel > 0 {
+			// Data that keeps missing is likely incompressible: skip
+			// ahead, leaving the skipped bytes in the literal run
+			ip += cfg.accel - 1 + misses>>accelShift
+			misses++
+		}
 	}
 
-	// Handle remaining bytes as literals
-	litLen := inLen - litStart
-	if litLen > 0 {
-		n, err := emitLiterals(src[litStart:], dst[op:], isFirstOutput)
+	s.ip, s.op, s.litStart, s.state, s.lastOff = ip, op, litStart, state, lastOff
+	return nil
+}
+
+// Shortest matches kept by ProfileFastDecode. Every token costs the
+// decoder a dispatch, so a short match takes longer to decode than the
+// literals it replaces, which are copied in one run with their neighbours.
+// A match overlapping its own output is copied a byte at a time for up
+// to its first 8 bytes, which only a longer match amortizes.
+const (
+	fastDecodeMinMatch   = 6
+	fastDecodeMinOverlap = 16
+)
+
+// slowToDecode reports whether ProfileFastDecode keeps a match as literals.
+func slowToDecode(offset, length int) bool {
+	return length < fastDecodeMinMatch || (offset < length && length < fastDecodeMinOverlap)
+}
+
+// hash5 hashes the 5 bytes at src[p:] like hash4, mixing in the fifth,
+// or returns 0 when fewer than 5 bytes remain. Equal hashes then no longer
+// imply an equal fourth byte, so 3-byte matches can turn up.
+//
+// It is kept out of line so the hash closure of compressScan stays cheap
+// enough to inline at every call site for the default hash4.
+//
+//go:noinline
+func hash5(src []byte, p, shift int) int {
+	if p+5 > len(src) {
+		return 0
+	}
+	v := uint32(src[p]) | uint32(src[p+1])<<8 | uint32(src[p+2])<<16 | uint32(src[p+3])<<24
+	v ^= uint32(src[p+4]) * 0x9e3779b1
+	return int((v * 0x1e35a7bd) >> shift)
+}
+
+// hash4 hashes the 4 bytes at src[p:] to a table index of 32-shift bits,
+// or returns 0 when fewer than 4 bytes remain.
+func hash4(src []byte, p, shift int) int {
+	if p+4 > len(src) {
+		return 0
+	}
+	v := uint32(src[p]) | uint32(src[p+1])<<8 | uint32(src[p+2])<<16 | uint32(src[p+3])<<24
+	return int((v * 0x1e35a7bd) >> shift)
+}
+
+// compressFinish emits the literals still pending after compressScan
+// reached the end of src, and the EOF marker.
+func compressFinish(src, dst []byte, s *compressState, stats *Stats) (int, error) {
+	op := s.op
+	if len(src) > s.litStart {
+		n, err := emitPendingLiterals(src[s.litStart:], dst[op:], s.state)
 		if err != nil {
 			return op, err
 		}
 		op += n
+		if stats != nil {
+			stats.addLiterals(len(src) - s.litStart)
+		}
 	}
 
 	// Emit EOF marker: 0x11 0x00 0x00
-	if op+3 > outLen {
+	if op+3 > len(dst) {
 		return op, ErrOutputOverrun
 	}
 	dst[op] = 0x11
@@ -169,102 +434,174 @@ func emitLiterals(lit, dst []byte, isFirst bool) (int, error) {
 		return 0, nil
 	}
 
-	op := 0
 	litLen := len(lit)
+	op, err := emitLiteralHeader(litLen, dst, isFirst)
+	if err != nil {
+		return op, err
+	}
+
+	// Copy literal bytes
+	if op+litLen > len(dst) {
+		return op, ErrOutputOverrun
+	}
+	copy(dst[op:], lit)
+	op += litLen
+
+	return op, nil
+}
+
+// emitPendingLiterals writes the literal run lit, which follows the match
+// whose last offset byte is *state, or starts the output if state is nil.
+func emitPendingLiterals(lit, dst []byte, state *byte) (int, error) {
+	op, err := emitPendingHeader(len(lit), dst, state)
+	if err != nil {
+		return op, err
+	}
+	if op+len(lit) > len(dst) {
+		return op, ErrOutputOverrun
+	}
+	return op + copy(dst[op:], lit), nil
+}
+
+// emitPendingHeader is emitLiteralHeader for a run following the match
+// whose last offset byte is *state (nil at the start of output). Runs of
+// 1-3 bytes after a match have no opcode of their own: their length is
+// stored in the low two bits of *state and nothing is written to dst.
+func emitPendingHeader(litLen int, dst []byte, state *byte) (int, error) {
+	if state != nil && litLen < 4 {
+		*state |= byte(litLen)
+		return 0, nil
+	}
+	return emitLiteralHeader(litLen, dst, state == nil)
+}
+
+// emitLiteralHeader writes the opcode bytes announcing a literal run of
+// litLen bytes. The literal bytes themselves are written by the caller.
+func emitLiteralHeader(litLen int, dst []byte, isFirst bool) (int, error) {
+	op := 0
 	outLen := len(dst)
 
-	if isFirst && litLen <= 238 {
-		// First literal run: length encoded as (len + 17) if len <= 3
-		// or (len - 3) if len >= 4, preceded by 0x00 if len >= 16
-		if litLen <= 3 {
-			// Encode as (len + 17)
-			if op+1+litLen > outLen {
-				return op, ErrOutputOverrun
-			}
-			dst[op] = byte(litLen + 17)
-			op++
-		} else if litLen <= 18 {
-			// Length 4-18: encode as (len - 3)
-			if op+1+litLen > outLen {
-				return op, ErrOutputOverrun
-			}
-			dst[op] = byte(litLen - 3)
-			op++
-		} else {
-			// Length > 18: need extended encoding
-			// 0x00 followed by (len - 18) in multi-byte format
-			if op+2+litLen > outLen {
-				return op, ErrOutputOverrun
-			}
-			remaining := litLen - 18
-			if remaining <= 255 {
-				dst[op] = 0x00
-				dst[op+1] = byte(remaining)
-				op += 2
-			} else {
-				// Very long literal run
-				dst[op] = 0x00
-				op++
-				for remaining > 255 {
-					if op >= outLen {
-						return op, ErrOutputOverrun
-					}
-					dst[op] = 0x00
-					op++
-					remaining -= 255
-				}
-				if op >= outLen {
-					return op, ErrOutputOverrun
-				}
-				dst[op] = byte(remaining)
-				op++
-			}
+	if isFirst && litLen <= 3 {
+		// Only the first opcode can be a run of (t - 17) literals for
+		// t > 17. liblzo2 uses it for first runs of up to 238 bytes; this
+		// encoder only needs it for 1-3, which have no other encoding
+		if op+1+litLen > outLen {
+			return op, ErrOutputOverrun
 		}
+		dst[op] = byte(litLen + 17)
+		op++
+	} else if litLen <= 18 {
+		// 0-15 for lengths 3-18 (value = len - 3), first run or not
+		if op+1+litLen > outLen {
+			return op, ErrOutputOverrun
+		}
+		dst[op] = byte(litLen - 3)
+		op++
 	} else {
-		// Non-first literal run (after a match)
-		// Uses same encoding as first literal run:
-		// - 0-15 for lengths 3-18 (value = len - 3)
-		// - 0x00 + extra bytes for longer runs
-		if litLen <= 18 {
-			if op+1+litLen > outLen {
+		// Extended literal encoding: 0x00 followed by (len - 18). A first
+		// run over 238 bytes has no single-byte form, and liblzo2 encodes
+		// it this way too
+		if op+2+litLen > outLen {
+			return op, ErrOutputOverrun
+		}
+		remaining := litLen - 18
+		dst[op] = 0x00
+		op++
+		for remaining > 255 {
+			if op >= outLen {
 				return op, ErrOutputOverrun
 			}
-			dst[op] = byte(litLen - 3)
-			op++
-		} else {
-			// Extended literal encoding: 0x00 followed by (len - 18)
-			remaining := litLen - 18
 			dst[op] = 0x00
 			op++
-			for remaining > 255 {
-				if op >= outLen {
-					return op, ErrOutputOverrun
-				}
-				dst[op] = 0x00
-				op++
-				remaining -= 255
-			}
-			if op >= outLen {
-				return op, ErrOutputOverrun
+			remaining -= 255
+		}
+		if op >= outLen {
+			return op, ErrOutputOverrun
+		}
+		dst[op] = byte(remaining)
+		op++
+	}
+
+	return op, nil
+}
+
+// longestCandidate walks the hash chain from ref, examining up to depth
+// positions within maxOffset of ip, and returns the one sharing the most
+// bytes with ip (the nearest on ties). It returns ref when no candidate
+// matches at all; the caller still verifies the match.
+func longestCandidate(src []byte, ip, ref int, chain []int, base, depth int) int {
+	maxLen := len(src) - ip
+	best, bestLen := ref, 0
+	for cand := ref; depth > 0 && cand >= 0 && ip-cand <= maxOffset; depth-- {
+		if n := commonLen(src, cand, ip, maxLen); n > bestLen {
+			best, bestLen = cand, n
+			if n == maxLen {
+				break
 			}
-			dst[op] = byte(remaining)
-			op++
 		}
+		next := chain[cand&windowMask] - base
+		if next >= cand {
+			break
+		}
+		cand = next
 	}
+	return best
+}
 
-	// Copy literal bytes
-	if op+litLen > outLen {
-		return op, ErrOutputOverrun
+// commonLen returns how many bytes, up to limit, src[a:] and src[b:] have
+// in common.
+func commonLen(src []byte, a, b, limit int) int {
+	n := 0
+	for n+8 <= limit {
+		if x := binary.LittleEndian.Uint64(src[a+n:]) ^ binary.LittleEndian.Uint64(src[b+n:]); x != 0 {
+			return n + bits.TrailingZeros64(x)>>3
+		}
+		n += 8
 	}
-	copy(dst[op:], lit)
-	op += litLen
+	for n < limit && src[a+n] == src[b+n] {
+		n++
+	}
+	return n
+}
 
-	return op, nil
+// m1Offset returns the first of the candidate offsets at which the two
+// bytes at ip repeat within M1 range, or 0 if neither does.
+func m1Offset(src []byte, ip, off1, off2 int) int {
+	for _, off := range [2]int{off1, off2} {
+		if off >= 1 && off <= m1MaxOffset && off <= ip &&
+			src[ip-off] == src[ip] && src[ip-off+1] == src[ip+1] {
+			return off
+		}
+	}
+	return 0
 }
 
+// matchEncodable reports whether emitMatch can represent a match with the
+// given offset and length. It mirrors the branch conditions of emitMatch.
+func matchEncodable(offset, length int) bool {
+	if length == 2 {
+		return offset >= 1 && offset <= m1MaxOffset
+	}
+	if length < 3 || offset < 1 {
+		return false
+	}
+	// M2 and M3 cover offsets up to 0x4000 and M4 the rest, so every
+	// offset the match search accepts is encodable
+	return offset <= maxOffset
+}
+
+// ErrUnencodableMatch is returned when an offset and length pair has no
+// LZO1Z match encoding. The compressor never emits such matches, so it
+// indicates a bug in the match search rather than a small buffer.
+var ErrUnencodableMatch = errors.New("lzo1z: match offset and length not encodable")
+
 // emitMatch writes a match (offset, length) to dst.
-// Returns bytes written.
+// Returns bytes written, or ErrUnencodableMatch if matchEncodable rejects
+// the match whatever the size of dst.
 func emitMatch(dst []byte, offset, length int) (int, error) {
+	if !matchEncodable(offset, length) {
+		return 0, ErrUnencodableMatch
+	}
 	if len(dst) < 4 {
 		return 0, ErrOutputOverrun
 	}
@@ -272,6 +609,7 @@ func emitMatch(dst []byte, offset, length int) (int, error) {
 	op := 0
 
 	// LZO1Z match encoding:
+	// M1: length 2, offset 1-1024 (0x400), only directly after 1-3 literals
 	// M2: length 3-4, offset 1-1792 (0x700)
 	// M3: length 3-33, offset 1-16384 (0x4000)
 	// M4: length 3-9 (extendable), offset 16385-49151
@@ -279,7 +617,17 @@ func emitMatch(dst []byte, offset, length int) (int, error) {
 	// Offset encoding for LZO1Z: (byte0 << 6) | (byte1 >> 2)
 	// So: byte0 = (offset - 1) >> 6, byte1 = ((offset - 1) & 0x3f) << 2
 
-	if length >= 3 && length <= 4 && offset >= 1 && offset <= 0x700 {
+	if length == 2 && offset >= 1 && offset <= m1MaxOffset {
+		// M1 match: 2 bytes
+		// Format: 0b0000OOOO 0bOOOOOOTT
+		// Decoded as a 2-byte copy only when the previous opcode left 1-3
+		// trailing literals; the caller must ensure that context.
+		off := offset - 1
+		dst[op] = byte(off >> 6)
+		dst[op+1] = byte((off & 0x3f) << 2)
+		op += 2
+
+	} else if length >= 3 && length <= 4 && offset >= 1 && offset <= 0x700 {
 		// M2 match: 2 bytes
 		// Format: 0b01LXXXXX 0bOOOOOOTT
 		// L = length - 2 (0 or 1, so length 3-4 maps to 1-2, stored as 0-1... wait)
@@ -312,9 +660,12 @@ func emitMatch(dst []byte, offset, length int) (int, error) {
 			op++
 		} else {
 			// Extended length
+			remaining := length - 2 - 31
+			if extendedLenSize(remaining)+3 > len(dst) {
+				return 0, ErrOutputOverrun
+			}
 			dst[op] = 0x20 // L = 0
 			op++
-			remaining := length - 2 - 31
 			for remaining > 255 {
 				dst[op] = 0x00
 				op++
@@ -328,7 +679,7 @@ func emitMatch(dst []byte, offset, length int) (int, error) {
 		dst[op+1] = byte(offByte1)
 		op += 2
 
-	} else if offset > 0x4000 && offset <= 0xbfff {
+	} else if length >= 3 && offset > 0x4000 && offset <= 0xbfff {
 		// M4 match: 3+ bytes, large offset
 		// Format: 0b0001HLLL [0x00...] 0bOOOOOOOO 0bOOOOOOTT
 		// H = high bit of offset (adds 0x4000 to offset)
@@ -345,9 +696,12 @@ func emitMatch(dst []byte, offset, length int) (int, error) {
 			dst[op] = byte(0x10 | offHigh | lenCode)
 			op++
 		} else {
+			remaining := length - 2 - 7
+			if extendedLenSize(remaining)+3 > len(dst) {
+				return 0, ErrOutputOverrun
+			}
 			dst[op] = byte(0x10 | offHigh) // L = 0
 			op++
-			remaining := length - 2 - 7
 			for remaining > 255 {
 				dst[op] = 0x00
 				op++
@@ -362,18 +716,194 @@ func emitMatch(dst []byte, offset, length int) (int, error) {
 		op += 2
 
 	} else {
-		return 0, ErrOutputOverrun // Can't encode this match
+		return 0, ErrUnencodableMatch // Unreachable: rejected by matchEncodable
 	}
 
 	return op, nil
 }
 
+// extendedLenSize returns the number of bytes encoding an extended length
+// remainder n >= 1: a 0x00 byte per 255 beyond the first, then the rest.
+func extendedLenSize(n int) int {
+	return (n-1)/255 + 1
+}
+
 // MaxCompressedSize returns the maximum possible compressed size for input of length n.
 // Use this to allocate the destination buffer.
+//
+// The bound is liblzo2's n + n/16 + 64 + 3, so buffers sized for the C
+// library fit here too. The compressor stays well inside it. Literal runs
+// of 4 or more bytes cost a header byte, a second one beyond 18 literals
+// and one more per further 255. Every such run after the first follows a
+// match of at least 4 bytes that saves a byte, so the worst case is one
+// extra byte per 23 input bytes: a 4-byte match and 19 literals.
+//
+// It panics if the size does not fit in an int, which can only happen for
+// inputs within about 6% of the largest int, e.g. near 2 GiB on 32-bit
+// platforms; no buffer that large could be allocated anyway.
 func MaxCompressedSize(n int) int {
+	size, ok := maxCompressedSize(n, math.MaxInt)
+	if !ok {
+		panic("lzo1z: MaxCompressedSize: input too large")
+	}
+	return size
+}
+
+// CompressBound is MaxCompressedSize under the name used by other
+// compression libraries for the worst-case output size.
+func CompressBound(srcLen int) int {
+	return MaxCompressedSize(srcLen)
+}
+
+// maxCompressedSize implements MaxCompressedSize, reporting false when the
+// size would exceed limit.
+func maxCompressedSize(n, limit int) (int, bool) {
 	if n == 0 {
-		return 3 // Just EOF marker
+		return 3, true // Just EOF marker
 	}
 	// Worst case: all literals + overhead + EOF
-	return n + n/16 + 64 + 3
+	extra := n/16 + 64 + 3
+	if n > limit-extra {
+		return 0, false
+	}
+	return n + extra, true
+}
+
+// CompressAppend compresses src and appends the compressed stream to dst,
+// returning the extended slice. dst is grown to fit the worst case
+// (MaxCompressedSize) when its spare capacity is smaller, and the
+// compressor writes straight into it, so a header already in dst is
+// followed by the payload without an extra copy.
+// On error dst is returned unextended.
+func CompressAppend(dst, src []byte) ([]byte, error) {
+	base := len(dst)
+	need := MaxCompressedSize(len(src))
+	if cap(dst)-base < need {
+		grown := make([]byte, base, base+need)
+		copy(grown, dst)
+		dst = grown
+	}
+
+	n, err := Compress(src, dst[base:base+need])
+	if err != nil {
+		return dst[:base], err
+	}
+	return dst[:base+n], nil
+}
+
+// MustCompress compresses src into scratch and returns the compressed
+// stream. If scratch has at least MaxCompressedSize(len(src)) capacity the
+// result shares its backing array; otherwise (including a nil scratch) a
+// new buffer is allocated. Callers can keep the returned slice as the
+// scratch for their next call to avoid repeated allocation.
+//
+// MustCompress panics if compression fails, which cannot happen with a
+// correctly sized buffer.
+func MustCompress(src, scratch []byte) []byte {
+	need := MaxCompressedSize(len(src))
+	if cap(scratch) < need {
+		scratch = make([]byte, need)
+	}
+	scratch = scratch[:cap(scratch)]
+
+	n, err := Compress(src, scratch)
+	if err != nil {
+		panic("lzo1z: MustCompress: " + err.Error())
+	}
+	return scratch[:n]
+}
+
+// CompressFit compresses src into a fixed-size dst, falling back to storing
+// src verbatim when the compressed stream does not fit.
+// Returns the number of bytes written and whether src was stored raw.
+// A stored result is not an LZO1Z stream; the caller must record the flag
+// and copy stored bytes back instead of decompressing them.
+//
+// ErrOutputOverrun is returned only if neither form fits in dst, so a dst
+// of at least len(src) bytes never overruns.
+func CompressFit(src, dst []byte) (int, bool, error) {
+	n, err := Compress(src, dst)
+	if err == nil {
+		return n, false, nil
+	}
+	if err != ErrOutputOverrun {
+		return 0, false, err
+	}
+	if len(src) > len(dst) {
+		return 0, false, ErrOutputOverrun
+	}
+	return copy(dst, src), true, nil
+}
+
+// Mode bytes that start the output of CompressInto.
+const (
+	modeCompressed = 0x00 // an LZO1Z stream follows
+	modeStored     = 0x01 // the input follows verbatim
+)
+
+// CompressInto compresses src into dst behind a 1-byte mode flag, storing
+// src verbatim instead when its compressed stream would be longer, and
+// returns the number of bytes written and whether src was stored. Unlike
+// CompressFit the flag is part of the output, which DecompressInto reads
+// back, so the output is self-describing and never more than
+// len(src)+1 bytes.
+//
+// A dst of len(src)+1 bytes always suffices; a smaller one returns
+// ErrOutputOverrun when src compresses to no fewer bytes than it has.
+func CompressInto(dst, src []byte) (n int, stored bool, err error) {
+	if len(dst) == 0 {
+		return 0, false, ErrOutputOverrun
+	}
+	n, stored, err = CompressFit(src, dst[1:1+min(len(dst)-1, len(src))])
+	if err != nil {
+		return 0, false, err
+	}
+	dst[0] = modeCompressed
+	if stored {
+		dst[0] = modeStored
+	}
+	return 1 + n, stored, nil
+}
+
+// CompressBudget compresses the longest prefix of src whose stream fits in
+// maxOut bytes, e.g. to fill a packet of fixed size, and returns the
+// length of that prefix and the number of bytes written to dst. The output
+// is a complete stream, ending in its EOF marker, that decompresses to
+// src[:consumed]; consumed is 0 when not even one byte fits.
+//
+// maxOut is capped at len(dst). When all of src does not fit, the prefix
+// is found by binary search, compressing O(log len(src)) candidates, so
+// the cost is a few times that of Compress; src[:consumed+1] never fits.
+func CompressBudget(src, dst []byte, maxOut int) (consumed, written int, err error) {
+	dst = dst[:min(max(maxOut, 0), len(dst))]
+
+	// Repeated attempts reuse one hash table, producing Compress's output
+	var c Compressor
+	n, err := c.Compress(src, dst)
+	if err != ErrOutputOverrun {
+		if err != nil {
+			return 0, 0, err
+		}
+		return len(src), n, nil
+	}
+
+	lo, hi := 0, len(src) // src[:lo] fits, src[:hi] does not
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		_, err := c.Compress(src[:mid], dst)
+		switch err {
+		case nil:
+			lo = mid
+		case ErrOutputOverrun:
+			hi = mid
+		default:
+			return 0, 0, err
+		}
+	}
+	// dst holds the last attempt, which need not be src[:lo]
+	n, err = c.Compress(src[:lo], dst)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lo, n, nil
 }
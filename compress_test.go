@@ -2,6 +2,11 @@ package lzo1z
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
 	"testing"
 )
 
@@ -108,6 +113,63 @@ func TestMaxCompressedSize(t *testing.T) {
 	}
 }
 
+func TestCompressBound(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	compressors := map[string]func(src, dst []byte) (int, error){
+		"Compress": Compress,
+		"level3":   func(src, dst []byte) (int, error) { return CompressLevel(src, dst, 3) },
+		"accel": func(src, dst []byte) (int, error) {
+			c := NewCompressor()
+			c.Acceleration = 4
+			return c.Compress(src, dst)
+		},
+	}
+	check := func(name string, input []byte) {
+		t.Helper()
+		bound := CompressBound(len(input))
+		if bound != MaxCompressedSize(len(input)) {
+			t.Fatalf("CompressBound(%d) = %d, MaxCompressedSize %d", len(input), bound, MaxCompressedSize(len(input)))
+		}
+		for cname, compress := range compressors {
+			dst := make([]byte, bound)
+			n, err := compress(input, dst)
+			if err != nil {
+				t.Fatalf("%s/%s %d bytes: %v within CompressBound %d", name, cname, len(input), err, bound)
+			}
+			// One byte in 23, plus the EOF marker and first run header
+			if limit := len(input) + len(input)/23 + 6; n > limit {
+				t.Errorf("%s/%s %d bytes: compressed to %d, beyond the worst case %d", name, cname, len(input), n, limit)
+			}
+			out := make([]byte, len(input))
+			if m, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out[:m], input) {
+				t.Fatalf("%s/%s %d bytes: roundtrip failed: %v", name, cname, len(input), err)
+			}
+		}
+	}
+
+	// Incompressible input: a single literal run
+	for _, size := range []int{0, 1, 3, 4, 18, 19, 273, 274, 529, 4096, 65536, 1<<20 + 7} {
+		input := make([]byte, size)
+		rng.Read(input)
+		check("random", input)
+	}
+
+	// 4-byte repeats in M4 range followed by runs of fresh bytes, so every
+	// run after a match pays its header
+	for _, run := range []int{4, 18, 19, 20, 273, 274} {
+		input := make([]byte, 0, 1<<18)
+		lit := make([]byte, run)
+		for len(input) < 1<<18 {
+			rng.Read(lit)
+			input = append(input, lit...)
+			if p := len(input) - m4MaxOffset - 1 - rng.Intn(0x4000); p >= 0 {
+				input = append(input, input[p:p+4]...)
+			}
+		}
+		check(fmt.Sprintf("run%d", run), input)
+	}
+}
+
 func BenchmarkCompress(b *testing.B) {
 	// Test with compressible data
 	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100)
@@ -121,6 +183,56 @@ func BenchmarkCompress(b *testing.B) {
 	}
 }
 
+func TestCompressRuns(t *testing.T) {
+	// Mostly zeros with a few values, as in sparse binary feeds
+	sparse := make([]byte, 1<<20)
+	for i := 0; i < len(sparse); i += 4093 {
+		sparse[i] = byte(i)
+		sparse[i+1] = byte(i >> 8)
+	}
+	periodic := bytes.Repeat([]byte("ab\x00\x00\x00xyz"), 1<<17)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		maxComp int
+	}{
+		{"zeros", make([]byte, 1<<20), 4200},
+		{"zeros_odd_length", make([]byte, 1<<20-3), 4200},
+		{"sparse", sparse, 8000},
+		{"periodic", periodic, 4200},
+	}
+	for _, tc := range tests {
+		for level := 1; level <= 3; level++ {
+			dst := make([]byte, MaxCompressedSize(len(tc.input)))
+			n, err := CompressLevel(tc.input, dst, level)
+			if err != nil {
+				t.Fatalf("%s level %d: CompressLevel failed: %v", tc.name, level, err)
+			}
+			if n > tc.maxComp {
+				t.Errorf("%s level %d: compressed to %d bytes, want at most %d", tc.name, level, n, tc.maxComp)
+			}
+			out := make([]byte, len(tc.input))
+			if m, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out[:m], tc.input) {
+				t.Errorf("%s level %d: roundtrip failed: %v", tc.name, level, err)
+			}
+		}
+	}
+}
+
+func BenchmarkCompressZeros(b *testing.B) {
+	// One long run, as in mostly-zero sparse binary data
+	input := make([]byte, 1<<20)
+	dst := make([]byte, MaxCompressedSize(len(input)))
+
+	b.ResetTimer()
+	b.SetBytes(int64(len(input)))
+
+	for i := 0; i < b.N; i++ {
+		_, _ = Compress(input, dst)
+	}
+}
+
 func BenchmarkCompressIncompressible(b *testing.B) {
 	// Test with random-like data (sequential bytes)
 	input := make([]byte, 4096)
@@ -225,3 +337,765 @@ func TestCompressVeryLongLiterals(t *testing.T) {
 		t.Errorf("Roundtrip failed for long literals test")
 	}
 }
+
+func TestMustCompress(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("Hello, World! Hello, World! Hello, World!"),
+		bytes.Repeat([]byte("ABCD"), 100),
+		{},
+		bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 50),
+	}
+
+	var scratch []byte
+	for i, input := range inputs {
+		out := MustCompress(input, scratch)
+
+		want := make([]byte, MaxCompressedSize(len(input)))
+		n, err := Compress(input, want)
+		if err != nil {
+			t.Fatalf("Compress failed: %v", err)
+		}
+		if !bytes.Equal(out, want[:n]) {
+			t.Errorf("input %d: MustCompress output differs from Compress", i)
+		}
+		scratch = out
+	}
+}
+
+func TestMustCompressReusesScratch(t *testing.T) {
+	input := bytes.Repeat([]byte("ABCD"), 100)
+	scratch := make([]byte, MaxCompressedSize(len(input)))
+
+	out := MustCompress(input, scratch)
+	if &out[0] != &scratch[0] {
+		t.Errorf("MustCompress did not reuse scratch")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		scratch = MustCompress(input, scratch)
+	})
+	if allocs != 0 {
+		t.Errorf("MustCompress allocated %.0f times per call, want 0", allocs)
+	}
+}
+
+func TestM4OffsetBoundary(t *testing.T) {
+	// Literals long enough to reach back 0xbfff, then one match
+	const histLen = 0xc000 + 16
+	hist := make([]byte, histLen)
+	for i := range hist {
+		hist[i] = byte(i*131 + i>>8)
+	}
+
+	for _, offset := range []int{0x4001, 0xbffe, 0xbfff} {
+		const length = 8
+		dst := make([]byte, MaxCompressedSize(histLen)+16)
+		n, err := emitLiterals(hist, dst, true)
+		if err != nil {
+			t.Fatalf("emitLiterals failed: %v", err)
+		}
+		m, err := emitMatch(dst[n:], offset, length)
+		if err != nil {
+			t.Fatalf("emitMatch(offset=%#x) failed: %v", offset, err)
+		}
+		n += m
+		n += copy(dst[n:], []byte{0x11, 0x00, 0x00})
+
+		out := make([]byte, histLen+length)
+		got, err := Decompress(dst[:n], out)
+		if err != nil {
+			t.Fatalf("offset %#x: Decompress failed: %v", offset, err)
+		}
+		if got != histLen+length {
+			t.Fatalf("offset %#x: got %d bytes, want %d", offset, got, histLen+length)
+		}
+		want := hist[histLen-offset : histLen-offset+length]
+		if !bytes.Equal(out[histLen:got], want) {
+			t.Errorf("offset %#x: match copied wrong bytes", offset)
+		}
+	}
+
+	// 0xc000 is beyond what M4 can represent
+	dst := make([]byte, 16)
+	if _, err := emitMatch(dst, 0xc000, 8); err == nil {
+		t.Errorf("emitMatch(offset=0xc000) succeeded, want error")
+	}
+}
+
+func TestM4DecodeMaxOffset(t *testing.T) {
+	// An M4 opcode with every offset bit set decodes to exactly 0xbfff
+	const histLen = 0xbfff
+	stream := append(literalRun(histLen), 0x19, 0xff, 0xfc, 0x11, 0x00, 0x00)
+
+	out := make([]byte, histLen+3)
+	n, err := Decompress(stream, out)
+	if err != nil {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+	if n != histLen+3 {
+		t.Fatalf("got %d bytes, want %d", n, histLen+3)
+	}
+	if !bytes.Equal(out[histLen:n], out[:3]) {
+		t.Errorf("offset 0xbfff match did not copy from output start")
+	}
+
+	// One byte less history puts the same match before the output start
+	stream = append(literalRun(histLen-1), 0x19, 0xff, 0xfc, 0x11, 0x00, 0x00)
+	if _, err := Decompress(stream, out); !errors.Is(err, ErrLookbehindOverrun) {
+		t.Errorf("expected ErrLookbehindOverrun, got %v", err)
+	}
+}
+
+func TestCompressNeverExceedsMaxOffset(t *testing.T) {
+	// A block repeated exactly 0xc000 bytes later must not be matched at
+	// that distance; repeats up to 0xbfff are, with M3 up to 0x4000 and
+	// M4 beyond
+	tests := []struct {
+		dist   int
+		m3, m4 bool
+	}{
+		{0x4000, true, false},
+		{0x4001, false, true},
+		{0xbfff, false, true},
+		{0xc000, false, false},
+	}
+	for _, tc := range tests {
+		// Noise without repeats of its own
+		input := make([]byte, tc.dist+64)
+		x := uint32(1)
+		for i := range input {
+			x ^= x << 13
+			x ^= x >> 17
+			x ^= x << 5
+			input[i] = byte(x >> 24)
+		}
+		copy(input[tc.dist:], input[:64])
+
+		for level := 1; level <= 3; level++ {
+			comp := make([]byte, MaxCompressedSize(len(input)))
+			n, err := CompressLevel(input, comp, level)
+			if err != nil {
+				t.Fatalf("dist %#x level %d: CompressLevel failed: %v", tc.dist, level, err)
+			}
+			out := make([]byte, len(input))
+			m, err := Decompress(comp[:n], out)
+			if err != nil {
+				t.Fatalf("dist %#x level %d: Decompress failed: %v", tc.dist, level, err)
+			}
+			if !bytes.Equal(out[:m], input) {
+				t.Errorf("dist %#x level %d: roundtrip mismatch", tc.dist, level)
+			}
+		}
+
+		_, st, err := CompressStats(input, make([]byte, MaxCompressedSize(len(input))))
+		if err != nil {
+			t.Fatalf("dist %#x: CompressStats failed: %v", tc.dist, err)
+		}
+		if (st.M3 > 0) != tc.m3 || (st.M4 > 0) != tc.m4 {
+			t.Errorf("dist %#x: emitted %d M3 and %d M4 matches, want M3 %v, M4 %v",
+				tc.dist, st.M3, st.M4, tc.m3, tc.m4)
+		}
+	}
+}
+
+func TestCompressFit(t *testing.T) {
+	random := make([]byte, 1000)
+	for i := range random {
+		random[i] = byte((i*7919)>>3 ^ i*31)
+	}
+	compressible := bytes.Repeat([]byte("ABCD"), 250)
+
+	tests := []struct {
+		name       string
+		input      []byte
+		dstLen     int
+		wantStored bool
+		wantErr    error
+	}{
+		{"compressible", compressible, len(compressible), false, nil},
+		{"incompressible_raw_fits", random, len(random), true, nil},
+		{"incompressible_nothing_fits", random, len(random) - 1, false, ErrOutputOverrun},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := make([]byte, tc.dstLen)
+			n, stored, err := CompressFit(tc.input, dst)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if stored != tc.wantStored {
+				t.Fatalf("stored = %v, want %v", stored, tc.wantStored)
+			}
+
+			if stored {
+				if !bytes.Equal(dst[:n], tc.input) {
+					t.Errorf("stored bytes differ from input")
+				}
+				return
+			}
+			out := make([]byte, len(tc.input))
+			m, err := Decompress(dst[:n], out)
+			if err != nil {
+				t.Fatalf("Decompress failed: %v", err)
+			}
+			if !bytes.Equal(out[:m], tc.input) {
+				t.Errorf("roundtrip mismatch")
+			}
+		})
+	}
+}
+
+func TestCompressInto(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	compressible := bytes.Repeat([]byte("ABCD"), 250)
+
+	tests := []struct {
+		name       string
+		input      []byte
+		dstLen     int
+		wantStored bool
+		wantErr    error
+	}{
+		{"compressible", compressible, len(compressible) + 1, false, nil},
+		{"compressible_small_dst", compressible, 20, false, nil},
+		{"random", random, len(random) + 1, true, nil},
+		{"random_dst_too_small", random, len(random), false, ErrOutputOverrun},
+		{"short", []byte("ab"), 3, true, nil},
+		{"empty", nil, 1, false, nil},
+		{"no_room_for_mode", nil, 0, false, ErrOutputOverrun},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := make([]byte, tc.dstLen)
+			n, stored, err := CompressInto(dst, tc.input)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if stored != tc.wantStored {
+				t.Errorf("stored = %v, want %v", stored, tc.wantStored)
+			}
+			if n > len(tc.input)+1 {
+				t.Errorf("wrote %d bytes for %d of input", n, len(tc.input))
+			}
+
+			out := make([]byte, len(tc.input))
+			m, err := DecompressInto(out, dst[:n])
+			if err != nil || !bytes.Equal(out[:m], tc.input) {
+				t.Errorf("roundtrip failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecompressIntoErrors(t *testing.T) {
+	random := make([]byte, 100)
+	rand.New(rand.NewSource(1)).Read(random)
+	stored := make([]byte, len(random)+1)
+	n, _, _ := CompressInto(stored, random)
+	stored = stored[:n]
+	compressed := make([]byte, 100)
+	n, _, _ = CompressInto(compressed, bytes.Repeat([]byte("ABCD"), 25))
+	compressed = compressed[:n]
+
+	if _, err := DecompressInto(make([]byte, 100), nil); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("empty: expected ErrInputOverrun, got %v", err)
+	}
+	if _, err := DecompressInto(make([]byte, 100), []byte{0x02, 0x11, 0x00, 0x00}); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("unknown mode: expected ErrCorrupted, got %v", err)
+	}
+	if m, err := DecompressInto(make([]byte, 99), stored); !errors.Is(err, ErrOutputOverrun) || m != 99 {
+		t.Errorf("stored, small dst: got (%d, %v), want (99, ErrOutputOverrun)", m, err)
+	}
+	if _, err := DecompressInto(make([]byte, 99), compressed); !errors.Is(err, ErrOutputOverrun) {
+		t.Errorf("compressed, small dst: expected ErrOutputOverrun, got %v", err)
+	}
+
+	// Positions count the mode byte
+	_, err := DecompressInto(make([]byte, 100), compressed[:len(compressed)-1])
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrInputOverrun || de.InputPos < 1 {
+		t.Errorf("cut stream: expected ErrInputOverrun past the mode byte, got %v", err)
+	}
+}
+
+func BenchmarkCompressTiny(b *testing.B) {
+	// Dominated by per-call setup such as hash table initialization
+	input := []byte("tiny payload: abcabcabcabc 0123")
+	dst := make([]byte, MaxCompressedSize(len(input)))
+
+	b.ResetTimer()
+	b.SetBytes(int64(len(input)))
+
+	for i := 0; i < b.N; i++ {
+		_, _ = Compress(input, dst)
+	}
+}
+
+func BenchmarkCompressSmall(b *testing.B) {
+	// 100 bytes: large enough to find matches, small enough that clearing
+	// a full-size hash table would dominate
+	input := []byte("GET /api/v1/items?id=42 HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\nUser-Agent: curl/8.0\r\nAccept-Encoding: gzip\r\n\r\n")[:100]
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		_, _ = Compress(input, dst)
+	}
+}
+
+func TestCompressSmallHashTable(t *testing.T) {
+	// Small inputs hash into a smaller table: the ratio must stay close to
+	// that of the full-size table, and the output must be identical for
+	// every way of compressing
+	var corpus []byte
+	for _, tc := range interopTestCases {
+		corpus = append(corpus, tc.input...)
+	}
+	corpus = append(corpus, bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 50)...)
+
+	c := NewCompressor()
+	for _, size := range []int{16, 64, 100, smallInputLen} {
+		small, full := 0, 0
+		dst := make([]byte, MaxCompressedSize(size))
+		for off := 0; off+size <= len(corpus); off += size {
+			input := corpus[off : off+size]
+			n, err := Compress(input, dst)
+			if err != nil {
+				t.Fatalf("Compress failed: %v", err)
+			}
+			want := bytes.Clone(dst[:n])
+			small += n
+
+			var hashTable [hashSize]int
+			fn, err := compressBlock(input, dst, hashTable[:], 1, compressConfig{})
+			if err != nil {
+				t.Fatalf("full-size table: %v", err)
+			}
+			full += fn
+
+			if cn, err := c.Compress(input, dst); err != nil || !bytes.Equal(dst[:cn], want) {
+				t.Fatalf("size %d at %d: Compressor output differs from Compress", size, off)
+			}
+		}
+		if small > full+full/100 {
+			t.Errorf("size %d: %d bytes with the small table, %d with the full one", size, small, full)
+		}
+	}
+}
+
+func TestCompressSmallInputsNoFalseMatches(t *testing.T) {
+	// Empty hash slots must never be taken as a match candidate, including
+	// for position 0 and all-zero data that hashes to the same slot
+	patterns := map[string]func(int) byte{
+		"zeros":    func(int) byte { return 0 },
+		"sequence": func(i int) byte { return byte(i) },
+		"period3":  func(i int) byte { return "abc"[i%3] },
+	}
+
+	for name, gen := range patterns {
+		for size := 4; size <= 64; size++ {
+			input := make([]byte, size)
+			for i := range input {
+				input[i] = gen(i)
+			}
+
+			dst := make([]byte, MaxCompressedSize(size))
+			n, err := Compress(input, dst)
+			if err != nil {
+				t.Fatalf("%s/%d: Compress failed: %v", name, size, err)
+			}
+			out := make([]byte, size)
+			m, err := Decompress(dst[:n], out)
+			if err != nil {
+				t.Fatalf("%s/%d: Decompress failed: %v", name, size, err)
+			}
+			if !bytes.Equal(out[:m], input) {
+				t.Errorf("%s/%d: roundtrip mismatch", name, size)
+			}
+		}
+	}
+}
+
+func TestCompressAppend(t *testing.T) {
+	header := []byte{0xde, 0xad, 0xbe, 0xef}
+	inputs := [][]byte{
+		[]byte("Hello, World! Hello, World! Hello, World!"),
+		bytes.Repeat([]byte("ABCD"), 1000),
+		{},
+		{'x'},
+	}
+
+	for i, input := range inputs {
+		pkt, err := CompressAppend(append([]byte{}, header...), input)
+		if err != nil {
+			t.Fatalf("input %d: CompressAppend failed: %v", i, err)
+		}
+		if !bytes.Equal(pkt[:len(header)], header) {
+			t.Errorf("input %d: header clobbered: %x", i, pkt[:len(header)])
+		}
+
+		out := make([]byte, len(input)+10)
+		n, err := Decompress(pkt[len(header):], out)
+		if err != nil {
+			t.Fatalf("input %d: Decompress failed: %v", i, err)
+		}
+		if !bytes.Equal(out[:n], input) {
+			t.Errorf("input %d: roundtrip mismatch", i)
+		}
+	}
+}
+
+func TestCompressAppendInPlace(t *testing.T) {
+	input := bytes.Repeat([]byte("ABCD"), 100)
+	buf := make([]byte, 4, 4+MaxCompressedSize(len(input)))
+
+	pkt, err := CompressAppend(buf, input)
+	if err != nil {
+		t.Fatalf("CompressAppend failed: %v", err)
+	}
+	if &pkt[0] != &buf[0] {
+		t.Errorf("CompressAppend reallocated despite sufficient capacity")
+	}
+}
+
+func TestCompressTrailingLiterals(t *testing.T) {
+	// A match followed by 1-3 literals carries the count in its last offset
+	// byte instead of being abandoned for a literal run
+	for _, tail := range []string{"", "1", "12", "123", "1234"} {
+		input := []byte("ABCDEFGHABCDEFGH" + tail)
+		literalOnly := 1 + len(input) + 3
+
+		dst := make([]byte, MaxCompressedSize(len(input)))
+		n, err := Compress(input, dst)
+		if err != nil {
+			t.Fatalf("%q: Compress failed: %v", input, err)
+		}
+		if n >= literalOnly {
+			t.Errorf("%q: compressed to %d bytes, want fewer than %d", input, n, literalOnly)
+		}
+
+		out := make([]byte, len(input))
+		m, err := Decompress(dst[:n], out)
+		if err != nil {
+			t.Fatalf("%q: Decompress failed: %v", input, err)
+		}
+		if !bytes.Equal(out[:m], input) {
+			t.Errorf("%q: roundtrip mismatch", input)
+		}
+	}
+
+	// 1-3 literals between two matches
+	input := []byte("ABCDEFGHABCDEFGHxyzABCDEFGH")
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	n, err := Compress(input, dst)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	out := make([]byte, len(input))
+	m, err := Decompress(dst[:n], out)
+	if err != nil || !bytes.Equal(out[:m], input) {
+		t.Errorf("mid-stream roundtrip failed: %v", err)
+	}
+}
+
+func TestCompressShortFirstLiterals(t *testing.T) {
+	// 1-3 literals starting the output are announced by a single len+17
+	// byte, which also tells the decoder a match follows
+	input := []byte("ABABABABABABABABABAB")
+	want := []byte{
+		0x13, 'A', 'B', // 2 literals
+		0x30, 0x00, 0x04, // M3: length 18, offset 2
+		0x11, 0x00, 0x00, // EOF
+	}
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	n, err := Compress(input, dst)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	if !bytes.Equal(dst[:n], want) {
+		t.Errorf("got  % x\nwant % x", dst[:n], want)
+	}
+
+	for _, lead := range []string{"A", "AB", "ABC"} {
+		input := bytes.Repeat([]byte(lead), 8)
+		dst := make([]byte, MaxCompressedSize(len(input)))
+		n, err := Compress(input, dst)
+		if err != nil {
+			t.Fatalf("%q: Compress failed: %v", input, err)
+		}
+		if dst[0] != byte(len(lead)+17) {
+			t.Errorf("%q: first byte %#x, want %#x", input, dst[0], len(lead)+17)
+		}
+		out := make([]byte, len(input))
+		m, err := Decompress(dst[:n], out)
+		if err != nil || !bytes.Equal(out[:m], input) {
+			t.Errorf("%q: roundtrip failed: %v", input, err)
+		}
+	}
+}
+
+func TestCompressLongFirstLiterals(t *testing.T) {
+	// A first run over 238 literals has no single-byte form, so liblzo2
+	// announces it like any later run: 0x00, one 0x00 per 255 bytes, then
+	// the rest of (len - 18). Its own output for 256 literals is a vector
+	for _, tc := range testCases {
+		if tc.name == "sequential_0_255" {
+			if got := MustCompress(tc.input, nil); !bytes.Equal(got, tc.compressed) {
+				t.Errorf("%s: got % x, liblzo2 has % x", tc.name, got[:3], tc.compressed[:3])
+			}
+		}
+	}
+
+	noise := make([]byte, 600)
+	x := uint32(1)
+	for i := range noise {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		noise[i] = byte(x)
+	}
+	for _, n := range []int{239, 256, 273, 274, 500, 528, 529} {
+		input := noise[:n]
+		want := []byte{0x00}
+		rest := n - 18
+		for ; rest > 255; rest -= 255 {
+			want = append(want, 0x00)
+		}
+		want = append(want, byte(rest))
+		want = append(append(want, input...), 0x11, 0x00, 0x00)
+
+		got := MustCompress(input, nil)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d literals: header % x, want % x", n, got[:min(len(got), 4)], want[:4])
+		}
+		out := make([]byte, n)
+		if m, err := Decompress(got, out); err != nil || !bytes.Equal(out[:m], input) {
+			t.Errorf("%d literals: roundtrip failed: %v", n, err)
+		}
+	}
+}
+
+func TestCompressM1(t *testing.T) {
+	// After the M3 match and one literal, "BC" repeats at the last match
+	// offset with no 3-byte match available: emitted as a 2-byte M1
+	input := []byte("ABCDEFGHABCDEFGHxBCyzwvu")
+	want := []byte{
+		0x05, 'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H', // 8 literals
+		0x26, 0x00, 0x1d, 'x', // M3: length 8, offset 8, 1 trailing literal
+		0x00, 0x1c, // M1: length 2, offset 8
+		0x02, 'y', 'z', 'w', 'v', 'u', // 5 literals
+		0x11, 0x00, 0x00, // EOF
+	}
+
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	n, err := Compress(input, dst)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	if !bytes.Equal(dst[:n], want) {
+		t.Errorf("got  % x\nwant % x", dst[:n], want)
+	}
+
+	for _, s := range []string{"ABxABxAB", "ABxABxABxyAByzABz", "0123456789012345678901x89qrstuvwxyz"} {
+		input := []byte(s)
+		dst := make([]byte, MaxCompressedSize(len(input)))
+		n, err := Compress(input, dst)
+		if err != nil {
+			t.Fatalf("%q: Compress failed: %v", s, err)
+		}
+		out := make([]byte, len(input))
+		m, err := Decompress(dst[:n], out)
+		if err != nil || !bytes.Equal(out[:m], input) {
+			t.Errorf("%q: roundtrip failed: %v", s, err)
+		}
+	}
+}
+
+func TestEmitMatchLongLengthOverrun(t *testing.T) {
+	// Extended lengths must be bounds checked, not just the opcode
+	for _, offset := range []int{1, 0x4001} {
+		dst := make([]byte, 10)
+		if _, err := emitMatch(dst, offset, 100000); !errors.Is(err, ErrOutputOverrun) {
+			t.Errorf("offset %#x: expected ErrOutputOverrun, got %v", offset, err)
+		}
+	}
+}
+
+func TestCompressFarRepeat(t *testing.T) {
+	// 2000 random bytes repeated 30000 bytes later, beyond M3 range, with
+	// random filler in between that must not shadow the first copy
+	rng := rand.New(rand.NewSource(2))
+	input := make([]byte, 32000)
+	rng.Read(input)
+	input = append(input, input[:2000]...)
+
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	n, st, err := CompressStats(input, dst)
+	if err != nil {
+		t.Fatalf("CompressStats failed: %v", err)
+	}
+	if st.M4 == 0 || st.MatchBytes < 1900 {
+		t.Errorf("far repeat not matched: %+v", st)
+	}
+	if n > len(input)-1800 {
+		t.Errorf("compressed to %d bytes, want the repeat encoded as a match", n)
+	}
+
+	c := NewCompressor()
+	c.SearchDepth = DefaultSearchDepth
+	cn, err := c.Compress(input, dst)
+	if err != nil {
+		t.Fatalf("Compressor.Compress failed: %v", err)
+	}
+	if cn > n {
+		t.Errorf("hash chains: %d bytes, more than greedy %d", cn, n)
+	}
+	out := make([]byte, len(input))
+	if m, err := Decompress(dst[:cn], out); err != nil || !bytes.Equal(out[:m], input) {
+		t.Errorf("roundtrip failed: %v", err)
+	}
+}
+
+func TestCompressZeroAllocs(t *testing.T) {
+	// The hash table lives on Compress's stack
+	for _, tc := range interopTestCases {
+		dst := make([]byte, MaxCompressedSize(tc.inputLen))
+		allocs := testing.AllocsPerRun(20, func() {
+			_, _ = Compress(tc.input, dst)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: Compress allocated %.0f times per call, want 0", tc.name, allocs)
+		}
+	}
+}
+
+func TestCompressBudget(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	text := bytes.Repeat([]byte("packets of a fixed size, "), 40)
+	noise := make([]byte, 300)
+	rng.Read(noise)
+	src := append(append(text, noise...), text...)
+	full := MustCompress(src, nil)
+
+	dst := make([]byte, len(full)+16)
+	for _, maxOut := range []int{0, 3, 4, 5, 6, 20, 64, 100, 200, 400, 500, len(full) - 1, len(full), len(full) + 16} {
+		consumed, written, err := CompressBudget(src, dst, maxOut)
+		if err != nil {
+			t.Fatalf("maxOut %d: CompressBudget failed: %v", maxOut, err)
+		}
+		if written > maxOut {
+			t.Fatalf("maxOut %d: wrote %d bytes", maxOut, written)
+		}
+		out := make([]byte, consumed)
+		if n, err := Decompress(dst[:written], out); err != nil || n != consumed || !bytes.Equal(out, src[:consumed]) {
+			t.Fatalf("maxOut %d: prefix of %d bytes does not decode: %v", maxOut, consumed, err)
+		}
+		if (consumed == len(src)) != (maxOut >= len(full)) {
+			t.Errorf("maxOut %d: consumed %d of %d, whole stream is %d bytes", maxOut, consumed, len(src), len(full))
+		}
+		if consumed < len(src) {
+			if _, err := Compress(src[:consumed+1], make([]byte, maxOut)); !errors.Is(err, ErrOutputOverrun) {
+				t.Errorf("maxOut %d: %d bytes would also fit", maxOut, consumed+1)
+			}
+		}
+	}
+
+	// maxOut is capped at len(dst)
+	if consumed, written, err := CompressBudget(src, dst[:50], 1000); err != nil || written > 50 || consumed == 0 {
+		t.Errorf("capped budget: consumed %d, wrote %d, %v", consumed, written, err)
+	}
+}
+
+// deterministicCorpus returns inputs covering literal runs, every match
+// type and both hash table sizes, generated without math/rand so they
+// cannot change between Go releases.
+func deterministicCorpus() [][]byte {
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 2000)
+	noise := make([]byte, 100000)
+	x := uint32(2463534242)
+	for i := range noise {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		noise[i] = byte(x)
+	}
+	mixed := make([]byte, 0, 200000)
+	for i := 0; len(mixed) < 200000; i++ {
+		mixed = append(mixed, text[i%45:i%45+i%60]...)
+		mixed = append(mixed, noise[i*37%len(noise):i*37%len(noise)+i%11]...)
+		if i%97 == 0 {
+			mixed = append(mixed, noise[:20000]...)
+		}
+	}
+	return [][]byte{text[:200], text, noise, mixed, make([]byte, 70000)}
+}
+
+func TestCompressDeterministic(t *testing.T) {
+	// The output of every level is pinned: any change to it, on any
+	// platform or Go version, breaks reproducible builds relying on it
+	want := []string{
+		1: "b6ecb1860ba15e061a37602b24653f61b899fbd0d72f2652e46db5888edc3037",
+		2: "ca30802f303d77551ee0a8aa5b6b877baa227466217062ea55dc06479a7f0f6f",
+		3: "3d6dbd184b245dcd85eeb278f2afaf884a511556fc058cab74aeb6e36100198f",
+	}
+	corpus := deterministicCorpus()
+	for level := 1; level <= 3; level++ {
+		h := sha256.New()
+		for _, input := range corpus {
+			dst := make([]byte, MaxCompressedSize(len(input)))
+			n, err := CompressLevel(input, dst, level)
+			if err != nil {
+				t.Fatalf("level %d: CompressLevel failed: %v", level, err)
+			}
+			h.Write(dst[:n])
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != want[level] {
+			t.Errorf("level %d: compressed corpus hash = %s, want %s", level, got, want[level])
+		}
+	}
+}
+
+func TestCompressAliasedBuffers(t *testing.T) {
+	input := bytes.Repeat([]byte("in place? "), 100)
+	buf := make([]byte, len(input)+MaxCompressedSize(len(input)))
+
+	tests := []struct {
+		name     string
+		src, dst []byte
+	}{
+		{"same", buf[:len(input)], buf},
+		{"dst_inside_src", buf[:len(input)], buf[10:20]},
+		{"src_after_dst", buf[100 : 100+len(input)], buf},
+		{"short_src", buf[:3], buf[2:]},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			copy(tc.src, input)
+			if _, err := Compress(tc.src, tc.dst); !errors.Is(err, ErrAliasedBuffers) {
+				t.Errorf("Compress: expected ErrAliasedBuffers, got %v", err)
+			}
+			if _, err := NewCompressor().Compress(tc.src, tc.dst); !errors.Is(err, ErrAliasedBuffers) {
+				t.Errorf("Compressor: expected ErrAliasedBuffers, got %v", err)
+			}
+		})
+	}
+
+	// Adjacent slices of one buffer do not overlap
+	src, dst := buf[:len(input)], buf[len(input):]
+	copy(src, input)
+	n, err := Compress(src, dst)
+	if err != nil {
+		t.Fatalf("adjacent buffers: Compress failed: %v", err)
+	}
+	out := make([]byte, len(input))
+	if _, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out, input) {
+		t.Errorf("adjacent buffers: roundtrip failed: %v", err)
+	}
+}
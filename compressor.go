@@ -0,0 +1,196 @@
+package lzo1z
+
+import "math"
+
+// Compressor is a reusable LZO1Z compressor. It keeps its hash table
+// between calls and invalidates it with a generation offset instead of
+// clearing 16K entries, which makes compressing many small buffers
+// considerably cheaper than calling Compress.
+//
+// A Compressor is not safe for concurrent use; keep one per goroutine.
+// With the default settings its output is byte-identical to Compress.
+//
+// Its memory does not depend on input size: 128 KiB of hash table, plus
+// a 512 KiB hash chain once SearchDepth above 1 is used, which is kept
+// until Reset. A Compressor used for a 10 MB input and then for a 100-byte
+// one holds the same memory throughout, so it is safe to pool.
+type Compressor struct {
+	// Lazy enables one-step lazy matching: a match is deferred by one
+	// byte when the next position starts a longer one. This improves the
+	// ratio on text at some cost in speed. The output remains a standard
+	// LZO1Z stream.
+	Lazy bool
+
+	// SearchDepth is the number of earlier positions with the same hash
+	// examined for the longest match. Values above 1 keep a hash chain
+	// (512 KiB, allocated on first use) and find longer matches on
+	// repetitive but shifted data; DefaultSearchDepth is a good balance
+	// of ratio and speed. Zero or 1 keeps the single-candidate search
+	// of Compress.
+	SearchDepth int
+
+	// Acceleration speeds up compression of data that rarely matches.
+	// After a position finds no match the search skips ahead by
+	// Acceleration bytes, and the step grows by one for every 64
+	// consecutive misses until the next match. Skipped bytes are stored
+	// as literals, so higher values trade ratio for speed. Zero searches
+	// every position, like Compress.
+	Acceleration int
+
+	// MinMatch is 3 or 4. At 4, the 2-byte M1 matches that can follow a
+	// short literal run are kept as literals instead, which can improve
+	// the ratio of binary data whose short matches are mostly chance.
+	// With the default HashLen, other matches are at least 4 bytes either
+	// way, as the hash search only finds candidates whose first 4 bytes
+	// agree. Values below 4 select the default of 3 and values above it 4.
+	MinMatch int
+
+	// HashLen is the number of bytes hashed to find match candidates, 4
+	// or 5. At 5 the single candidate per hash no longer mixes sequences
+	// that share 4 bytes but not the fifth, so text whose words and keys
+	// share 4-byte prefixes finds more of its longer matches: JSON or
+	// source code compresses 1-2% smaller, about a third more slowly.
+	// Binary data of small fixed-width integers usually does better at 4.
+	// The shortest match stays 3 bytes, though 3- and 4-byte matches are
+	// then found only by chance. Values below 5 select the default of 4
+	// and values above it 5.
+	HashLen int
+
+	// Profile biases match selection towards compression ratio, the
+	// default, or decode speed, see ProfileFastDecode.
+	Profile Profile
+
+	hashTable [hashSize]int
+	chain     []int // hash chain links, allocated when SearchDepth > 1
+	base      int   // positions are stored as pos+base, see compressBlock
+}
+
+// Profile selects what a Compressor optimizes for. Every profile produces
+// a standard LZO1Z stream.
+type Profile int
+
+const (
+	// ProfileDefault favors compression ratio; with the other settings at
+	// their defaults the output is identical to Compress.
+	ProfileDefault Profile = iota
+
+	// ProfileFastDecode favors decode speed for read-heavy data. It keeps
+	// only matches of at least 6 bytes, and at least 16 when they overlap
+	// their own output, and no 2-byte M1 matches, storing the rest as
+	// literals. The decoder then handles fewer, longer tokens, nearly all
+	// copied with the built-in copy. On this package's Go source it
+	// decodes about 30% faster for a ratio about 11% lower. Data whose
+	// matches are already long, such as repetitive logs, is unchanged,
+	// and data whose matches are mostly short is stored almost as
+	// literals: it decodes many times faster because it is barely
+	// compressed. BenchmarkDecompressProfile measures all three.
+	ProfileFastDecode
+)
+
+// DefaultSearchDepth is the suggested Compressor.SearchDepth for
+// hash-chain match search.
+const DefaultSearchDepth = 8
+
+// CompressLevel compresses src into dst like Compress, trading speed for
+// ratio according to level:
+//
+//	1: greedy matching, identical to Compress
+//	2: one-step lazy matching, as Compressor.Lazy
+//	3: lazy matching over hash chains searched to DefaultSearchDepth
+//
+// Levels below 1 are treated as 1 and levels above 3 as 3. Every level
+// produces a standard LZO1Z stream.
+func CompressLevel(src, dst []byte, level int) (int, error) {
+	var cfg compressConfig
+	if level >= 2 {
+		cfg.lazy = true
+	}
+	if level >= 3 {
+		cfg.chain = make([]int, windowSize)
+		cfg.depth = DefaultSearchDepth
+	}
+	return compressNew(src, dst, cfg)
+}
+
+// NewCompressor returns a ready-to-use Compressor.
+func NewCompressor() *Compressor {
+	c := &Compressor{}
+	c.Reset()
+	return c
+}
+
+// Compress compresses src into dst like the package-level Compress.
+func (c *Compressor) Compress(src, dst []byte) (int, error) {
+	if c.base < 1 || c.base > math.MaxInt-len(src)-1 {
+		// Zero-value Compressor, or the generation offset would overflow
+		c.Reset()
+	}
+	n, err := compressBlock(src, dst, c.hashTable[:hashTableLen(len(src))], c.base, c.config())
+	// Every position stored by this call is below the next base, so the
+	// next call sees the whole table as empty
+	c.base += len(src) + 1
+	return n, err
+}
+
+// config returns the compressConfig for c's settings, allocating the hash
+// chain on first use.
+func (c *Compressor) config() compressConfig {
+	cfg := compressConfig{lazy: c.Lazy, accel: c.Acceleration, minMatch4: c.MinMatch >= 4}
+	cfg.hash5 = c.HashLen >= 5
+	if c.Profile == ProfileFastDecode {
+		cfg.minMatch4 = true
+		cfg.fastDecode = true
+	}
+	if c.SearchDepth > 1 {
+		if c.chain == nil {
+			// Links are only reached through the hash table, so stale
+			// entries never need clearing
+			c.chain = make([]int, windowSize)
+		}
+		cfg.chain = c.chain
+		cfg.depth = c.SearchDepth
+	}
+	return cfg
+}
+
+// Reset clears the hash table and releases the hash chain, returning the
+// Compressor to its initial state. Compress never depends on earlier
+// calls, so Reset is only needed to drop references to old input
+// positions, or to release the chain of a Compressor that will no longer
+// search with SearchDepth above 1, e.g. before putting it in a sync.Pool.
+func (c *Compressor) Reset() {
+	c.hashTable = [hashSize]int{}
+	c.chain = nil
+	c.base = 1
+}
+
+// Scratch is caller-owned hash table storage for CompressScratch. It
+// carries its own generation offset next to the table, so tables never
+// contend on shared state and each one only clears itself when its own
+// offset is exhausted.
+//
+// The zero value is ready to use. A Scratch must not be used by two calls
+// at once.
+type Scratch struct {
+	table [hashSize]int
+	base  int
+}
+
+// CompressScratch compresses src into dst like Compress, using s as the
+// hash table. Like a Compressor, it does not clear the table between calls
+// but stores positions above a generation offset that it raises on every
+// call; the output is byte-identical to Compress.
+//
+// It suits callers that manage their own memory and would rather not have
+// Compress put 128 KiB on the goroutine stack, or keep a Compressor per
+// goroutine.
+func CompressScratch(src, dst []byte, s *Scratch) (int, error) {
+	if s.base < 1 || s.base > math.MaxInt-len(src)-1 {
+		// Zero-value Scratch, or the generation offset would overflow
+		clear(s.table[:])
+		s.base = 1
+	}
+	n, err := compressBlock(src, dst, s.table[:hashTableLen(len(src))], s.base, compressConfig{})
+	s.base += len(src) + 1
+	return n, err
+}
@@ -0,0 +1,685 @@
+package lzo1z
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressorMatchesCompress(t *testing.T) {
+	c := NewCompressor()
+
+	var inputs [][]byte
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+	inputs = append(inputs,
+		[]byte{},
+		[]byte("AB"),
+		bytes.Repeat([]byte("ABCD"), 100),
+		bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 50),
+	)
+
+	// Run twice so later inputs see a table full of stale entries
+	for round := 0; round < 2; round++ {
+		for i, input := range inputs {
+			want := make([]byte, MaxCompressedSize(len(input)))
+			wn, err := Compress(input, want)
+			if err != nil {
+				t.Fatalf("Compress failed: %v", err)
+			}
+
+			got := make([]byte, MaxCompressedSize(len(input)))
+			gn, err := c.Compress(input, got)
+			if err != nil {
+				t.Fatalf("Compressor.Compress failed: %v", err)
+			}
+			if !bytes.Equal(got[:gn], want[:wn]) {
+				t.Errorf("round %d input %d: output differs from Compress", round, i)
+			}
+		}
+	}
+}
+
+func TestCompressScratch(t *testing.T) {
+	var inputs [][]byte
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+	inputs = append(inputs,
+		[]byte{},
+		[]byte("AB"),
+		bytes.Repeat([]byte("ABCD"), 100),
+		bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 50),
+	)
+
+	// Reused across rounds, so later inputs see a table full of stale
+	// entries from both table sizes
+	scratch := new(Scratch)
+	for round := 0; round < 2; round++ {
+		for i, input := range inputs {
+			want := MustCompress(input, nil)
+			got := make([]byte, MaxCompressedSize(len(input)))
+			n, err := CompressScratch(input, got, scratch)
+			if err != nil {
+				t.Fatalf("CompressScratch failed: %v", err)
+			}
+			if !bytes.Equal(got[:n], want) {
+				t.Errorf("round %d input %d: output differs from Compress", round, i)
+			}
+		}
+	}
+
+	// A table written by another scratch user in between is still stale
+	other := new(Scratch)
+	input := inputs[len(inputs)-1]
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	CompressScratch(input, dst, other)
+	n, _ := CompressScratch(input[1:], dst, scratch)
+	if !bytes.Equal(dst[:n], MustCompress(input[1:], nil)) {
+		t.Errorf("interleaved tables: output differs from Compress")
+	}
+
+	// Exhausting one table's generation offset clears that table and
+	// starts over without touching the others
+	other.base = math.MaxInt - 100
+	for i := 0; i < 3; i++ {
+		n, err := CompressScratch(input, dst, other)
+		if err != nil || !bytes.Equal(dst[:n], MustCompress(input, nil)) {
+			t.Errorf("exhausted offset, call %d: output differs from Compress (%v)", i, err)
+		}
+	}
+	if other.base != 3*(len(input)+1)+1 {
+		t.Errorf("offset not reset on wrap: base = %d", other.base)
+	}
+	before := scratch.base
+	n, _ = CompressScratch(input, dst, scratch)
+	if !bytes.Equal(dst[:n], MustCompress(input, nil)) || scratch.base != before+len(input)+1 {
+		t.Errorf("other table's wrap affected scratch")
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		CompressScratch(input, dst, scratch)
+	})
+	if allocs != 0 {
+		t.Errorf("CompressScratch allocated %v times per call", allocs)
+	}
+}
+
+func TestCompressorZeroValue(t *testing.T) {
+	var c Compressor
+	input := bytes.Repeat([]byte("zero value "), 20)
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	n, err := c.Compress(input, dst)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	out := make([]byte, len(input))
+	m, err := Decompress(dst[:n], out)
+	if err != nil || !bytes.Equal(out[:m], input) {
+		t.Errorf("roundtrip failed: %v", err)
+	}
+}
+
+func TestCompressorBaseOverflow(t *testing.T) {
+	c := NewCompressor()
+	input := bytes.Repeat([]byte("overflow "), 20)
+	dst := make([]byte, MaxCompressedSize(len(input)))
+
+	if _, err := c.Compress(input, dst); err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	c.base = math.MaxInt - 10
+
+	want := make([]byte, MaxCompressedSize(len(input)))
+	wn, _ := Compress(input, want)
+	n, err := c.Compress(input, dst)
+	if err != nil {
+		t.Fatalf("Compress near overflow failed: %v", err)
+	}
+	if !bytes.Equal(dst[:n], want[:wn]) {
+		t.Errorf("output differs from Compress after base reset")
+	}
+	if c.base != len(input)+2 {
+		t.Errorf("base = %d, want table reset to %d", c.base, len(input)+2)
+	}
+}
+
+func TestCompressorLazy(t *testing.T) {
+	lorem := bytes.Repeat([]byte("Lorem ipsum dolor sit amet, consectetur adipiscing elit. "), 300)
+	// "abcd" at ip matches 4 bytes; "bcdefghijk" at ip+1 matches 10
+	shifted := []byte("bcdefghijk0123abcd----abcdefghijk")
+
+	inputs := [][]byte{lorem, shifted}
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+
+	greedy := NewCompressor()
+	lazy := NewCompressor()
+	lazy.Lazy = true
+	var greedyTotal, lazyTotal int
+	for i, input := range inputs {
+		dst := make([]byte, MaxCompressedSize(len(input)))
+		gn, err := greedy.Compress(input, dst)
+		if err != nil {
+			t.Fatalf("input %d: greedy Compress failed: %v", i, err)
+		}
+		ln, err := lazy.Compress(input, dst)
+		if err != nil {
+			t.Fatalf("input %d: lazy Compress failed: %v", i, err)
+		}
+
+		out := make([]byte, len(input))
+		m, err := Decompress(dst[:ln], out)
+		if err != nil || !bytes.Equal(out[:m], input) {
+			t.Fatalf("input %d: lazy roundtrip failed: %v", i, err)
+		}
+		greedyTotal += gn
+		lazyTotal += ln
+
+		switch i {
+		case 0:
+			if ln > gn {
+				t.Errorf("lorem: lazy %d bytes, greedy %d", ln, gn)
+			}
+			t.Logf("lorem: greedy %d bytes, lazy %d bytes", gn, ln)
+		case 1:
+			if ln >= gn {
+				t.Errorf("shifted: lazy %d bytes, want fewer than greedy %d", ln, gn)
+			}
+		}
+	}
+	if lazyTotal > greedyTotal {
+		t.Errorf("lazy total %d bytes exceeds greedy %d", lazyTotal, greedyTotal)
+	}
+	t.Logf("all inputs: greedy %d bytes, lazy %d bytes", greedyTotal, lazyTotal)
+}
+
+func TestCompressorSearchDepth(t *testing.T) {
+	// Each record's most recent "rec:" is followed by a different name,
+	// so only a chain search finds the longer match further back
+	var shifted bytes.Buffer
+	names := []string{"alpha-centauri", "beta-pictoris", "gamma-draconis", "delta-scuti", "epsilon-eridani"}
+	for i := 0; i < 400; i++ {
+		fmt.Fprintf(&shifted, "rec:%s;%s|", names[i%5], names[i*3%5])
+	}
+
+	inputs := [][]byte{shifted.Bytes(), {}, []byte("AB"), bytes.Repeat([]byte("ABCD"), 100)}
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+
+	for _, depth := range []int{0, 2, DefaultSearchDepth, 64} {
+		c := NewCompressor()
+		c.SearchDepth = depth
+		// Twice, so the second round runs over stale chain links
+		for round := 0; round < 2; round++ {
+			for i, input := range inputs {
+				dst := make([]byte, MaxCompressedSize(len(input)))
+				n, err := c.Compress(input, dst)
+				if err != nil {
+					t.Fatalf("depth %d input %d: Compress failed: %v", depth, i, err)
+				}
+				out := make([]byte, len(input))
+				m, err := Decompress(dst[:n], out)
+				if err != nil || !bytes.Equal(out[:m], input) {
+					t.Fatalf("depth %d input %d: roundtrip failed: %v", depth, i, err)
+				}
+			}
+		}
+	}
+
+	size := func(depth int) int {
+		c := NewCompressor()
+		c.SearchDepth = depth
+		dst := make([]byte, MaxCompressedSize(shifted.Len()))
+		n, err := c.Compress(shifted.Bytes(), dst)
+		if err != nil {
+			t.Fatalf("depth %d: Compress failed: %v", depth, err)
+		}
+		return n
+	}
+	single, chained := size(0), size(DefaultSearchDepth)
+	if chained >= single {
+		t.Errorf("shifted records: depth %d gives %d bytes, want fewer than single-slot %d",
+			DefaultSearchDepth, chained, single)
+	}
+	t.Logf("shifted records: single-slot %d bytes, depth %d %d bytes", single, DefaultSearchDepth, chained)
+}
+
+func tinyInputs() [][]byte {
+	inputs := make([][]byte, 10000)
+	for i := range inputs {
+		inputs[i] = []byte(fmt.Sprintf("msg %05d: abcabcabc 01234567890", i))
+	}
+	return inputs
+}
+
+func BenchmarkCompressTinyBuffers(b *testing.B) {
+	inputs := tinyInputs()
+	dst := make([]byte, MaxCompressedSize(32))
+
+	b.ResetTimer()
+	b.SetBytes(int64(32 * len(inputs)))
+	for i := 0; i < b.N; i++ {
+		for _, in := range inputs {
+			_, _ = Compress(in, dst)
+		}
+	}
+}
+
+func BenchmarkCompressorTinyBuffers(b *testing.B) {
+	inputs := tinyInputs()
+	dst := make([]byte, MaxCompressedSize(32))
+	c := NewCompressor()
+
+	b.ResetTimer()
+	b.SetBytes(int64(32 * len(inputs)))
+	for i := 0; i < b.N; i++ {
+		for _, in := range inputs {
+			_, _ = c.Compress(in, dst)
+		}
+	}
+}
+
+func BenchmarkCompressScratchTinyBuffers(b *testing.B) {
+	inputs := tinyInputs()
+	dst := make([]byte, MaxCompressedSize(32))
+	scratch := new(Scratch)
+
+	b.ResetTimer()
+	b.SetBytes(int64(32 * len(inputs)))
+	for i := 0; i < b.N; i++ {
+		for _, in := range inputs {
+			_, _ = CompressScratch(in, dst, scratch)
+		}
+	}
+}
+
+func BenchmarkCompressorSearchDepth(b *testing.B) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 400)
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	for _, depth := range []int{0, DefaultSearchDepth} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			c := NewCompressor()
+			c.SearchDepth = depth
+			b.SetBytes(int64(len(input)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = c.Compress(input, dst)
+			}
+		})
+	}
+}
+
+func TestCompressLevel(t *testing.T) {
+	lorem := bytes.Repeat([]byte("Lorem ipsum dolor sit amet, consectetur adipiscing elit. "), 300)
+	inputs := [][]byte{lorem}
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+
+	prevLorem, prevTotal := math.MaxInt, math.MaxInt
+	for _, level := range []int{0, 1, 2, 3, 4} {
+		total := 0
+		for i, input := range inputs {
+			dst := make([]byte, MaxCompressedSize(len(input)))
+			n, err := CompressLevel(input, dst, level)
+			if err != nil {
+				t.Fatalf("level %d input %d: CompressLevel failed: %v", level, i, err)
+			}
+			out := make([]byte, len(input))
+			if m, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out[:m], input) {
+				t.Fatalf("level %d input %d: roundtrip failed: %v", level, i, err)
+			}
+			if level == 1 && !bytes.Equal(dst[:n], MustCompress(input, nil)) {
+				t.Errorf("level 1 input %d: output differs from Compress", i)
+			}
+			if i == 0 {
+				if n > prevLorem {
+					t.Errorf("level %d: lorem %d bytes, more than %d at the level below", level, n, prevLorem)
+				}
+				prevLorem = n
+			}
+			total += n
+		}
+		if total > prevTotal {
+			t.Errorf("level %d: %d bytes in total, more than %d at the level below", level, total, prevTotal)
+		}
+		t.Logf("level %d: lorem %d bytes, total %d bytes", level, prevLorem, total)
+		prevTotal = total
+	}
+}
+
+func TestCompressorAcceleration(t *testing.T) {
+	c := NewCompressor()
+	c.Acceleration = 4
+	checkCompressorKnob(t, c, nil,
+		func(c *Compressor) { c.Acceleration = 0 },
+		func(c *Compressor) { c.Acceleration = -1 })
+}
+
+func BenchmarkCompressorAcceleration(b *testing.B) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	dst := make([]byte, MaxCompressedSize(len(random)))
+	for _, accel := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("accel=%d", accel), func(b *testing.B) {
+			c := NewCompressor()
+			c.Acceleration = accel
+			b.SetBytes(int64(len(random)))
+			for i := 0; i < b.N; i++ {
+				_, _ = c.Compress(random, dst)
+			}
+		})
+	}
+}
+
+func TestCompressorProfileFastDecode(t *testing.T) {
+	c := NewCompressor()
+	c.Profile = ProfileFastDecode
+	checkCompressorKnob(t, c, func(i int, comp []byte) {
+		err := DecodeOpcodes(comp, func(op OpInfo) bool {
+			if op.Offset > 0 && slowToDecode(op.Offset, op.Length) {
+				t.Errorf("input %d: %v match of %d bytes at offset %d", i, op.Kind, op.Length, op.Offset)
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("input %d: DecodeOpcodes failed: %v", i, err)
+		}
+	}, func(c *Compressor) { c.Profile = ProfileDefault }, func(c *Compressor) { c.Profile = -1 })
+}
+
+// sourceCorpus returns the package's own Go source, the text the
+// ProfileFastDecode documentation quotes figures for.
+func sourceCorpus(tb testing.TB) []byte {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var out []byte
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(f)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		out = append(out, data...)
+	}
+	return out
+}
+
+func BenchmarkDecompressProfile(b *testing.B) {
+	inputs := map[string][]byte{
+		"records": binaryRecords(10000),
+		"log":     parallelInput(),
+		"source":  sourceCorpus(b),
+	}
+	for name, input := range inputs {
+		for _, profile := range []Profile{ProfileDefault, ProfileFastDecode} {
+			c := NewCompressor()
+			c.Profile = profile
+			comp := make([]byte, MaxCompressedSize(len(input)))
+			n, _ := c.Compress(input, comp)
+			comp = comp[:n]
+			out := make([]byte, len(input))
+
+			b.Run(fmt.Sprintf("%s/profile=%d", name, profile), func(b *testing.B) {
+				b.ReportMetric(float64(len(input))/float64(n), "ratio")
+				b.SetBytes(int64(len(input)))
+				for i := 0; i < b.N; i++ {
+					_, _ = Decompress(comp, out)
+				}
+			})
+		}
+	}
+}
+
+// jsonRecords returns JSON lines whose keys and values share 4-byte
+// prefixes but differ after them, the case HashLen 5 is for.
+func jsonRecords(n int) []byte {
+	r := rand.New(rand.NewSource(1))
+	var out []byte
+	for i := 0; i < n; i++ {
+		out = fmt.Appendf(out, `{"user_id":%d,"user_name":"u%d","user_role":"%s","user_state":"%s"}`+"\n",
+			r.Intn(100000), r.Intn(1000), []string{"admin", "author", "audit"}[r.Intn(3)],
+			[]string{"active", "activated", "actionable"}[r.Intn(3)])
+	}
+	return out
+}
+
+func TestCompressorHashLen(t *testing.T) {
+	c := NewCompressor()
+	c.HashLen = 6
+	checkCompressorKnob(t, c, nil)
+	c.HashLen = 5
+	checkCompressorKnob(t, c, nil,
+		func(c *Compressor) { c.HashLen = 4 },
+		func(c *Compressor) { c.HashLen = -1 })
+
+	// With a dictionary, which is indexed with the same hash
+	c.HashLen = 5
+	dict := jsonRecords(100)
+	input := jsonRecords(200)[len(dict):]
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	var joined []byte
+	n, err := c.compressDict(input, dst, dict, &joined)
+	if err != nil {
+		t.Fatalf("compressDict failed: %v", err)
+	}
+	out := make([]byte, len(input))
+	if m, err := DecompressWithDict(dst[:n], out, dict); err != nil || !bytes.Equal(out[:m], input) {
+		t.Errorf("dictionary roundtrip failed: %v", err)
+	}
+
+	// Keys sharing 4-byte prefixes find their longer matches
+	records := jsonRecords(10000)
+	sizes := map[int]int{}
+	for _, hashLen := range []int{4, 5} {
+		c.HashLen = hashLen
+		dst := make([]byte, MaxCompressedSize(len(records)))
+		sizes[hashLen], _ = c.Compress(records, dst)
+	}
+	if sizes[5] >= sizes[4] {
+		t.Errorf("HashLen 5 compressed JSON records to %d bytes, HashLen 4 to %d", sizes[5], sizes[4])
+	}
+}
+
+func BenchmarkCompressorHashLen(b *testing.B) {
+	input := jsonRecords(10000)
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	for _, hashLen := range []int{4, 5} {
+		b.Run(fmt.Sprintf("hashlen=%d", hashLen), func(b *testing.B) {
+			c := NewCompressor()
+			c.HashLen = hashLen
+			n, _ := c.Compress(input, dst)
+			b.ReportMetric(float64(len(input))/float64(n), "ratio")
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				_, _ = c.Compress(input, dst)
+			}
+		})
+	}
+}
+
+// binaryRecords returns fixed-size little-endian records whose few varying
+// fields leave many chance 3-byte repeats between unrelated records.
+func binaryRecords(n int) []byte {
+	var out []byte
+	x := uint32(1)
+	for i := 0; i < n; i++ {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		out = binary.LittleEndian.AppendUint32(out, uint32(i))
+		out = binary.LittleEndian.AppendUint16(out, uint16(x%1000))
+		out = append(out, byte(x>>16)&7, 0, byte(x>>24))
+		out = binary.LittleEndian.AppendUint32(out, 0x00010000|x&0xff)
+	}
+	return out
+}
+
+// knobInputs returns the inputs checkCompressorKnob runs: inputs too short
+// to match, incompressible data alone and ahead of compressible data, the
+// record, JSON and log fixtures, a long run of zeros and the liblzo2
+// vectors.
+func knobInputs() [][]byte {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{
+		{}, []byte("abc"), []byte("abcd"), []byte("abcde"),
+		random, append(append([]byte{}, random...), parallelInput()...),
+		binaryRecords(2000), jsonRecords(2000), parallelInput(), make([]byte, 100000),
+	}
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+	return inputs
+}
+
+// checkCompressorKnob checks a Compressor setting. As c is configured, every
+// knobInputs input must roundtrip, and check, if not nil, is given each
+// compressed stream. Then each of off, which sets the knob to a value that
+// disables it, must make c compress exactly like Compress.
+func checkCompressorKnob(t *testing.T, c *Compressor, check func(input int, comp []byte), off ...func(*Compressor)) {
+	t.Helper()
+	inputs := knobInputs()
+	for i, input := range inputs {
+		dst := make([]byte, MaxCompressedSize(len(input)))
+		n, err := c.Compress(input, dst)
+		if err != nil {
+			t.Fatalf("input %d: Compress failed: %v", i, err)
+		}
+		out := make([]byte, len(input))
+		if m, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out[:m], input) {
+			t.Fatalf("input %d: roundtrip failed: %v", i, err)
+		}
+		if check != nil {
+			check(i, dst[:n])
+		}
+	}
+
+	for k, set := range off {
+		set(c)
+		for i, input := range inputs {
+			dst := make([]byte, MaxCompressedSize(len(input)))
+			n, _ := c.Compress(input, dst)
+			if !bytes.Equal(dst[:n], MustCompress(input, nil)) {
+				t.Errorf("off value %d, input %d: differs from Compress", k, i)
+			}
+		}
+	}
+}
+
+func TestCompressorMinMatch(t *testing.T) {
+	c := NewCompressor()
+	c.MinMatch = 4
+	checkCompressorKnob(t, c, func(i int, comp []byte) {
+		err := DecodeOpcodes(comp, func(op OpInfo) bool {
+			if op.Offset > 0 && op.Length < 4 {
+				t.Errorf("input %d: %v match of %d bytes at %d", i, op.Kind, op.Length, op.InputPos)
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("input %d: DecodeOpcodes failed: %v", i, err)
+		}
+	}, func(c *Compressor) { c.MinMatch = 3 }, func(c *Compressor) { c.MinMatch = -1 })
+
+	// MinMatch 4 drops exactly the M1 matches the records otherwise use
+	records := binaryRecords(2000)
+	for _, minMatch := range []int{3, 4} {
+		c.MinMatch = minMatch
+		cfg := c.config()
+		st := Stats{MatchLengths: make(map[int]int)}
+		cfg.stats = &st
+		dst := make([]byte, MaxCompressedSize(len(records)))
+		if _, err := compressNew(records, dst, cfg); err != nil {
+			t.Fatalf("MinMatch %d: compress failed: %v", minMatch, err)
+		}
+		if minMatch == 3 && st.M1 == 0 {
+			t.Errorf("MinMatch 3: no M1 matches, the records do not exercise MinMatch")
+		}
+		if minMatch == 4 && (st.M1 != 0 || st.MatchLengths[2] != 0 || st.MatchLengths[3] != 0) {
+			t.Errorf("MinMatch 4: %d M1 matches, lengths %v", st.M1, st.MatchLengths)
+		}
+	}
+}
+
+func TestCompressorFlushedChunks(t *testing.T) {
+	input := parallelInput()
+	c := NewCompressor()
+
+	for _, chunks := range []int{1, 7, 100} {
+		size := (len(input) + chunks - 1) / chunks
+		var stream []byte
+		for start := 0; start < len(input); start += size {
+			chunk := input[start:min(start+size, len(input))]
+			dst := make([]byte, MaxCompressedSize(len(chunk)))
+			n, err := c.Compress(chunk, dst)
+			if err != nil {
+				t.Fatalf("%d chunks: Compress failed: %v", chunks, err)
+			}
+
+			// Every chunk is a self-contained stream
+			out := make([]byte, len(chunk))
+			if m, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out[:m], chunk) {
+				t.Fatalf("%d chunks: chunk at %d does not decode on its own: %v", chunks, start, err)
+			}
+			stream = append(stream, dst[:n]...)
+		}
+
+		out := make([]byte, len(input))
+		if n, err := DecompressAll(stream, out); err != nil || !bytes.Equal(out[:n], input) {
+			t.Errorf("%d chunks: concatenated decode failed: %v", chunks, err)
+		}
+	}
+}
+
+func TestCompressorRetention(t *testing.T) {
+	c := NewCompressor()
+	c.SearchDepth = DefaultSearchDepth
+
+	big := bytes.Repeat(parallelInput(), 10)
+	if _, err := c.Compress(big, make([]byte, MaxCompressedSize(len(big)))); err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	// The chain is sized by the match window, never by the input
+	if len(c.chain) != windowSize || cap(c.chain) != windowSize {
+		t.Errorf("chain has len %d cap %d after a large input, want %d", len(c.chain), cap(c.chain), windowSize)
+	}
+
+	c.Reset()
+	if c.chain != nil {
+		t.Errorf("Reset kept the hash chain")
+	}
+
+	// Without chains nothing is reallocated; with them the chain returns
+	small := []byte("a small input, a small input")
+	dst := make([]byte, MaxCompressedSize(len(small)))
+	c.SearchDepth = 0
+	if _, err := c.Compress(small, dst); err != nil || c.chain != nil {
+		t.Errorf("SearchDepth 0: err %v, chain allocated: %v", err, c.chain != nil)
+	}
+	c.SearchDepth = DefaultSearchDepth
+	n, err := c.Compress(small, dst)
+	if err != nil || len(c.chain) != windowSize {
+		t.Fatalf("SearchDepth %d after Reset: err %v, chain len %d", DefaultSearchDepth, err, len(c.chain))
+	}
+	out := make([]byte, len(small))
+	if m, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out[:m], small) {
+		t.Errorf("roundtrip after Reset failed: %v", err)
+	}
+}
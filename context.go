@@ -0,0 +1,16 @@
+package lzo1z
+
+import "context"
+
+// CompressContext compresses src into dst like Compress, checking ctx
+// every 64 KiB of input and returning ctx.Err() promptly once it is done.
+//
+// On cancellation the returned count n covers whole opcodes encoding a
+// prefix of src, but no EOF marker: dst[:n] is not a complete stream
+// (RepairEOF would turn it into one), and dst beyond n is unspecified.
+func CompressContext(ctx context.Context, src, dst []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return compressNew(src, dst, compressConfig{ctx: ctx})
+}
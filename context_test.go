@@ -0,0 +1,72 @@
+package lzo1z
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+)
+
+// cancelAfter is a context that reports cancellation once Err has been
+// called n times, making mid-compression cancellation deterministic.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestCompressContextCancel(t *testing.T) {
+	src := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(src)
+	dst := make([]byte, MaxCompressedSize(len(src)))
+
+	// Entry check and the checks at 0, 64 and 128 KiB pass; 192 KiB cancels
+	ctx := &cancelAfter{Context: context.Background(), n: 4}
+	n, err := CompressContext(ctx, src, dst)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n == 0 || n > 3*ctxCheckInterval+ctxCheckInterval/8 {
+		t.Errorf("cancelled after writing %d bytes, want about %d", n, 3*ctxCheckInterval)
+	}
+
+	// The partial output is a stream prefix missing only its EOF marker
+	fixed, err := RepairEOF(dst[:n])
+	if err != nil {
+		t.Fatalf("RepairEOF(partial) failed: %v", err)
+	}
+	out, err := DecompressAppend(nil, fixed)
+	if err != nil {
+		t.Fatalf("Decompress(partial) failed: %v", err)
+	}
+	if !bytes.Equal(out, src[:len(out)]) {
+		t.Errorf("partial output does not decode to a prefix of src")
+	}
+}
+
+func TestCompressContext(t *testing.T) {
+	src := bytes.Repeat([]byte("context "), 20000)
+
+	want := make([]byte, MaxCompressedSize(len(src)))
+	wn, _ := Compress(src, want)
+	got := make([]byte, MaxCompressedSize(len(src)))
+	gn, err := CompressContext(context.Background(), src, got)
+	if err != nil {
+		t.Fatalf("CompressContext failed: %v", err)
+	}
+	if !bytes.Equal(got[:gn], want[:wn]) {
+		t.Errorf("output differs from Compress")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := CompressContext(ctx, []byte("ab"), got); n != 0 || err != context.Canceled {
+		t.Errorf("cancelled context: got (%d, %v), want (0, context.Canceled)", n, err)
+	}
+}
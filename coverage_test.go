@@ -2,6 +2,9 @@ package lzo1z
 
 import (
 	"bytes"
+	"errors"
+	"math"
+	"math/rand"
 	"testing"
 )
 
@@ -127,15 +130,21 @@ func TestEmitMatchErrors(t *testing.T) {
 	dst := make([]byte, 2) // Too small
 
 	_, err := emitMatch(dst, 1, 3)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun for small buffer, got %v", err)
 	}
 
 	// Invalid offset (too large)
 	dst = make([]byte, 100)
 	_, err = emitMatch(dst, 50000, 3)
-	if err != ErrOutputOverrun {
-		t.Errorf("expected ErrOutputOverrun for huge offset, got %v", err)
+	if !errors.Is(err, ErrUnencodableMatch) {
+		t.Errorf("expected ErrUnencodableMatch for huge offset, got %v", err)
+	}
+
+	// A match that cannot be encoded reports so even when dst is too small
+	_, err = emitMatch(make([]byte, 2), 0x401, 2)
+	if !errors.Is(err, ErrUnencodableMatch) {
+		t.Errorf("expected ErrUnencodableMatch for far M1 into a small buffer, got %v", err)
 	}
 }
 
@@ -224,7 +233,7 @@ func TestCompressOutputOverrun(t *testing.T) {
 	dst := make([]byte, 5) // Too small
 
 	_, err := Compress(input, dst)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -234,12 +243,12 @@ func TestEmitLiteralsOutputOverrun(t *testing.T) {
 	dst := make([]byte, 10) // Too small
 
 	_, err := emitLiterals(lit, dst, true)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 
 	_, err = emitLiterals(lit, dst, false)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun for non-first, got %v", err)
 	}
 }
@@ -361,7 +370,7 @@ func TestDecompressStateTransitions(t *testing.T) {
 	// by creating inputs that follow specific paths
 
 	tests := [][]byte{
-		// First byte > 17, t < 4: stateStart -> stateMatchNext
+		// First byte > 17, t < 4: stateStart -> stateMatch
 		[]byte("AAA"),
 
 		// First byte > 17, t >= 4: stateStart -> stateFirstLiteralRun
@@ -430,6 +439,32 @@ func TestMaxCompressedSizeEdgeCases(t *testing.T) {
 	}
 }
 
+func TestMaxCompressedSizeOverflow(t *testing.T) {
+	// Simulate a 32-bit int
+	tests := []struct {
+		n    int
+		want int
+		ok   bool
+	}{
+		{1 << 30, 1<<30 + 1<<26 + 67, true},
+		{2021161017, math.MaxInt32, true}, // largest input that fits
+		{2021161018, 0, false},
+		{math.MaxInt32, 0, false},
+	}
+	for _, tc := range tests {
+		if got, ok := maxCompressedSize(tc.n, math.MaxInt32); got != tc.want || ok != tc.ok {
+			t.Errorf("maxCompressedSize(%d) = (%d, %v), want (%d, %v)", tc.n, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MaxCompressedSize(math.MaxInt) did not panic")
+		}
+	}()
+	MaxCompressedSize(math.MaxInt)
+}
+
 func TestEmitLiteralsBoundaryConditions(t *testing.T) {
 	// Test boundary conditions in emitLiterals
 
@@ -500,29 +535,30 @@ func TestCompressSkipMatchConditions(t *testing.T) {
 
 func TestDecompressMalformedInput(t *testing.T) {
 	// Test decompressor with various malformed inputs
-	malformed := [][]byte{
+	malformed := []struct {
+		data    []byte
+		wantErr error
+	}{
 		// Empty
-		{},
+		{[]byte{}, nil},
 		// Just EOF marker
-		{0x11, 0x00, 0x00},
+		{[]byte{0x11, 0x00, 0x00}, nil},
 		// Truncated literal
-		{0x18, 0x41},
-		// Invalid M2 match (lookbehind)
-		{0x18, 0x41, 0x40, 0xff},
-		// M3 with zero offset (invalid)
-		{0x18, 0x41, 0x20, 0x00, 0x00},
+		{[]byte{0x18, 0x41}, ErrInputOverrun},
+		// 7 literals announced, so the would-be M2 is cut literal data
+		{[]byte{0x18, 0x41, 0x40, 0xff}, ErrInputOverrun},
+		{[]byte{0x18, 0x41, 0x20, 0x00, 0x00}, ErrInputOverrun},
 		// Extended literal with truncation
-		{0x00, 0xff},
-		// M4 near EOF marker
-		{0x11, 0x01, 0x00},
+		{[]byte{0x00, 0xff}, ErrInputOverrun},
+		// M4 near EOF marker, with a nonzero offset
+		{[]byte{0x11, 0x01, 0x00}, ErrLookbehindOverrun},
 	}
 
-	for i, data := range malformed {
+	for i, tc := range malformed {
 		out := make([]byte, 1000)
-		_, err := Decompress(data, out)
-		// We just want to ensure no panic, errors are expected
-		_ = err
-		t.Logf("malformed[%d]: err=%v", i, err)
+		if _, err := Decompress(tc.data, out); !errors.Is(err, tc.wantErr) {
+			t.Errorf("malformed[%d]: expected %v, got %v", i, tc.wantErr, err)
+		}
 	}
 }
 
@@ -536,3 +572,74 @@ func TestCompressLiteralsOnlyError(t *testing.T) {
 		t.Error("expected error for small buffer")
 	}
 }
+
+func TestMatchEncodable(t *testing.T) {
+	offsets := []int{-1, 0, 1, 0x400, 0x401, 0x700, 0x701, 0x4000, 0x4001, 0xbffe, 0xbfff, 0xc000, 50000}
+	lengths := []int{2, 3, 4, 5, 9, 10, 33, 34, 264, 1000}
+
+	// matchEncodable must agree with what emitMatch accepts
+	for _, off := range offsets {
+		for _, l := range lengths {
+			dst := make([]byte, 100)
+			_, err := emitMatch(dst, off, l)
+			if got, want := matchEncodable(off, l), err == nil; got != want {
+				t.Errorf("matchEncodable(%d, %d) = %v, emitMatch err = %v", off, l, got, err)
+			}
+			if err != nil && !errors.Is(err, ErrUnencodableMatch) {
+				t.Errorf("emitMatch(%d, %d) = %v, want ErrUnencodableMatch", off, l, err)
+			}
+		}
+	}
+
+	for _, l := range []int{0, 1} {
+		if matchEncodable(1, l) {
+			t.Errorf("matchEncodable(1, %d) = true, want false", l)
+		}
+	}
+}
+
+func TestCompressUnencodableFallback(t *testing.T) {
+	// Repeats at 0x2000 and 0x5000 bytes reach M3 and M4; a predicate
+	// declaring everything beyond M2 range unencodable sends those matches
+	// down the literal fallback instead of aborting
+	block := make([]byte, 0x1000)
+	rand.New(rand.NewSource(1)).Read(block)
+	var input []byte
+	for _, n := range []int{0x2000, 0x5000} {
+		input = append(input, block...)
+		input = append(input, make([]byte, n-len(block))...)
+	}
+	input = append(input, block...)
+	input = append(input, bytes.Repeat([]byte("near near "), 50)...)
+
+	rejected := 0
+	cfg := compressConfig{encodable: func(offset, length int) bool {
+		if offset > m2MaxOffset {
+			rejected++
+			return false
+		}
+		return true
+	}}
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	n, err := compressNew(input, dst, cfg)
+	if err != nil {
+		t.Fatalf("compress with unencodable matches failed: %v", err)
+	}
+	if rejected == 0 {
+		t.Fatal("no match was rejected, the fallback was not exercised")
+	}
+
+	out := make([]byte, len(input))
+	if m, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out[:m], input) {
+		t.Fatalf("roundtrip failed: %v", err)
+	}
+	err = DecodeOpcodes(dst[:n], func(op OpInfo) bool {
+		if op.Offset > m2MaxOffset {
+			t.Errorf("match at %d has rejected offset %d", op.InputPos, op.Offset)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("DecodeOpcodes failed: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package lzo1z
+
+// Decompressor is a reusable LZO1Z decompressor that can also resume a
+// stream whose input arrives in pieces.
+//
+// Given a complete stream, Decompress behaves exactly like the
+// package-level Decompress. When the input ends early, the Decompressor
+// keeps its place in the stream, including a token cut in half, and the
+// next call continues from there.
+//
+// A Decompressor is not safe for concurrent use; keep one per goroutine.
+type Decompressor struct {
+	// MaxOutputLen caps the decoded size of a stream whatever the size of
+	// dst: an opcode that would take the output past it fails with
+	// ErrOutputOverrun before anything is copied. Services decoding
+	// untrusted input can use it to bound the work of a stream whose
+	// extended lengths expand far beyond any plausible ratio. Zero means
+	// no limit beyond len(dst).
+	MaxOutputLen int
+
+	// MaxExpansionRatio fails a stream with ErrCorrupted as soon as a match
+	// would take its output past this many bytes per input byte consumed
+	// so far, guarding services that accept compressed uploads against
+	// decompression bombs. It is checked once per match, not per byte.
+	// No stream exceeds about 255:1, which long runs of a single byte
+	// approach legitimately. Zero means no limit.
+	MaxExpansionRatio int
+
+	st      decodeState // position at the start of the pending token
+	pending []byte      // input of a token cut short, kept for the next call
+	inPos   int         // stream offset of the pending token, for DecodeError
+}
+
+// NewDecompressor returns a ready-to-use Decompressor. The zero value is
+// also ready to use.
+func NewDecompressor() *Decompressor {
+	return &Decompressor{}
+}
+
+// Decompress decodes src into dst and returns the number of bytes of
+// decoded output in dst.
+//
+// If src ends before the EOF marker, Decompress returns the output decoded
+// so far and ErrInputOverrun, and remembers where it stopped. Calling it
+// again with the following input and the same dst, still holding the
+// earlier output, continues the stream. src may be split at any byte.
+//
+// When the stream ends, or decoding fails with any other error, the
+// Decompressor starts over, so independent streams can be decoded one
+// after another without calling Reset.
+func (d *Decompressor) Decompress(src, dst []byte) (int, error) {
+	in := src
+	if len(d.pending) > 0 {
+		d.pending = append(d.pending, src...)
+		in = d.pending
+	}
+
+	if d.MaxOutputLen > 0 && len(dst) > d.MaxOutputLen {
+		dst = dst[:d.MaxOutputLen]
+	}
+
+	cfg := decodeConfig{maxRatio: d.MaxExpansionRatio, inBase: d.inPos, guard: true}
+	op, ip, tokIP, tok, err := decodeFrom(in, dst, cfg, d.st)
+	switch err {
+	case nil:
+		if ip < len(in) {
+			err = errNotConsumed(d.inPos+ip, op)
+		}
+	case ErrInputOverrun, errMissingEOF:
+		// Keep the unfinished token; copy handles the overlap when in
+		// is d.pending itself
+		d.st = tok
+		d.pending = append(d.pending[:0], in[tokIP:]...)
+		d.inPos += tokIP
+		return tok.op, &DecodeError{Err: ErrInputOverrun, InputPos: d.inPos, OutputPos: tok.op}
+	default:
+		err = &DecodeError{Err: err, InputPos: d.inPos + tokIP, OutputPos: tok.op}
+	}
+
+	d.Reset()
+	return op, err
+}
+
+// Reset discards a partially decoded stream, keeping the buffer that
+// holds cut-off input for reuse.
+func (d *Decompressor) Reset() {
+	d.st = decodeState{}
+	d.pending = d.pending[:0]
+	d.inPos = 0
+}
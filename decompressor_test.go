@@ -0,0 +1,248 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecompressorMatchesDecompress(t *testing.T) {
+	d := NewDecompressor()
+
+	srcs := [][]byte{
+		{},
+		{0x15, 0x41, 0x42},                   // truncated
+		{0x12, 0x41, 0x11, 0x00, 0x00, 0x00}, // trailing garbage
+		{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}, // lookbehind
+	}
+	for _, tc := range interopTestCases {
+		srcs = append(srcs, tc.compressed)
+	}
+
+	// Twice, so every stream also runs after an error or a finished stream
+	for round := 0; round < 2; round++ {
+		for i, src := range srcs {
+			want := make([]byte, 1<<20)
+			wn, werr := Decompress(src, want)
+
+			got := make([]byte, 1<<20)
+			gn, gerr := d.Decompress(src, got)
+			if !reflect.DeepEqual(gerr, werr) || gn != wn || !bytes.Equal(got[:gn], want[:wn]) {
+				t.Errorf("round %d src %d: got (%d, %v), want (%d, %v)", round, i, gn, gerr, wn, werr)
+			}
+			if errors.Is(gerr, ErrInputOverrun) {
+				// A truncated stream leaves d waiting for more input
+				d.Reset()
+			}
+		}
+	}
+}
+
+func TestDecompressorSplitInput(t *testing.T) {
+	var d Decompressor
+	for _, tc := range interopTestCases {
+		for _, chunk := range []int{1, 2, 3, 7, 64} {
+			dst := make([]byte, tc.inputLen)
+			src := tc.compressed
+
+			var n int
+			var err error
+			for len(src) > 0 {
+				k := min(chunk, len(src))
+				n, err = d.Decompress(src[:k], dst)
+				src = src[k:]
+				if len(src) > 0 && !errors.Is(err, ErrInputOverrun) {
+					t.Fatalf("%s/%d: mid-stream error %v, want ErrInputOverrun", tc.name, chunk, err)
+				}
+			}
+			if err != nil {
+				t.Fatalf("%s/%d: Decompress failed: %v", tc.name, chunk, err)
+			}
+			if !bytes.Equal(dst[:n], tc.input) {
+				t.Errorf("%s/%d: output mismatch", tc.name, chunk)
+			}
+		}
+	}
+}
+
+func TestDecompressorConsecutiveTrailingLiterals(t *testing.T) {
+	// Matches whose trailing literals are followed directly by another
+	// match: each count must come from its own opcode's last byte, also
+	// when the input is cut between or inside them
+	src := []byte{
+		0x01, 'A', 'B', 'C', 'D', // 4 literals
+		0x22, 0x00, 0x0d, 'x', // M3: length 4, offset 4, 1 trailing
+		0x5e, 'y', 'z', // M2: length 3, last offset, 2 trailing
+		0x00, 0x0b, '1', '2', '3', // M1: length 2, offset 3, 3 trailing
+		0x21, 0x00, 0x00, // M3: length 3, offset 1, none trailing
+		0x11, 0x00, 0x00, // EOF
+	}
+	want := []byte("ABCDABCDxBCDyzDy123333")
+
+	dst := make([]byte, len(want))
+	n, err := Decompress(src, dst)
+	if err != nil || !bytes.Equal(dst[:n], want) {
+		t.Fatalf("Decompress = %q, %v; want %q", dst[:n], err, want)
+	}
+
+	var d Decompressor
+	for cut := 1; cut < len(src); cut++ {
+		clear(dst)
+		if _, err := d.Decompress(src[:cut], dst); !errors.Is(err, ErrInputOverrun) {
+			t.Fatalf("cut %d: first half error %v, want ErrInputOverrun", cut, err)
+		}
+		n, err := d.Decompress(src[cut:], dst)
+		if err != nil || !bytes.Equal(dst[:n], want) {
+			t.Errorf("cut %d: got %q, %v; want %q", cut, dst[:n], err, want)
+		}
+	}
+}
+
+func TestDecompressorReset(t *testing.T) {
+	d := NewDecompressor()
+	dst := make([]byte, 100)
+
+	// Abandon a stream cut inside a literal run
+	if _, err := d.Decompress([]byte{0x15, 0x41, 0x42}, dst); !errors.Is(err, ErrInputOverrun) {
+		t.Fatalf("expected ErrInputOverrun, got %v", err)
+	}
+	d.Reset()
+
+	n, err := d.Decompress([]byte{0x14, 0x58, 0x59, 0x5a, 0x11, 0x00, 0x00}, dst)
+	if err != nil || string(dst[:n]) != "XYZ" {
+		t.Errorf("after Reset: got (%q, %v), want (\"XYZ\", nil)", dst[:n], err)
+	}
+}
+
+// expansionBomb returns a stream of one literal and a single M3 match at
+// offset 1 whose extended length spends a 0x00 byte per 255 output bytes,
+// about 250 KiB from 1 KiB, with its decoded length. The match opcode
+// starts at input 2 and ends at input 1006.
+func expansionBomb() ([]byte, int) {
+	src := []byte{0x12, 'A', 0x20}
+	src = append(src, make([]byte, 1000)...)
+	src = append(src, 0xff, 0x00, 0x00, 0x11, 0x00, 0x00)
+	return src, 1 + 31 + 255*1000 + 0xff + 2
+}
+
+func TestDecompressorMaxOutputLen(t *testing.T) {
+	src, want := expansionBomb()
+
+	dst := make([]byte, 1<<20)
+	d := &Decompressor{MaxOutputLen: 64 << 10}
+	n, err := d.Decompress(src, dst)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrOutputOverrun {
+		t.Fatalf("expected a DecodeError for ErrOutputOverrun, got %v", err)
+	}
+	if n != 1 || de.InputPos != 2 || de.OutputPos != 1 {
+		t.Errorf("stopped at input %d output %d with %d bytes, want the match at input 2 output 1", de.InputPos, de.OutputPos, n)
+	}
+	if dst[1] != 0 {
+		t.Errorf("match partly copied past the cap")
+	}
+
+	// The cap counts the whole stream, also when it arrives in pieces
+	d.MaxOutputLen = want
+	if _, err := d.Decompress(src[:500], dst); !errors.Is(err, ErrInputOverrun) {
+		t.Fatalf("first half: expected ErrInputOverrun, got %v", err)
+	}
+	if n, err := d.Decompress(src[500:], dst); err != nil || n != want {
+		t.Errorf("at the cap: got %d, %v; want %d, nil", n, err, want)
+	}
+	d.MaxOutputLen = want - 1
+	if _, err := d.Decompress(src, dst); !errors.Is(err, ErrOutputOverrun) {
+		t.Errorf("one byte over the cap: expected ErrOutputOverrun, got %v", err)
+	}
+}
+
+func TestDecompressorMaxExpansionRatio(t *testing.T) {
+	src, want := expansionBomb()
+	dst := make([]byte, want)
+
+	// The match yields 255289 bytes from 1006 input bytes: 253.8 to 1
+	d := &Decompressor{MaxExpansionRatio: 253}
+	n, err := d.Decompress(src, dst)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrCorrupted {
+		t.Fatalf("ratio 253: expected a DecodeError for ErrCorrupted, got %v", err)
+	}
+	if n != 1 || de.InputPos != 2 || de.OutputPos != 1 {
+		t.Errorf("ratio 253: stopped at input %d output %d with %d bytes, want the match at input 2 output 1", de.InputPos, de.OutputPos, n)
+	}
+
+	// Input consumed in earlier calls counts towards the ratio
+	d.MaxExpansionRatio = 254
+	if _, err := d.Decompress(src[:500], dst); !errors.Is(err, ErrInputOverrun) {
+		t.Fatalf("first half: expected ErrInputOverrun, got %v", err)
+	}
+	if n, err := d.Decompress(src[500:], dst); err != nil || n != want {
+		t.Errorf("ratio 254: got %d, %v; want %d, nil", n, err, want)
+	}
+
+	// A legitimate stream is limited the same way
+	zeros := MustCompress(make([]byte, 1<<20), nil)
+	dst = make([]byte, 1<<20)
+	d.MaxExpansionRatio = 10
+	if _, err := d.Decompress(zeros, dst); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("zeros at ratio 10: expected ErrCorrupted, got %v", err)
+	}
+	d.MaxExpansionRatio = 1000
+	if n, err := d.Decompress(zeros, dst); err != nil || n != len(dst) {
+		t.Errorf("zeros at ratio 1000: got %d, %v", n, err)
+	}
+}
+
+func tinyFrames() [][]byte {
+	inputs := tinyInputs()
+	frames := make([][]byte, len(inputs))
+	for i, input := range inputs {
+		frames[i] = MustCompress(input, nil)
+	}
+	return frames
+}
+
+func BenchmarkDecompressTinyFrames(b *testing.B) {
+	frames := tinyFrames()
+	dst := make([]byte, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, frame := range frames {
+			_, _ = Decompress(frame, dst)
+		}
+	}
+}
+
+func BenchmarkDecompressorTinyFrames(b *testing.B) {
+	frames := tinyFrames()
+	dst := make([]byte, 64)
+	d := NewDecompressor()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, frame := range frames {
+			_, _ = d.Decompress(frame, dst)
+		}
+	}
+}
+
+func TestDecompressorErrorPosition(t *testing.T) {
+	src := []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}
+	d := NewDecompressor()
+	dst := make([]byte, 64)
+
+	// Positions count from the start of the stream, not of each piece
+	if _, err := d.Decompress(src[:6], dst); !errors.Is(err, ErrInputOverrun) {
+		t.Fatalf("expected ErrInputOverrun, got %v", err)
+	}
+	_, err := d.Decompress(src[6:], dst)
+
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrLookbehindOverrun {
+		t.Fatalf("expected a DecodeError for ErrLookbehindOverrun, got %v", err)
+	}
+	if de.InputPos != 5 || de.OutputPos != 4 {
+		t.Errorf("got input %d output %d, want input 5 output 4", de.InputPos, de.OutputPos)
+	}
+}
@@ -0,0 +1,109 @@
+package lzo1z
+
+import "math"
+
+// CompressWithDict compresses src into dst like Compress, letting matches
+// reach back into dict as if it preceded src. Short inputs that share
+// content with a known dictionary, such as messages of a fixed schema,
+// compress far better this way. The stream decodes only with
+// DecompressWithDict and the same dictionary, as with liblzo2's
+// lzo1z_999_compress_dict.
+//
+// Only the last maxOffset (49151) bytes of dict are within reach of a
+// match; earlier bytes are ignored. src and dict are copied into one
+// buffer for the search, so unlike Compress it allocates.
+func CompressWithDict(src, dst, dict []byte) (int, error) {
+	var c Compressor
+	var joined []byte
+	return c.compressDict(src, dst, dict, &joined)
+}
+
+// DecompressWithDict decompresses src, compressed by CompressWithDict, into
+// dst, given the same dictionary. Returns the number of bytes written to
+// dst; errors are reported like Decompress, with output positions relative
+// to dst. A match reaching before the start of dict returns
+// ErrLookbehindOverrun.
+func DecompressWithDict(src, dst, dict []byte) (int, error) {
+	var window []byte
+	return decompressDict(src, dst, dict, &window, decodeConfig{})
+}
+
+// dictWindow returns the part of dict that matches can reach.
+func dictWindow(dict []byte) []byte {
+	return dict[max(0, len(dict)-maxOffset):]
+}
+
+// compressDict implements CompressWithDict with c's hash table and
+// settings, building dict and src into *joined, which is kept for reuse.
+// An empty dict compresses exactly like c.Compress.
+func (c *Compressor) compressDict(src, dst, dict []byte, joined *[]byte) (int, error) {
+	dict = dictWindow(dict)
+	if len(dict) == 0 || len(src) <= minMatch {
+		return c.Compress(src, dst)
+	}
+	if overlaps(src, dst) || overlaps(dict, dst) {
+		return 0, ErrAliasedBuffers
+	}
+
+	buf := append(append((*joined)[:0], dict...), src...)
+	*joined = buf
+	if c.base < 1 || c.base > math.MaxInt-len(buf)-1 {
+		c.Reset()
+	}
+	cfg := c.config()
+	base := c.base
+	c.base += len(buf) + 1
+
+	// Index the dictionary, and the first byte of src, which is stored as
+	// a literal: a stream cannot open with a match, since at the start
+	// the decoder reads every opcode above 17 as a literal run
+	chain := cfg.chain
+	for p := 0; p <= len(dict); p++ {
+		h := hash4(buf, p, 32-hashBits)
+		if cfg.hash5 {
+			h = hash5(buf, p, 32-hashBits)
+		}
+		if chain != nil {
+			chain[p&windowMask] = c.hashTable[h]
+		}
+		c.hashTable[h] = p + base
+	}
+
+	s := compressState{ip: len(dict) + 1, litStart: len(dict)}
+	if err := compressScan(buf, dst, c.hashTable[:], base, cfg, &s, len(buf)-minMatch); err != nil {
+		return s.op, err
+	}
+	return compressFinish(buf, dst, &s, nil)
+}
+
+// decompressDict implements DecompressWithDict with cfg, decoding after a
+// copy of dict in *window, which is grown as needed and kept for reuse.
+func decompressDict(src, dst, dict []byte, window *[]byte, cfg decodeConfig) (int, error) {
+	dict = dictWindow(dict)
+	if len(dict) == 0 {
+		return decompressWhole(src, dst, cfg)
+	}
+	if len(src) == 0 {
+		return 0, nil
+	}
+
+	need := len(dict) + len(dst)
+	if cap(*window) < need {
+		*window = make([]byte, need)
+	}
+	buf := (*window)[:need]
+	copy(buf, dict)
+
+	op, ip, tokIP, tok, err := decodeFrom(src, buf, cfg, decodeState{op: len(dict)})
+	n := copy(dst, buf[len(dict):op])
+	if err != nil {
+		if err == errMissingEOF {
+			err = ErrInputOverrun
+		}
+		return n, &DecodeError{Err: err, InputPos: tokIP, OutputPos: tok.op - len(dict)}
+	}
+	if ip < len(src) {
+		return n, errNotConsumed(ip, n)
+	}
+	return n, nil
+}
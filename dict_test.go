@@ -0,0 +1,144 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// dictMessage returns a JSON-like message of about 200 bytes, whose field
+// names and layout repeat across messages but not within one.
+func dictMessage(id int) []byte {
+	return []byte(fmt.Sprintf(`{"id":%d,"user":"user-%d","action":"update",`+
+		`"resource":"/api/v2/accounts/%d/settings","status":"ok","region":"eu-west-1",`+
+		`"client":{"name":"mobile","version":"4.%d.0"},"latency_ms":%d}`,
+		id, id*7, id*13, id%10, id%97))
+}
+
+func TestCompressWithDict(t *testing.T) {
+	dict := bytes.Join([][]byte{dictMessage(1), dictMessage(2), dictMessage(3)}, nil)
+	long := bytes.Repeat([]byte("0123456789abcdef"), maxOffset/8)
+
+	tests := []struct {
+		name      string
+		src, dict []byte
+	}{
+		{"message", dictMessage(42), dict},
+		{"empty_src", nil, dict},
+		{"short_src", []byte("{}"), dict},
+		{"four_bytes", []byte(`{"id`), dict},
+		{"empty_dict", dictMessage(42), nil},
+		{"dict_beyond_reach", dictMessage(42), append(append([]byte{}, dict...), long...)},
+		{"src_repeats_dict_start", dict[:100], append(append([]byte{}, dict...), long[:maxOffset-len(dict)-50]...)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			comp := make([]byte, MaxCompressedSize(len(tc.src)))
+			n, err := CompressWithDict(tc.src, comp, tc.dict)
+			if err != nil {
+				t.Fatalf("CompressWithDict failed: %v", err)
+			}
+			out := make([]byte, len(tc.src))
+			m, err := DecompressWithDict(comp[:n], out, tc.dict)
+			if err != nil || !bytes.Equal(out[:m], tc.src) {
+				t.Fatalf("roundtrip failed: %v", err)
+			}
+		})
+	}
+
+	// An empty dictionary compresses like Compress
+	src := dictMessage(42)
+	want := MustCompress(src, nil)
+	got := make([]byte, len(want))
+	if n, err := CompressWithDict(src, got, nil); err != nil || !bytes.Equal(got[:n], want) {
+		t.Errorf("empty dict differs from Compress (%v)", err)
+	}
+}
+
+func TestDecompressWithDictErrors(t *testing.T) {
+	dict := bytes.Join([][]byte{dictMessage(1), dictMessage(2)}, nil)
+	src := dictMessage(3)
+	comp := make([]byte, MaxCompressedSize(len(src)))
+	n, err := CompressWithDict(src, comp, dict)
+	if err != nil {
+		t.Fatalf("CompressWithDict failed: %v", err)
+	}
+	comp = comp[:n]
+	out := make([]byte, len(src))
+
+	// Matches reach into the dictionary, which a shorter one lacks
+	if _, err := Decompress(comp, out); !errors.Is(err, ErrLookbehindOverrun) {
+		t.Errorf("no dict: expected ErrLookbehindOverrun, got %v", err)
+	}
+	if _, err := DecompressWithDict(comp, out, dict[len(dict)-10:]); !errors.Is(err, ErrLookbehindOverrun) {
+		t.Errorf("short dict: expected ErrLookbehindOverrun, got %v", err)
+	}
+	if _, err := DecompressWithDict(comp, out[:len(src)-1], dict); !errors.Is(err, ErrOutputOverrun) {
+		t.Errorf("small dst: expected ErrOutputOverrun, got %v", err)
+	}
+	if _, err := DecompressWithDict(append(comp, 0), out, dict); !errors.Is(err, ErrInputNotConsumed) {
+		t.Errorf("trailing input: expected ErrInputNotConsumed, got %v", err)
+	}
+
+	// Positions are relative to dst, not to the dictionary before it
+	_, err = DecompressWithDict(comp[:len(comp)-1], out, dict)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.OutputPos > len(src) {
+		t.Errorf("cut stream: expected a DecodeError within dst, got %v", err)
+	}
+}
+
+func TestWriterDict(t *testing.T) {
+	dict := bytes.Join([][]byte{dictMessage(1), dictMessage(2), dictMessage(3)}, nil)
+	msg := dictMessage(42)
+
+	compress := func(w *Writer, buf *bytes.Buffer) []byte {
+		if _, err := w.Write(msg); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	var plainBuf, dictBuf bytes.Buffer
+	plain := compress(NewWriter(&plainBuf), &plainBuf)
+	withDict := compress(NewWriterDict(&dictBuf, dict), &dictBuf)
+
+	// The dictionary supplies nearly all of a short message
+	if len(withDict) > len(plain)/2 {
+		t.Errorf("%d-byte message: %d bytes with dict, %d without, want at most half",
+			len(msg), len(withDict), len(plain))
+	}
+
+	got, err := io.ReadAll(NewReaderDict(bytes.NewReader(withDict), dict))
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("roundtrip failed: %v", err)
+	}
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(withDict))); err == nil {
+		t.Errorf("read without dict: expected an error")
+	}
+
+	// Every block of a longer stream uses the dictionary
+	var input []byte
+	for i := 0; i < 50; i++ {
+		input = append(input, dictMessage(i)...)
+	}
+	var buf bytes.Buffer
+	w := NewWriterDict(&buf, dict)
+	for i := 0; i < len(input); i += 700 {
+		w.Write(input[i:min(i+700, len(input))])
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	got, err = io.ReadAll(NewReaderDict(&buf, dict))
+	if err != nil || !bytes.Equal(got, input) {
+		t.Errorf("multi-block roundtrip failed: %v", err)
+	}
+}
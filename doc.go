@@ -18,7 +18,9 @@
 //	compressed = compressed[:n]
 //
 // Use MaxCompressedSize to determine the required buffer size for worst-case
-// compression (incompressible data).
+// compression (incompressible data). CompressInto instead stores data that
+// does not compress verbatim behind a mode byte, so its output is at most
+// one byte longer than the input; DecompressInto reads it back.
 //
 // # Decompression
 //
@@ -32,6 +34,60 @@
 //	}
 //	result := output[:n]
 //
+// Malformed input returns an error, never a panic. The entry points meant
+// for untrusted input (DecompressAppendMax, DecompressedSize, Decompressor,
+// Reader, ReadFrame, DecompressPrefixed and RepairEOF) also turn a panic
+// of their own, which only a bug in this package could cause, into
+// ErrCorrupted at the opcode being decoded, so a decoding bug cannot take
+// a server down.
+// Decompress and the other direct decoders leave that guard out of their
+// fast path. DecompressAppendMax and Reader.MaxOutputLen bound the memory
+// such input can make them allocate.
+//
+// # Streaming
+//
+// NewWriter compresses an io.Writer stream in independent blocks, each
+// framed with its decompressed and compressed lengths:
+//
+//	w := lzo1z.NewWriter(conn)
+//	if _, err := w.Write(data); err != nil {
+//	    log.Fatal(err)
+//	}
+//	err := w.Close() // flushes the last block and the stream terminator
+//
+// NewReader decodes such a stream, serving decompressed bytes through the
+// io.Reader interface. As in compress/flate, NewWriterLevel selects the
+// compression level, and both types can be Reset to reuse their buffers
+// for another stream; NewReadCloser returns the Reader as an io.ReadCloser
+// and an error, as gzip.NewReader does. NewWriterChecksum and
+// NewReaderChecksum add a CRC-32 to every block, verified as each block is
+// decoded, and NewWriterDict and NewReaderDict compress every block
+// against a shared dictionary, as CompressWithDict and DecompressWithDict
+// do for single buffers.
+// CompressPull writes the same stream from a function that produces the
+// input on demand, and Pipe connects a Writer to a Reader in memory.
+//
+// CompressParallel and DecompressParallel produce and decode the same block
+// format from memory, spreading the blocks over several goroutines, and
+// CompressReaderAt does the same for an io.ReaderAt too large to load.
+//
+// A Decompressor decodes a single raw LZO1Z stream whose input arrives in
+// pieces, resuming where the previous call ran out of input.
+//
+// DecompressTo streams the output of a single stream to an io.Writer,
+// keeping only the window of output that matches can refer back to, and
+// DecompressCallback hands the same output to a function chunk by chunk.
+// DecompressToWriterCRC also returns the CRC-32 of the output, for
+// re-framing it in a container that records one.
+// CompressFrom is its counterpart, compressing input read from an
+// io.Reader without holding all of it in memory.
+//
+// WriteFrame and ReadFrame wrap a single buffer in a frame that records
+// its decompressed length and CRC-32, so the reader needs no out-of-band
+// size and detects corruption. An ArchiveWriter packs many named buffers
+// into one archive of frames followed by a directory, from which an
+// ArchiveReader reads any entry on its own.
+//
 // # Buffer Sizing
 //
 // The caller must provide appropriately sized buffers:
@@ -39,15 +95,32 @@
 //   - For decompression: you must know or estimate the output size
 //
 // LZO does not store the decompressed size in the compressed stream,
-// so the caller must track this separately.
+// so the caller must track this separately or compute it with
+// DecompressedSize, which runs the decoder without writing any output.
+// DecompressAlloc does both, returning output allocated to the exact size.
 //
 // # Thread Safety
 //
 // Both Compress and Decompress are safe for concurrent use - they have
-// no global state and perform zero allocations.
+// no global state and perform zero allocations (only a failing Decompress
+// allocates, for the *DecodeError it returns).
 //
 // # Performance
 //
 // The implementation achieves approximately 420 MB/s compression and
 // 1 GB/s decompression on modern hardware with zero allocations.
+//
+// Compress pays a fixed cost per call to clear its 16K-entry hash table.
+// When compressing many small buffers, reuse a Compressor instead, which
+// invalidates its table without clearing it. CompressScratch does the same
+// with a table the caller allocates and reuses.
+//
+// Compress is greedy. Setting Compressor.Lazy trades some speed for a
+// better ratio by deferring a match when the next byte starts a longer one,
+// and Compressor.SearchDepth searches hash chains for the longest match.
+// CompressLevel bundles these settings into levels 1 to 3, and
+// ProfileFastDecode gives up some ratio for faster decoding instead.
+// CompressStats reports the matches and literal runs Compress emits, to
+// see why some data compresses poorly, and CompressFinder encodes the
+// matches of a caller's MatchFinder, to experiment with other searches.
 package lzo1z
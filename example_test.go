@@ -79,7 +79,7 @@ func ExampleCompress() {
 
 	ratio := float64(len(input)) / float64(n)
 	fmt.Printf("Input: %d bytes, Compressed: %d bytes, Ratio: %.1fx\n", len(input), n, ratio)
-	// Output: Input: 400 bytes, Compressed: 16 bytes, Ratio: 25.0x
+	// Output: Input: 400 bytes, Compressed: 13 bytes, Ratio: 30.8x
 }
 
 func ExampleMaxCompressedSize() {
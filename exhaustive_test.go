@@ -2,6 +2,7 @@ package lzo1z
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 )
 
@@ -34,7 +35,7 @@ func TestCompressOutputOverrunEOF(t *testing.T) {
 	dst := make([]byte, 4) // Too small for compressed + EOF
 
 	_, err := Compress(input, dst)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -45,7 +46,7 @@ func TestCompressLiteralsOnlyOutputOverrun(t *testing.T) {
 	dst := make([]byte, 3) // Too small
 
 	_, err := Compress(input, dst)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -60,7 +61,7 @@ func TestEmitLiteralsFirstShortOverrun(t *testing.T) {
 	dst := make([]byte, 2) // Need 3 bytes (1 length + 2 data)
 
 	_, err := emitLiterals(lit, dst, true)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -71,7 +72,7 @@ func TestEmitLiteralsFirstMediumOverrun(t *testing.T) {
 	dst := make([]byte, 5)    // Need 9 bytes
 
 	_, err := emitLiterals(lit, dst, true)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -82,7 +83,7 @@ func TestEmitLiteralsFirstExtendedOverrun(t *testing.T) {
 	dst := make([]byte, 30) // Need 52 bytes
 
 	_, err := emitLiterals(lit, dst, true)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -93,7 +94,7 @@ func TestEmitLiteralsFirstVeryLongOverrunLoop(t *testing.T) {
 	dst := make([]byte, 5)                // Way too small
 
 	_, err := emitLiterals(lit, dst, true)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -104,7 +105,7 @@ func TestEmitLiteralsFirstVeryLongOverrunFinal(t *testing.T) {
 	dst := make([]byte, 4) // Enough for prefix but not final byte
 
 	_, err := emitLiterals(lit, dst, true)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -115,7 +116,7 @@ func TestEmitLiteralsNonFirstMediumOverrun(t *testing.T) {
 	dst := make([]byte, 5)    // Need 9 bytes
 
 	_, err := emitLiterals(lit, dst, false)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -126,7 +127,7 @@ func TestEmitLiteralsNonFirstExtendedOverrunLoop(t *testing.T) {
 	dst := make([]byte, 3)
 
 	_, err := emitLiterals(lit, dst, false)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -137,7 +138,7 @@ func TestEmitLiteralsNonFirstExtendedOverrunFinal(t *testing.T) {
 	dst := make([]byte, 3)
 
 	_, err := emitLiterals(lit, dst, false)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -148,7 +149,7 @@ func TestEmitLiteralsCopyOverrun(t *testing.T) {
 	dst := make([]byte, 10) // Enough for header but not data
 
 	_, err := emitLiterals(lit, dst, true)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -161,7 +162,7 @@ func TestEmitMatchBufferTooSmall(t *testing.T) {
 	// Line 269: len(dst) < 4
 	dst := make([]byte, 3)
 	_, err := emitMatch(dst, 10, 5)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -170,8 +171,8 @@ func TestEmitMatchInvalidOffset(t *testing.T) {
 	// Line 365: offset out of range
 	dst := make([]byte, 100)
 	_, err := emitMatch(dst, 100000, 5) // Way too large
-	if err != ErrOutputOverrun {
-		t.Errorf("expected ErrOutputOverrun, got %v", err)
+	if !errors.Is(err, ErrUnencodableMatch) {
+		t.Errorf("expected ErrUnencodableMatch, got %v", err)
 	}
 }
 
@@ -264,16 +265,39 @@ func TestEmitMatchM4AllPaths(t *testing.T) {
 // ============================================================================
 
 func TestDecompressStateStartInputOverrun(t *testing.T) {
-	// Line 68: ip >= inLen at stateStart
+	// Empty input is an empty stream
 	out := make([]byte, 100)
-	_, err := Decompress([]byte{}, out)
-	// Empty input should return 0, nil or error
-	_ = err // Either is acceptable
+	if n, err := Decompress([]byte{}, out); n != 0 || err != nil {
+		t.Errorf("empty input: got (%d, %v), want (0, nil)", n, err)
+	}
+
+	// No stream is shorter than the 3-byte EOF marker, so every 1- and
+	// 2-byte input is cut short. A single byte is a truncated opcode,
+	// reported as such however small dst is
+	for b0 := 0; b0 < 256; b0++ {
+		for _, dstLen := range []int{0, 100} {
+			n, err := Decompress([]byte{byte(b0)}, make([]byte, dstLen))
+			var de *DecodeError
+			if !errors.As(err, &de) || de.Err != ErrInputOverrun || de.InputPos != 0 || n != 0 {
+				t.Fatalf("%#02x into %d bytes: got (%d, %v), want ErrInputOverrun at input offset 0",
+					b0, dstLen, n, err)
+			}
+		}
+	}
+	// Two bytes may hold a complete 1-literal opcode before the cut
+	for b0 := 0; b0 < 256; b0++ {
+		for b1 := 0; b1 < 256; b1++ {
+			src := []byte{byte(b0), byte(b1)}
+			if _, err := Decompress(src, make([]byte, 100)); !errors.Is(err, ErrInputOverrun) {
+				t.Fatalf("% x: expected ErrInputOverrun, got %v", src, err)
+			}
+		}
+	}
 }
 
 func TestDecompressStateStartT17Path(t *testing.T) {
 	// Lines 73-107: t > 17 paths
-	// t = 18 (t-17 = 1 < 4): stateStart -> copy 1 literal -> stateMatchNext
+	// t = 18 (t-17 = 1 < 4): stateStart -> copy 1 literal -> stateMatch
 	compressed := []byte{
 		0x12,             // t = 18, copy 1 literal
 		0x41,             // literal 'A'
@@ -309,7 +333,7 @@ func TestDecompressStateStartOutputOverrun(t *testing.T) {
 	out := make([]byte, 2) // Too small
 
 	_, err := Decompress(compressed, out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -320,7 +344,7 @@ func TestDecompressStateStartInputOverrunLiterals(t *testing.T) {
 	out := make([]byte, 100)
 
 	_, err := Decompress(compressed, out)
-	if err != ErrInputOverrun {
+	if !errors.Is(err, ErrInputOverrun) {
 		t.Errorf("expected ErrInputOverrun, got %v", err)
 	}
 }
@@ -434,7 +458,7 @@ func TestDecompressStateMatchDoneTrailingLiterals(t *testing.T) {
 }
 
 func TestDecompressStateMatchNextM1(t *testing.T) {
-	// Lines 363-395: stateMatchNext -> M1 match
+	// Lines 363-395: stateMatch -> M1 match
 	input := []byte("ABCABCABC")
 	compressed := make([]byte, MaxCompressedSize(len(input)))
 	n, _ := Compress(input, compressed)
@@ -484,7 +508,7 @@ func TestDecompressCraftedErrors(t *testing.T) {
 		// stateMatchDone errors - trailing literal truncated
 		{"matchdone_truncated", []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x41, 0x05}, ErrLookbehindOverrun},
 
-		// stateMatchNext errors
+		// stateMatch errors after leading literals
 		{"matchnext_truncated", []byte{0x12, 0x41}, ErrInputOverrun},
 		{"matchnext_m1_truncated", []byte{0x12, 0x41, 0x05}, ErrInputOverrun},
 	}
@@ -493,9 +517,12 @@ func TestDecompressCraftedErrors(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			out := make([]byte, 1000)
 			_, err := Decompress(tc.data, out)
-			if tc.want != nil && err != tc.want {
+			if tc.want != nil && !errors.Is(err, tc.want) {
 				t.Errorf("expected %v, got %v", tc.want, err)
 			}
+			if _, err := Verify(tc.data); tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("Verify: expected %v, got %v", tc.want, err)
+			}
 		})
 	}
 }
@@ -507,7 +534,7 @@ func TestDecompressOutputOverrunPaths(t *testing.T) {
 	compressed := []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x11, 0x00, 0x00}
 	out := make([]byte, 2)
 	_, err := Decompress(compressed, out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun for small output, got %v", err)
 	}
 
@@ -518,7 +545,7 @@ func TestDecompressOutputOverrunPaths(t *testing.T) {
 
 	out = make([]byte, 50) // Too small for decompressed
 	_, err = Decompress(comp[:n], out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun for match, got %v", err)
 	}
 }
@@ -636,7 +663,7 @@ func TestDecompressLiteralRunInputOverrun(t *testing.T) {
 	data := []byte{0x00, 0x00, 0x00} // Extended literal but no length byte
 	out := make([]byte, 100)
 	_, err := Decompress(data, out)
-	if err != ErrInputOverrun {
+	if !errors.Is(err, ErrInputOverrun) {
 		t.Errorf("expected ErrInputOverrun, got %v", err)
 	}
 }
@@ -647,7 +674,7 @@ func TestDecompressLiteralRunOutputOverrun(t *testing.T) {
 	data := []byte{0x05, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x11, 0x00, 0x00}
 	out := make([]byte, 5) // Too small for 8 bytes
 	_, err := Decompress(data, out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -661,7 +688,7 @@ func TestDecompressM2OutputOverrun(t *testing.T) {
 
 	out := make([]byte, 30) // Too small
 	_, err := Decompress(comp[:n], out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -674,7 +701,7 @@ func TestDecompressM3OutputOverrun(t *testing.T) {
 
 	out := make([]byte, 50) // Too small
 	_, err := Decompress(comp[:n], out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -687,20 +714,20 @@ func TestDecompressM1FirstLiteralOutputOverrun(t *testing.T) {
 
 	out := make([]byte, 10) // Too small
 	_, err := Decompress(comp[:n], out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
 
 func TestDecompressM1MatchNextOutputOverrun(t *testing.T) {
-	// M1 match in stateMatchNext with small output
+	// M1 match in stateMatch with small output
 	input := []byte("ABCABCABCABC")
 	comp := make([]byte, MaxCompressedSize(len(input)))
 	n, _ := Compress(input, comp)
 
 	out := make([]byte, 5) // Too small
 	_, err := Decompress(comp[:n], out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -713,21 +740,25 @@ func TestDecompressMatchDoneOutputOverrun(t *testing.T) {
 
 	out := make([]byte, 8) // Too small for all data
 	_, err := Decompress(comp[:n], out)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
 
 func TestCompressMaxMatchLength(t *testing.T) {
-	// Test match length capping at 264
-	input := bytes.Repeat([]byte("A"), 500)
+	// A long run is one extended-length match, not a chain of short ones
+	input := bytes.Repeat([]byte("A"), 100000)
 	dst := make([]byte, MaxCompressedSize(len(input)))
 	n, err := Compress(input, dst)
 	if err != nil {
 		t.Fatalf("Compress failed: %v", err)
 	}
+	// 1 literal (2 bytes), M3 with 392 extended length bytes (396), EOF (3)
+	if n != 401 {
+		t.Errorf("compressed to %d bytes, want 401", n)
+	}
 
-	out := make([]byte, 600)
+	out := make([]byte, len(input))
 	m, err := Decompress(dst[:n], out)
 	if err != nil {
 		t.Fatalf("Decompress failed: %v", err)
@@ -747,7 +778,7 @@ func TestCompressEmitLiteralsError(t *testing.T) {
 	dst := make([]byte, 10) // Too small
 
 	_, err := Compress(input, dst)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -759,7 +790,7 @@ func TestCompressEmitMatchError(t *testing.T) {
 	dst := make([]byte, 8) // Too small after literal
 
 	_, err := Compress(input, dst)
-	if err != ErrOutputOverrun {
+	if !errors.Is(err, ErrOutputOverrun) {
 		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
@@ -1096,7 +1127,7 @@ func TestDecompressStateStartErrors(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			out := make([]byte, 2) // Small output
 			_, err := Decompress(tc.data, out)
-			if tc.want != nil && err != tc.want {
+			if tc.want != nil && !errors.Is(err, tc.want) {
 				// Allow any error for error cases
 				if err == nil {
 					t.Errorf("expected error, got nil")
@@ -1297,9 +1328,8 @@ func TestDecompressMatchDoneErrors(t *testing.T) {
 	// Truncate to cause error in trailing copy
 	out := make([]byte, 8) // Not enough for trailing
 	_, err := Decompress(comp[:n], out)
-	if err != ErrOutputOverrun {
-		// May get different error depending on state
-		_ = err
+	if !errors.Is(err, ErrOutputOverrun) {
+		t.Errorf("expected ErrOutputOverrun, got %v", err)
 	}
 }
 
@@ -1335,3 +1365,128 @@ func TestDecompressMatchNextErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestDecompressOffsetReuseBeforeMatch(t *testing.T) {
+	// Each literal path into stateMatch, with a reuse opcode as the first
+	// match of the stream. Its offset would copy from the output position
+	// itself, so the stream is corrupt rather than merely out of range.
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"after_short_first_literals", []byte{0x12, 0x41, 0x7c, 0x11, 0x00, 0x00}},
+		{"after_long_first_literals", []byte{0x16, 0x41, 0x42, 0x43, 0x44, 0x45, 0x5c, 0x11, 0x00, 0x00}},
+		{"after_literal_run", []byte{0x01, 0x41, 0x42, 0x43, 0x44, 0x5c, 0x11, 0x00, 0x00}},
+		{"after_extended_literal_run", append(append([]byte{0x00, 0x00, 0x01}, bytes.Repeat([]byte{0x41}, 274)...), 0x5c, 0x11, 0x00, 0x00)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Decompress(tc.data, make([]byte, 300)); !errors.Is(err, ErrCorrupted) {
+				t.Errorf("Decompress: expected ErrCorrupted, got %v", err)
+			}
+			if _, err := DecompressedSize(tc.data); !errors.Is(err, ErrCorrupted) {
+				t.Errorf("DecompressedSize: expected ErrCorrupted, got %v", err)
+			}
+			if err := DecodeOpcodes(tc.data, func(OpInfo) bool { return true }); !errors.Is(err, ErrCorrupted) {
+				t.Errorf("DecodeOpcodes: expected ErrCorrupted, got %v", err)
+			}
+		})
+	}
+
+	// The M1 that can follow the first literal run is a match, whose
+	// offset the next opcode may reuse
+	lits := make([]byte, 2100)
+	for i := range lits {
+		lits[i] = byte(i * 7)
+	}
+	data := []byte{0x00, 0, 0, 0, 0, 0, 0, 0, 0, 42} // 2100 literals
+	data = append(data, lits...)
+	data = append(data, 0x00, 0x00) // M1 len 3 offset 0x701
+	data = append(data, 0x5c)       // M2 len 3, reuse offset 0x701
+	data = append(data, 0x11, 0x00, 0x00)
+	out := make([]byte, 2106)
+	n, err := Decompress(data, out)
+	if err != nil || n != len(out) {
+		t.Fatalf("reuse after M1: Decompress = (%d, %v)", n, err)
+	}
+	want := append([]byte{}, lits...)
+	for len(want) < len(out) {
+		want = append(want, want[len(want)-0x701])
+	}
+	if !bytes.Equal(out, want) {
+		t.Errorf("reuse after M1: output mismatch")
+	}
+}
+
+// ============================================================================
+// MATCH-NEXT ENTRY POINTS
+// ============================================================================
+
+func TestDecompressMatchNextOffsetReuse(t *testing.T) {
+	// stateMatch is entered from stateStart (1-3 leading literals) and
+	// from stateMatchDone (1-3 trailing literals). Offset reuse must see the
+	// last match offset in both cases.
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr error
+	}{
+		{
+			// No match has happened yet, so there is no offset to reuse
+			name:    "from_start_no_prior_match",
+			data:    []byte{0x13, 0x41, 0x42, 0x5c, 0x11, 0x00, 0x00},
+			wantErr: ErrCorrupted,
+		},
+		{
+			// Leading literals, M2 at offset 2, then reuse of offset 2
+			name: "from_start_after_match",
+			data: []byte{
+				0x13, 0x41, 0x42, // 2 literals "AB"
+				0x40, 0x04, // M2 len 3 offset 2
+				0x5c,             // M2 len 3, reuse offset 2
+				0x11, 0x00, 0x00, // EOF
+			},
+			want: "ABABABAB",
+		},
+		{
+			// Match with 1 trailing literal, then reuse of its offset
+			name: "from_match_done",
+			data: []byte{
+				0x12, 0x41, // 1 literal "A"
+				0x21, 0x00, 0x01, // M3 len 3 offset 1, 1 trailing literal
+				0x42,             // trailing literal "B"
+				0x5c,             // M2 len 3, reuse offset 1
+				0x11, 0x00, 0x00, // EOF
+			},
+			want: "AAAABBBB",
+		},
+		{
+			// Reuse opcode carrying its own trailing literals
+			name: "from_match_done_reuse_with_trailing",
+			data: []byte{
+				0x12, 0x41, // 1 literal "A"
+				0x21, 0x00, 0x02, // M3 len 3 offset 1, 2 trailing literals
+				0x42, 0x43, // trailing literals "BC"
+				0x7e,       // M2 len 4, reuse offset 1, 2 trailing literals
+				0x44, 0x45, // trailing literals "DE"
+				0x5c,             // M2 len 3, reuse offset 1
+				0x11, 0x00, 0x00, // EOF
+			},
+			want: "AAAABCCCCCDEEEE",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := make([]byte, 100)
+			n, err := Decompress(tc.data, out)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err == nil && string(out[:n]) != tc.want {
+				t.Errorf("got %q, want %q", out[:n], tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package lzo1z
+
+// MatchFinder supplies the matches CompressFinder encodes, so alternative
+// match search strategies can be tried while reusing the encoder.
+//
+// Find returns a match for the input at src[pos:]: a copy of length bytes
+// from offset bytes back, or ok false for none. Find is called with
+// increasing pos, skipping the positions covered by the matches it
+// returned, so a finder that indexes the input must index skipped
+// positions itself.
+type MatchFinder interface {
+	Find(src []byte, pos int) (offset, length int, ok bool)
+}
+
+// CompressFinder compresses src into dst like Compress, taking its matches
+// from f instead of the built-in hash search. A nil f compresses exactly
+// like Compress, whose search loop makes no interface calls.
+//
+// Matches shorter than 3 bytes, 3-byte matches beyond offset 0x700, matches
+// that are not encodable or that do not repeat the input at their offset
+// are ignored, so a faulty finder costs ratio but never produces a corrupt
+// stream or one larger than MaxCompressedSize.
+func CompressFinder(src, dst []byte, f MatchFinder) (int, error) {
+	if f == nil {
+		return Compress(src, dst)
+	}
+	if len(src) == 0 {
+		return 0, nil
+	}
+	if overlaps(src, dst) {
+		return 0, ErrAliasedBuffers
+	}
+	if len(src) <= 3 {
+		return compressLiteralsOnly(src, dst)
+	}
+
+	var s compressState
+	for ip := 0; ip < len(src)-minMatch; {
+		offset, length, ok := f.Find(src, ip)
+		if !ok || !validMatch(src, ip, offset, length) {
+			ip++
+			continue
+		}
+
+		if ip > s.litStart {
+			n, err := emitPendingLiterals(src[s.litStart:ip], dst[s.op:], s.state)
+			if err != nil {
+				return s.op, err
+			}
+			s.op += n
+		}
+		n, err := emitMatch(dst[s.op:], offset, length)
+		if err != nil {
+			return s.op, err
+		}
+		s.op += n
+		s.state = &dst[s.op-1]
+
+		ip += length
+		s.litStart = ip
+	}
+	return compressFinish(src, dst, &s, nil)
+}
+
+// validMatch reports whether a match found at ip can be emitted: at least
+// minMatch bytes, encodable, within src and repeating the earlier input.
+// A 3-byte match beyond M2's offset range takes 3 bytes to encode and
+// would split the literals around it, costing more than it saves.
+func validMatch(src []byte, ip, offset, length int) bool {
+	if length < minMatch || (length == minMatch && offset > 0x700) ||
+		!matchEncodable(offset, length) ||
+		offset > ip || length > len(src)-ip {
+		return false
+	}
+	return commonLen(src, ip-offset, ip, length) == length
+}
@@ -0,0 +1,99 @@
+package lzo1z
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// bruteForceFinder returns the longest match at each position, searching
+// every earlier offset.
+type bruteForceFinder struct{}
+
+func (bruteForceFinder) Find(src []byte, pos int) (offset, length int, ok bool) {
+	for off := 1; off <= pos && off <= maxOffset; off++ {
+		if n := commonLen(src, pos-off, pos, len(src)-pos); n > length {
+			offset, length = off, n
+		}
+	}
+	return offset, length, length >= minMatch
+}
+
+// badFinder returns matches the encoder must reject.
+type badFinder struct{}
+
+func (badFinder) Find(src []byte, pos int) (offset, length int, ok bool) {
+	switch pos % 4 {
+	case 0:
+		return pos + 1, 4, true // before the start of src
+	case 1:
+		return 1, len(src), true // past the end of src
+	case 2:
+		return 1, 5, true // input does not repeat
+	}
+	return 0, 3, true
+}
+
+func TestCompressFinder(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty": {},
+		"short": []byte("abc"),
+		"text":  bytes.Repeat([]byte("the finder finds the find; "), 100),
+		"mixed": append(bytes.Repeat([]byte{0}, 500), []byte("abcdefghabcdXfghabcdefgY")...),
+	}
+	for name, input := range inputs {
+		for fname, f := range map[string]MatchFinder{"brute": bruteForceFinder{}, "bad": badFinder{}} {
+			dst := make([]byte, MaxCompressedSize(len(input)))
+			n, err := CompressFinder(input, dst, f)
+			if err != nil {
+				t.Fatalf("%s/%s: CompressFinder failed: %v", name, fname, err)
+			}
+			out := make([]byte, len(input))
+			m, err := Decompress(dst[:n], out)
+			if err != nil || !bytes.Equal(out[:m], input) {
+				t.Errorf("%s/%s: roundtrip failed: %v", name, fname, err)
+			}
+		}
+
+		// No finder is Compress
+		want := make([]byte, MaxCompressedSize(len(input)))
+		wn, _ := Compress(input, want)
+		got := make([]byte, len(want))
+		gn, err := CompressFinder(input, got, nil)
+		if err != nil || !bytes.Equal(got[:gn], want[:wn]) {
+			t.Errorf("%s: nil finder differs from Compress (%v)", name, err)
+		}
+	}
+}
+
+// farFinder returns a 3-byte match every 22 bytes at offsets around 2000,
+// alternating so none reuses the last offset. Such matches are encodable
+// only as M3 and would grow the stream past its bound.
+type farFinder struct{}
+
+func (farFinder) Find(src []byte, pos int) (offset, length int, ok bool) {
+	return farOffset(pos), 3, pos >= 2112 && pos%22 == 0
+}
+
+func farOffset(pos int) int {
+	return 2000 + pos/22%2
+}
+
+func TestCompressFinderBound(t *testing.T) {
+	input := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(input)
+	for p := 2112; p+3 <= len(input); p += 22 {
+		copy(input[p:p+3], input[p-farOffset(p):])
+	}
+
+	dst := make([]byte, MaxCompressedSize(len(input)))
+	n, err := CompressFinder(input, dst, farFinder{})
+	if err != nil {
+		t.Fatalf("CompressFinder exceeded MaxCompressedSize: %v", err)
+	}
+	out := make([]byte, len(input))
+	m, err := Decompress(dst[:n], out)
+	if err != nil || !bytes.Equal(out[:m], input) {
+		t.Errorf("roundtrip failed: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package lzo1z
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// frameMagic starts every frame written by WriteFrame.
+var frameMagic = [4]byte{'L', 'Z', '1', 'Z'}
+
+// ErrFrameTooLarge is returned by WriteFrame and ArchiveWriter.Add for data
+// whose length does not fit in the 32-bit length of a frame header.
+var ErrFrameTooLarge = errors.New("lzo1z: frame data of 4 GiB or more")
+
+// errFrameChecksum is returned by ReadFrame for data failing its CRC-32.
+// It matches both ErrCorrupted, like every other damaged frame, and the
+// more specific ErrChecksumMismatch.
+var errFrameChecksum = fmt.Errorf("%w: %w", ErrCorrupted, ErrChecksumMismatch)
+
+// frameHeaderLen is the size of the header preceding a frame's payload:
+//
+//	[4]byte:           magic "LZ1Z"
+//	uint32 big-endian: decompressed length
+//	uint32 big-endian: CRC-32 (IEEE) of the decompressed data
+//	uint32 big-endian: compressed length
+//
+// followed by the payload, a complete LZO1Z stream. A payload whose
+// compressed length equals the decompressed length is stored verbatim.
+const frameHeaderLen = 16
+
+// WriteFrame compresses data and writes it to w as a self-describing,
+// self-verifying frame: a header carrying the decompressed length and
+// its CRC-32, followed by the compressed stream. Frames can be written
+// back to back and read with ReadFrame.
+//
+// Data that does not compress (already compressed or encrypted input)
+// is stored verbatim, so a frame is never more than frameHeaderLen bytes
+// larger than data. The header records lengths in 32 bits, so data of
+// 4 GiB or more returns ErrFrameTooLarge and writes nothing.
+func WriteFrame(w io.Writer, data []byte) error {
+	if uint64(len(data)) > math.MaxUint32 {
+		return ErrFrameTooLarge
+	}
+	buf := make([]byte, frameHeaderLen+MaxCompressedSize(len(data)))
+	n, err := Compress(data, buf[frameHeaderLen:])
+	if err != nil {
+		return err
+	}
+	if n >= len(data) {
+		n = copy(buf[frameHeaderLen:], data)
+	}
+
+	copy(buf, frameMagic[:])
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[8:], crc32.ChecksumIEEE(data))
+	binary.BigEndian.PutUint32(buf[12:], uint32(n))
+
+	_, err = w.Write(buf[:frameHeaderLen+n])
+	return err
+}
+
+// ReadFrame reads one frame written by WriteFrame from r and returns the
+// decompressed data.
+//
+// A frame cut short returns ErrInputOverrun, a bad magic or a header and
+// payload that disagree return ErrCorrupted, and data that decodes but
+// fails the CRC-32 check returns an error matching both ErrCorrupted and
+// ErrChecksumMismatch. At the end of r, before any frame byte, ReadFrame
+// returns io.EOF.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var hdr [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, readErr(err)
+	}
+	if [4]byte(hdr[:4]) != frameMagic {
+		return nil, ErrCorrupted
+	}
+	rawLen := binary.BigEndian.Uint32(hdr[4:])
+	sum := binary.BigEndian.Uint32(hdr[8:])
+	compLen := binary.BigEndian.Uint32(hdr[12:])
+
+	if !validLens(rawLen, compLen) {
+		return nil, ErrCorrupted
+	}
+
+	comp, err := readPayload(r, nil, int(compLen))
+	if err != nil {
+		return nil, readErr(err)
+	}
+
+	var data []byte
+	if compLen == rawLen {
+		data = comp // stored verbatim
+	} else {
+		data = make([]byte, rawLen)
+		n, err := decompressWhole(comp, data, decodeConfig{guard: true})
+		if err != nil {
+			return nil, err
+		}
+		if n != len(data) {
+			return nil, ErrCorrupted
+		}
+	}
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, errFrameChecksum
+	}
+	return data, nil
+}
+
+// DecompressPrefixed decodes a message made of its decompressed length as
+// a uvarint (encoding/binary) followed by an LZO1Z stream, allocating
+// exactly that many output bytes. It returns the output and the number of
+// bytes of src the message took up; data after the stream's EOF marker is
+// left for the caller, so messages can be read back to back. An empty
+// message needs the 3-byte EOF marker for that, which Compress does not
+// write for empty input; at the end of src it can go without.
+//
+// A prefix cut short returns ErrInputOverrun. An invalid prefix, or one
+// that disagrees with the length the stream decodes to, returns
+// ErrCorrupted. Errors from decoding the stream are DecodeErrors with
+// positions relative to the start of src.
+func DecompressPrefixed(src []byte) ([]byte, int, error) {
+	rawLen, k := binary.Uvarint(src)
+	if k == 0 {
+		return nil, 0, ErrInputOverrun
+	}
+	// Each input byte expands to at most 255 output bytes, which bounds
+	// the allocation a corrupt prefix can trigger
+	if k < 0 || rawLen > 255*uint64(len(src)-k) || rawLen > math.MaxInt {
+		return nil, 0, ErrCorrupted
+	}
+
+	dst := make([]byte, rawLen)
+	n, ip, err := decompress(src[k:], dst, decodeConfig{guard: true})
+	if errors.Is(err, ErrOutputOverrun) {
+		return nil, 0, ErrCorrupted // the stream is longer than the prefix
+	}
+	if err != nil {
+		err.(*DecodeError).InputPos += k
+		return nil, 0, err
+	}
+	if n != len(dst) {
+		return nil, 0, ErrCorrupted
+	}
+	return dst, k + ip, nil
+}
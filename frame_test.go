@@ -0,0 +1,247 @@
+package lzo1z
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func TestFrameRoundtrip(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("hello, frame"),
+		{},
+		bytes.Repeat([]byte("frames back to back "), 500),
+	}
+
+	var buf bytes.Buffer
+	for _, input := range inputs {
+		if err := WriteFrame(&buf, input); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+
+	r := bytes.NewReader(buf.Bytes())
+	for i, want := range inputs {
+		got, err := ReadFrame(r)
+		if err != nil {
+			t.Fatalf("frame %d: ReadFrame failed: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("frame %d: got %d bytes, want %d", i, len(got), len(want))
+		}
+	}
+	if _, err := ReadFrame(r); err != io.EOF {
+		t.Errorf("after last frame: expected io.EOF, got %v", err)
+	}
+}
+
+func TestFrameStored(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{1, 15, 16, 1000, 100000} {
+		input := make([]byte, size)
+		rng.Read(input)
+
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, input); err != nil {
+			t.Fatalf("size %d: WriteFrame failed: %v", size, err)
+		}
+		if buf.Len() > frameHeaderLen+size {
+			t.Errorf("size %d: frame is %d bytes, want at most %d", size, buf.Len(), frameHeaderLen+size)
+		}
+
+		got, err := ReadFrame(&buf)
+		if err != nil || !bytes.Equal(got, input) {
+			t.Errorf("size %d: roundtrip failed: %v", size, err)
+		}
+	}
+}
+
+func TestFrameTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, bytes.Repeat([]byte("truncate me "), 20)); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	frame := buf.Bytes()
+
+	for cut := 1; cut < len(frame); cut++ {
+		if _, err := ReadFrame(bytes.NewReader(frame[:cut])); !errors.Is(err, ErrInputOverrun) {
+			t.Fatalf("cut at %d: expected ErrInputOverrun, got %v", cut, err)
+		}
+	}
+}
+
+func TestFrameCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, bytes.Repeat([]byte("hello, frame "), 4)); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	frame := buf.Bytes()
+
+	var stored bytes.Buffer
+	if err := WriteFrame(&stored, []byte("hello, frame")); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+
+	flip := func(frame []byte, i int) []byte {
+		b := append([]byte{}, frame...)
+		b[i] ^= 0x01
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		frame   []byte
+		wantErr error
+	}{
+		{"magic", flip(frame, 0), ErrCorrupted},
+		{"length", flip(frame, 7), ErrCorrupted},
+		{"checksum", flip(frame, 11), ErrChecksumMismatch},
+		// The payload opens with a literal run; flipping a literal still decodes
+		{"payload_literal", flip(frame, frameHeaderLen+1), ErrChecksumMismatch},
+		{"stored_payload", flip(stored.Bytes(), frameHeaderLen), ErrChecksumMismatch},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadFrame(bytes.NewReader(tc.frame))
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+			// Every damaged frame is ErrCorrupted, checksum failures included
+			if !errors.Is(err, ErrCorrupted) {
+				t.Errorf("expected an error matching ErrCorrupted, got %v", err)
+			}
+		})
+	}
+}
+
+// prefixed returns input compressed behind a uvarint prefix of rawLen.
+func prefixed(t *testing.T, rawLen int, input []byte) []byte {
+	t.Helper()
+	msg, err := CompressAppend(binary.AppendUvarint(nil, uint64(rawLen)), input)
+	if err != nil {
+		t.Fatalf("CompressAppend failed: %v", err)
+	}
+	return msg
+}
+
+func TestFrameHostileHeader(t *testing.T) {
+	// A compressed length far beyond what a 1-byte frame compresses to,
+	// with no payload behind it, must fail without allocating it
+	hdr := []byte("LZ1Z\x00\x00\x00\x01\x00\x00\x00\x00\xff\xff\xff\xff")
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ReadFrame(bytes.NewReader(hdr))
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, got %v", err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("allocated %d bytes for a 16-byte frame", alloc)
+	}
+
+	// A plausible length is read as the payload arrives
+	hdr = []byte("LZ1Z\x10\x00\x00\x00\x00\x00\x00\x00\x10\x00\x00\x00")
+	runtime.ReadMemStats(&before)
+	_, err = ReadFrame(bytes.NewReader(append(hdr, "cut short"...)))
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("expected ErrInputOverrun, got %v", err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("allocated %d bytes for a 25-byte frame", alloc)
+	}
+}
+
+func TestWriteFrameTooLarge(t *testing.T) {
+	n := uint64(1) << 32
+	if n > math.MaxInt {
+		t.Skip("a 4 GiB slice does not fit in int")
+	}
+	// Never written to, so the pages are not touched
+	data := make([]byte, int(n))
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, data); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("WriteFrame: expected ErrFrameTooLarge, got %v", err)
+	}
+	if err := NewArchiveWriter(&buf).Add("big", data); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("ArchiveWriter.Add: expected ErrFrameTooLarge, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %d bytes", buf.Len())
+	}
+}
+
+func TestDecompressPrefixed(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("hello, prefix"),
+		{},
+		bytes.Repeat([]byte("messages back to back "), 500),
+	}
+
+	var src []byte
+	for _, input := range inputs {
+		if len(input) == 0 {
+			// Compress writes nothing for empty input; back to back,
+			// the message needs the EOF marker to end
+			src = append(src, 0x00, 0x11, 0x00, 0x00)
+			continue
+		}
+		src = append(src, prefixed(t, len(input), input)...)
+	}
+	for i, input := range inputs {
+		got, n, err := DecompressPrefixed(src)
+		if err != nil {
+			t.Fatalf("message %d: DecompressPrefixed failed: %v", i, err)
+		}
+		if !bytes.Equal(got, input) || len(got) != cap(got) {
+			t.Errorf("message %d: got %d bytes (cap %d), want %d", i, len(got), cap(got), len(input))
+		}
+		src = src[n:]
+	}
+	if len(src) != 0 {
+		t.Errorf("%d bytes left after the last message", len(src))
+	}
+
+	// An empty message at the end of src may omit the marker
+	if got, n, err := DecompressPrefixed([]byte{0x00}); len(got) != 0 || n != 1 || err != nil {
+		t.Errorf("empty message = (%q, %d, %v), want (\"\", 1, nil)", got, n, err)
+	}
+}
+
+func TestDecompressPrefixedErrors(t *testing.T) {
+	input := bytes.Repeat([]byte("prefix "), 40)
+	msg := prefixed(t, len(input), input)
+
+	tests := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"too_small", prefixed(t, len(input)-1, input), ErrCorrupted},
+		{"too_large", prefixed(t, len(input)+1, input), ErrCorrupted},
+		{"beyond_any_ratio", prefixed(t, 1<<30, input), ErrCorrupted},
+		{"empty", nil, ErrInputOverrun},
+		{"prefix_cut", []byte{0x80}, ErrInputOverrun},
+		{"prefix_overflow", bytes.Repeat([]byte{0xff}, 11), ErrCorrupted},
+		{"stream_cut", msg[:len(msg)-1], ErrInputOverrun},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := DecompressPrefixed(tc.src); !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	// Stream errors report positions in src, past the prefix
+	bad := append(binary.AppendUvarint(nil, 300), 0x11, 0x00, 0x04, 0x11, 0x00, 0x00)
+	var de *DecodeError
+	if _, _, err := DecompressPrefixed(bad); !errors.As(err, &de) || de.InputPos != 2 {
+		t.Errorf("expected a DecodeError at input 2, got %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package lzo1z
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"testing"
 )
 
@@ -52,26 +54,176 @@ func FuzzRoundtrip(f *testing.F) {
 	})
 }
 
-// FuzzDecompress tests that the decompressor handles arbitrary input
-// without panicking (may return errors, which is fine).
-func FuzzDecompress(f *testing.F) {
-	// Seed with valid compressed data
-	f.Add([]byte{0x11, 0x00, 0x00})                                     // Empty
-	f.Add([]byte{0x12, 0x41, 0x11, 0x00, 0x00})                         // Single literal
-	f.Add([]byte{0x12, 0x41, 0x20, 0x06, 0x00, 0x00, 0x11, 0x00, 0x00}) // With match
+// decompressSeeds is the seed corpus of FuzzDecompress.
+var decompressSeeds = [][]byte{
+	// Valid compressed data
+	{0x11, 0x00, 0x00},                                     // Empty
+	{0x12, 0x41, 0x11, 0x00, 0x00},                         // Single literal
+	{0x12, 0x41, 0x20, 0x06, 0x00, 0x00, 0x11, 0x00, 0x00}, // With match
 
 	// Invalid/malformed data
-	f.Add([]byte{})
-	f.Add([]byte{0x00})
-	f.Add([]byte{0xff, 0xff, 0xff})
-	f.Add([]byte{0x20})             // Truncated M3
-	f.Add([]byte{0x11, 0x00})       // Truncated EOF
-	f.Add([]byte{0x40, 0x00})       // M2 with zero offset
-	f.Add([]byte{0x10, 0x00, 0x00}) // M4 EOF marker
+	{},
+	{0x00},
+	{0xff, 0xff, 0xff},
+	{0x20},             // Truncated M3
+	{0x11, 0x00},       // Truncated EOF
+	{0x40, 0x00},       // M2 with zero offset
+	{0x10, 0x00, 0x00}, // M4 EOF marker
+}
+
+// FuzzDecompress tests that the decompressor handles arbitrary input
+// without panicking (may return errors, which is fine). Decompress and
+// the unguarded walk run without the panic guard, so a decoder bug shows
+// up as the crash it is rather than as ErrCorrupted.
+func FuzzDecompress(f *testing.F) {
+	for _, seed := range decompressSeeds {
+		f.Add(seed)
+	}
 
 	f.Fuzz(func(t *testing.T, input []byte) {
 		// Just ensure no panic - errors are expected for random input
 		output := make([]byte, 64*1024)
-		_, _ = Decompress(input, output)
+		n, err := Decompress(input, output)
+		decodeTokens(input, nil, decodeConfig{walk: true}, decodeState{})
+
+		// The size walk must agree with the decoder whenever the output fits
+		size, sizeErr := DecompressedSize(input)
+		if err == nil && (sizeErr != nil || size != n) {
+			t.Errorf("DecompressedSize = (%d, %v), Decompress = (%d, nil)", size, sizeErr, n)
+		}
+		if sizeErr == nil && size <= len(output) && err != nil {
+			t.Errorf("DecompressedSize = %d, but Decompress failed: %v", size, err)
+		}
+		if err != nil && !errors.Is(err, ErrOutputOverrun) && !errors.Is(err, sizeErr) {
+			t.Errorf("DecompressedSize error %v, Decompress error %v", sizeErr, err)
+		}
 	})
 }
+
+// FuzzDictRoundtrip tests that any input compressed against any dictionary
+// decompresses back to the original with that dictionary.
+func FuzzDictRoundtrip(f *testing.F) {
+	f.Add([]byte{}, []byte{})
+	f.Add([]byte("Hello, World!"), []byte{})
+	f.Add([]byte{}, []byte("Hello, World!"))
+	f.Add([]byte("Hello"), []byte("Hello, World!"))
+	f.Add([]byte("Hello, World! Hello, World!"), []byte("Hello, World!"))
+	f.Add(bytes.Repeat([]byte("The quick brown fox. "), 10), []byte("The quick brown fox. "))
+	f.Add(bytes.Repeat([]byte{0}, 100), bytes.Repeat([]byte{0}, 100))
+
+	f.Fuzz(func(t *testing.T, input, dict []byte) {
+		if len(input) > 64*1024 || len(dict) > 64*1024 {
+			// Skip very large inputs for speed
+			return
+		}
+
+		compBuf := make([]byte, MaxCompressedSize(len(input)))
+		compLen, err := CompressWithDict(input, compBuf, dict)
+		if err != nil {
+			t.Fatalf("CompressWithDict failed: %v", err)
+		}
+
+		decompBuf := make([]byte, len(input)+100)
+		decompLen, err := DecompressWithDict(compBuf[:compLen], decompBuf, dict)
+		if err != nil {
+			t.Fatalf("DecompressWithDict failed: %v", err)
+		}
+		if !bytes.Equal(input, decompBuf[:decompLen]) {
+			t.Errorf("Roundtrip mismatch: input len=%d, output len=%d", len(input), decompLen)
+		}
+	})
+}
+
+// FuzzFrame tests that ReadFrame handles arbitrary input without
+// panicking, and that any input survives a WriteFrame/ReadFrame roundtrip.
+func FuzzFrame(f *testing.F) {
+	// Seed with valid frames, compressed and stored, and cut ones
+	for _, data := range [][]byte{
+		{},
+		[]byte("hello, frame"),
+		bytes.Repeat([]byte("frame "), 100),
+	} {
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, data); err != nil {
+			f.Fatalf("WriteFrame failed: %v", err)
+		}
+		frame := buf.Bytes()
+		f.Add(frame)
+		f.Add(frame[:len(frame)-1])
+		f.Add(frame[:frameHeaderLen])
+	}
+	f.Add([]byte("LZ1Z"))
+	f.Add([]byte("LZ1Z\xff\xff\xff\xff\x00\x00\x00\x00\x00\x00\x00\x01\x00"))
+	f.Add([]byte("LZ1Z\x00\x00\x00\x01\x00\x00\x00\x00\xff\xff\xff\xff"))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		// Just ensure no panic - errors are expected for random input.
+		// ReadFrame recovers decoder panics; FuzzDecompress finds those.
+		_, _ = ReadFrame(bytes.NewReader(input))
+
+		if len(input) > 64*1024 {
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, input); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+		got, err := ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("Roundtrip mismatch: input len=%d, output len=%d", len(input), len(got))
+		}
+	})
+}
+
+// TestDecompressNoPanicEscapes feeds the FuzzDecompress seeds and the test
+// vectors, each cut at every length and with every byte flipped, to the
+// decode entry points. None may let a panic escape.
+func TestDecompressNoPanicEscapes(t *testing.T) {
+	inputs := append([][]byte{}, decompressSeeds...)
+	for _, tc := range append(testCases, interopTestCases...) {
+		if len(tc.compressed) > 0 && len(tc.compressed) <= 256 {
+			inputs = append(inputs, tc.compressed)
+		}
+	}
+	var corpus [][]byte
+	for _, in := range inputs {
+		for n := 0; n <= len(in); n++ {
+			corpus = append(corpus, in[:n])
+		}
+		for i := range in {
+			flipped := append([]byte{}, in...)
+			flipped[i] ^= 0xff
+			corpus = append(corpus, flipped)
+		}
+	}
+
+	noPanic := func(name string, in []byte, decode func()) {
+		t.Helper()
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("%s(%x) panicked: %v", name, in, r)
+			}
+		}()
+		decode()
+	}
+	dst := make([]byte, 4096)
+	for _, in := range corpus {
+		noPanic("Decompress", in, func() { _, _ = Decompress(in, dst) })
+		noPanic("Decompress into a small dst", in, func() { _, _ = Decompress(in, dst[:3]) })
+		noPanic("DecompressedSize", in, func() { _, _ = DecompressedSize(in) })
+		noPanic("DecompressLZO1X", in, func() { _, _ = DecompressLZO1X(in, dst) })
+		noPanic("DecompressWithDict", in, func() { _, _ = DecompressWithDict(in, dst, []byte("dictionary")) })
+		noPanic("DecompressTo", in, func() { _, _ = DecompressTo(io.Discard, in) })
+		noPanic("Decompressor", in, func() {
+			d := NewDecompressor()
+			for i := range in {
+				if _, err := d.Decompress(in[i:i+1], dst); !errors.Is(err, ErrInputOverrun) {
+					break
+				}
+			}
+		})
+	}
+}
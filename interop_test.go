@@ -145,10 +145,10 @@ func TestDecompressInputNotConsumed(t *testing.T) {
 
 func TestDecompressDictionaryRejection(t *testing.T) {
 	// C supports lzo1z_999_compress_dict which produces data that requires
-	// a dictionary to decompress. The Go decompressor does not support
-	// dictionary decompression. Dictionary-compressed data will typically
-	// fail with ErrLookbehindOverrun because it references data that should
-	// be in the dictionary (before the output buffer start).
+	// a dictionary to decompress, as does CompressWithDict. Decompress,
+	// given no dictionary, will typically fail on such data with
+	// ErrLookbehindOverrun because it references data that should be in
+	// the dictionary (before the output buffer start).
 	//
 	// This test documents the behavior: dictionary-compressed data produces
 	// an error rather than silently corrupting output.
@@ -0,0 +1,24 @@
+package lzo1z
+
+// DecompressLZO1X decompresses LZO1X compressed data from src into dst.
+// Returns the number of bytes written to dst.
+//
+// It shares the LZO1Z decoder and differs only where the formats do:
+// M3/M4 offsets are decoded as (ip[0] >> 2) + (ip[1] << 6), M2 matches
+// carry three offset bits in the opcode and never reuse the last offset,
+// M1 offsets follow the LZO1X layout, and the trailing literal count is
+// taken from the first offset byte rather than the last.
+//
+// This function is compatible with data compressed by the lzo1x_1 and
+// lzo1x_999 compressors from the liblzo2 library. Errors are reported
+// exactly as by Decompress.
+func DecompressLZO1X(src, dst []byte) (int, error) {
+	op, ip, err := decompress(src, dst, decodeConfig{lzo1x: true})
+	if err != nil {
+		return op, err
+	}
+	if ip < len(src) {
+		return op, errNotConsumed(ip, op)
+	}
+	return op, nil
+}
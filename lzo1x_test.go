@@ -0,0 +1,205 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// LZO1X vectors produced by lzo1x_999 compression of lzo1xMixedInput and
+// lzo1xFarInput. They exercise M1, M2, M3 and M4 matches, extended lengths
+// and trailing literals in the LZO1X opcode layout.
+//
+// Generated with liblzo2 2.10 (Debian bookworm liblzo2-dev 2.10-2) by
+// testdata/gen/gen_vectors.c:
+//
+//	cd testdata/gen && docker build -t lzo1z-gen . && docker run --rm lzo1z-gen lzo1x
+var (
+	lzo1xMixedCompressed = []byte{
+		0x32, 0x61, 0x62, 0x61, 0x78, 0x79, 0x41, 0x61, 0x62, 0x62, 0x78, 0x79, 0x42, 0x61, 0x62, 0x63,
+		0x78, 0x79, 0x43, 0x61, 0x62, 0x64, 0x78, 0x79, 0x44, 0x61, 0x62, 0x65, 0x78, 0x79, 0x45, 0x61,
+		0x62, 0x66, 0x95, 0x03, 0x67, 0x95, 0x03, 0x61, 0x95, 0x03, 0x62, 0x95, 0x03, 0x63, 0x95, 0x03,
+		0x64, 0x95, 0x03, 0x65, 0x95, 0x03, 0x66, 0x95, 0x03, 0x67, 0x95, 0x03, 0x61, 0x95, 0x03, 0x62,
+		0x95, 0x03, 0x63, 0x95, 0x03, 0x64, 0x95, 0x03, 0x65, 0x95, 0x03, 0x66, 0x95, 0x03, 0x67, 0x95,
+		0x03, 0x61, 0x95, 0x03, 0x62, 0x95, 0x03, 0x63, 0x95, 0x03, 0x64, 0x95, 0x03, 0x65, 0x95, 0x03,
+		0x66, 0x95, 0x03, 0x67, 0x95, 0x03, 0x61, 0x95, 0x03, 0x62, 0x95, 0x03, 0x63, 0x95, 0x03, 0x64,
+		0x95, 0x03, 0x65, 0x95, 0x03, 0x66, 0x95, 0x03, 0x67, 0x95, 0x03, 0x61, 0x95, 0x03, 0x62, 0x95,
+		0x03, 0x63, 0x95, 0x03, 0x64, 0x94, 0x03, 0x44, 0x05, 0x00, 0x0f, 0x45, 0x54, 0x68, 0x65, 0x20,
+		0x71, 0x75, 0x69, 0x63, 0x6b, 0x20, 0x62, 0x72, 0x6f, 0x77, 0x6e, 0x20, 0x66, 0x6f, 0x78, 0x20,
+		0x6a, 0x75, 0x6d, 0x70, 0x73, 0x20, 0x6f, 0x76, 0x65, 0x72, 0x20, 0x74, 0x58, 0x03, 0x07, 0x6c,
+		0x61, 0x7a, 0x79, 0x20, 0x64, 0x6f, 0x67, 0x2e, 0x20, 0x20, 0x66, 0xb0, 0x00, 0x00, 0x52, 0x00,
+		0x7a, 0x71, 0x07, 0x0e, 0x15, 0x7a, 0x71, 0x1c, 0x23, 0x2a, 0x7a, 0x71, 0x31, 0x38, 0x3f, 0x7a,
+		0x71, 0x46, 0x4d, 0x54, 0x7a, 0x71, 0x5b, 0x62, 0x69, 0x7a, 0x71, 0x70, 0x77, 0x7e, 0x7a, 0x71,
+		0x85, 0x8c, 0x93, 0x7a, 0x71, 0x9a, 0xa1, 0xa8, 0x7a, 0x71, 0xaf, 0xb6, 0xbd, 0x7a, 0x71, 0xc4,
+		0xcb, 0xd2, 0x7a, 0x71, 0xd9, 0xe0, 0xe7, 0x7a, 0x71, 0xee, 0xf5, 0x01, 0x7a, 0x71, 0x08, 0x0f,
+		0x16, 0x7a, 0x71, 0x1d, 0x24, 0x2b, 0x7a, 0x71, 0x32, 0x39, 0x40, 0x7a, 0x71, 0x47, 0x4e, 0x55,
+		0x7a, 0x71, 0x5c, 0x63, 0x6a, 0x7a, 0x71, 0x71, 0x78, 0x7f, 0x7a, 0x71, 0x86, 0x8d, 0x94, 0x7a,
+		0x71, 0x9b, 0xa2, 0x20, 0x39, 0xf4, 0x02, 0x11, 0x00, 0x00,
+	}
+	lzo1xFarCompressed = []byte{
+		0x00, 0xe7, 0x46, 0x41, 0x52, 0x2d, 0x4d, 0x41, 0x54, 0x43, 0x48, 0x2d, 0x48, 0x45, 0x41, 0x44,
+		0x45, 0x52, 0x3a, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x61, 0x62, 0x63,
+		0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0x73,
+		0x74, 0x75, 0x76, 0xc6, 0x7e, 0x81, 0x6b, 0x4b, 0xfb, 0xe2, 0xfb, 0x54, 0xf6, 0xbd, 0xdf, 0x7c,
+		0x1c, 0xe1, 0x87, 0x01, 0xbf, 0x31, 0xde, 0x56, 0x72, 0x0f, 0x47, 0x67, 0x66, 0x87, 0x59, 0xaa,
+		0x88, 0x3c, 0x59, 0xea, 0x56, 0x13, 0x7b, 0xd2, 0x85, 0xa1, 0xd8, 0x3c, 0x54, 0x55, 0x2f, 0x37,
+		0xae, 0x65, 0x5b, 0xda, 0x02, 0x79, 0x98, 0xcc, 0xe3, 0x1a, 0x76, 0x8e, 0x5f, 0xd9, 0x99, 0x8f,
+		0x1f, 0x3f, 0x36, 0xee, 0x43, 0x78, 0x4d, 0x0d, 0xfa, 0xbe, 0xa6, 0xda, 0xe4, 0x86, 0x8e, 0xdc,
+		0x29, 0x6d, 0x4e, 0xff, 0x56, 0xe1, 0x70, 0x20, 0xfb, 0x8f, 0xb1, 0x58, 0x05, 0x90, 0xc5, 0x09,
+		0xdc, 0x53, 0xcd, 0xaa, 0x3b, 0x48, 0x99, 0x52, 0xd3, 0x52, 0x9d, 0x06, 0x9f, 0xea, 0xb5, 0xc2,
+		0x06, 0x13, 0x98, 0x49, 0xb2, 0x01, 0x1e, 0xac, 0x32, 0x88, 0x31, 0x9c, 0x52, 0x46, 0x95, 0x71,
+		0x36, 0x8f, 0x57, 0xf6, 0x39, 0x1d, 0x16, 0xfa, 0x88, 0x74, 0xf5, 0x98, 0x7c, 0x17, 0x5c, 0x41,
+		0xbb, 0x6d, 0x71, 0x8e, 0x0f, 0x70, 0x59, 0xc7, 0x01, 0x1b, 0x2f, 0x33, 0x3d, 0x91, 0xc0, 0x1d,
+		0xa5, 0x0d, 0x0d, 0xab, 0x33, 0x8d, 0x7e, 0x5e, 0x8f, 0x3e, 0xe6, 0x68, 0x74, 0xa6, 0x3a, 0xb1,
+		0xc3, 0x93, 0x11, 0xa8, 0x64, 0xc7, 0xdb, 0xca, 0xe0, 0x60, 0xe1, 0xf3, 0xbf, 0x09, 0x00, 0x67,
+		0xa2, 0xe3, 0x25, 0xa0, 0x21, 0x31, 0x87, 0xd5, 0x62, 0xc5, 0xa8, 0x20, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0xe6, 0x1c, 0x03, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xe6, 0x1c,
+		0x03, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xe6, 0x1c, 0x03, 0x20, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0xe6, 0x1c, 0x03, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xe6,
+		0x1c, 0x03, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xe6, 0x1c, 0x03, 0x20, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0xe6, 0x1c, 0x03, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xe6, 0x1c, 0x03, 0x20, 0x00, 0x80, 0x1c, 0x03, 0x10, 0x28, 0x64, 0x0a, 0x11, 0x00, 0x00,
+	}
+)
+
+// lzo1xMixedInput mixes short repeats, a text run and noise with sparse
+// 2-byte repeats.
+func lzo1xMixedInput() []byte {
+	var b bytes.Buffer
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&b, "ab%cxy%c", 'a'+i%7, 'A'+i%5)
+	}
+	b.WriteString(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 4))
+	for i := 0; i < 60; i++ {
+		b.WriteByte(byte(i * 7 % 251))
+		if i%3 == 0 {
+			b.WriteString("zq")
+		}
+	}
+	b.WriteString(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 2))
+	return b.Bytes()
+}
+
+// lzo1xFarInput repeats a header more than 16 KiB apart so the second copy
+// needs an M4 match.
+func lzo1xFarInput() []byte {
+	var b bytes.Buffer
+	head := []byte("FAR-MATCH-HEADER:0123456789abcdefghijklmnopqrstuv")
+	b.Write(head)
+	block := make([]byte, 200)
+	x := uint32(1)
+	for i := range block {
+		x = x*1103515245 + 12345
+		block[i] = byte(x >> 16)
+	}
+	for b.Len() < 17000 {
+		b.Write(block)
+	}
+	b.Write(head)
+	return b.Bytes()
+}
+
+func TestDecompressLZO1XVectors(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      []byte
+		compressed []byte
+	}{
+		{"mixed", lzo1xMixedInput(), lzo1xMixedCompressed},
+		{"far", lzo1xFarInput(), lzo1xFarCompressed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := make([]byte, len(tc.input))
+			n, err := DecompressLZO1X(tc.compressed, dst)
+			if err != nil {
+				t.Fatalf("DecompressLZO1X failed: %v", err)
+			}
+			if !bytes.Equal(dst[:n], tc.input) {
+				t.Errorf("output mismatch")
+			}
+
+			// The same stream is not valid LZO1Z
+			n, err = Decompress(tc.compressed, dst)
+			if err == nil && bytes.Equal(dst[:n], tc.input) {
+				t.Errorf("LZO1X stream decoded as LZO1Z")
+			}
+		})
+	}
+}
+
+func TestDecompressLZO1XNoOffsetReuse(t *testing.T) {
+	// M2 opcode 0x5c has (t & 0x1f) == 0x1c, which LZO1Z treats as
+	// last-offset reuse. In LZO1X it is length 3, offset 1+7+(0<<3).
+	compressed := []byte{
+		0x1a, 'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H', 'I', // 9 literals
+		0x5c, 0x00, // M2: length 3, offset 8
+		0x11, 0x00, 0x00, // EOF
+	}
+
+	dst := make([]byte, 20)
+	n, err := DecompressLZO1X(compressed, dst)
+	if err != nil {
+		t.Fatalf("DecompressLZO1X failed: %v", err)
+	}
+	if string(dst[:n]) != "ABCDEFGHIBCD" {
+		t.Errorf("got %q, want %q", dst[:n], "ABCDEFGHIBCD")
+	}
+}
+
+func TestDecompressLZO1XM1(t *testing.T) {
+	// M1 in match state: offset 1+(t>>2)+(b<<2), 2 bytes
+	compressed := []byte{
+		0x14, 'A', 'B', 'C', // 3 literals, then match state
+		0x04, 0x00, // M1: offset 2
+		0x11, 0x00, 0x00, // EOF
+	}
+	dst := make([]byte, 10)
+	n, err := DecompressLZO1X(compressed, dst)
+	if err != nil {
+		t.Fatalf("DecompressLZO1X failed: %v", err)
+	}
+	if string(dst[:n]) != "ABCBC" {
+		t.Errorf("got %q, want %q", dst[:n], "ABCBC")
+	}
+
+	// M1 after a literal run: offset 1+0x800+(t>>2)+(b<<2), 3 bytes
+	const lits = 2100
+	stream := literalRun(lits)
+	lit := stream[len(stream)-lits:]
+	for i := range lit {
+		lit[i] = byte(i % 251)
+	}
+	stream = append(stream, 0x04, 0x01, 0x11, 0x00, 0x00) // offset 2054, EOF
+	want := append(append([]byte{}, lit...), lit[lits-2054:lits-2051]...)
+
+	dst = make([]byte, lits+10)
+	n, err = DecompressLZO1X(stream, dst)
+	if err != nil {
+		t.Fatalf("DecompressLZO1X failed: %v", err)
+	}
+	if !bytes.Equal(dst[:n], want) {
+		t.Errorf("M1 after literal run: output mismatch")
+	}
+}
+
+func TestDecompressLZO1XErrors(t *testing.T) {
+	dst := make([]byte, 100)
+
+	if _, err := DecompressLZO1X([]byte{0x15, 0x41, 0x42}, dst); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("truncated: expected ErrInputOverrun, got %v", err)
+	}
+	if _, err := DecompressLZO1X([]byte{0x12, 0x41, 0x11, 0x00, 0x00, 0x00}, dst); !errors.Is(err, ErrInputNotConsumed) {
+		t.Errorf("trailing garbage: expected ErrInputNotConsumed, got %v", err)
+	}
+	// M3 at offset 1+(0xfc>>2)+(0xff<<6), far beyond the 1 byte written
+	if _, err := DecompressLZO1X([]byte{0x12, 0x41, 0x21, 0xfc, 0xff, 0x11, 0x00, 0x00}, dst); !errors.Is(err, ErrLookbehindOverrun) {
+		t.Errorf("lookbehind: expected ErrLookbehindOverrun, got %v", err)
+	}
+	if n, err := DecompressLZO1X(nil, dst); n != 0 || err != nil {
+		t.Errorf("empty: got (%d, %v), want (0, nil)", n, err)
+	}
+}
@@ -12,12 +12,16 @@
 //   - Different M2_MAX_OFFSET constant (0x0700 vs 0x0800)
 package lzo1z
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Algorithm constants
 const (
-	m2MaxOffset = 0x0700 // 1792 - LZO1Z specific (LZO1X uses 0x0800)
-	m4MaxOffset = 0x4000 // 16384 - same across LZO variants
+	m2MaxOffset      = 0x0700 // 1792 - LZO1Z specific (LZO1X uses 0x0800)
+	m2MaxOffsetLZO1X = 0x0800 // 2048 - M1 base offset after a literal run in LZO1X
+	m4MaxOffset      = 0x4000 // 16384 - same across LZO variants
 )
 
 // Errors returned by Decompress
@@ -27,8 +31,34 @@ var (
 	ErrLookbehindOverrun = errors.New("lzo1z: lookbehind overrun (match references before output start)")
 	ErrCorrupted         = errors.New("lzo1z: corrupted input data")
 	ErrInputNotConsumed  = errors.New("lzo1z: input not fully consumed (extra bytes after EOF marker)")
+	ErrMatchTooLong      = errors.New("lzo1z: match longer than the configured limit")
+	ErrChecksumMismatch  = errors.New("lzo1z: decompressed data does not match expected checksum")
+	ErrWindowTooSmall    = errors.New("lzo1z: window buffer too small for the stream")
+	ErrOutputTooLarge    = errors.New("lzo1z: output larger than the configured limit")
 )
 
+// DecodeError records where decoding a stream failed. Decompress and the
+// other decoding functions return the sentinel errors above wrapped in a
+// *DecodeError, so errors.Is(err, ErrLookbehindOverrun) and similar checks
+// keep working.
+type DecodeError struct {
+	Err       error // one of the sentinel errors
+	InputPos  int   // offset in the input of the opcode that failed
+	OutputPos int   // output bytes written before that opcode
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v at input offset %d, output offset %d", e.Err, e.InputPos, e.OutputPos)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// errNotConsumed reports input left over after the EOF marker at ip, once
+// op bytes were decoded.
+func errNotConsumed(ip, op int) error {
+	return &DecodeError{Err: ErrInputNotConsumed, InputPos: ip, OutputPos: op}
+}
+
 // Decompress decompresses LZO1Z compressed data from src into dst.
 // Returns the number of bytes written to dst.
 //
@@ -36,37 +66,351 @@ var (
 // If dst is too small, ErrOutputOverrun is returned along with the
 // number of bytes successfully written.
 //
+// An empty src is an empty stream and returns (0, nil). A src that ends
+// before the EOF marker, such as a single byte of an opcode that needs
+// more, returns ErrInputOverrun; a literal run cut short is reported as
+// such even when dst is also too small for it.
+//
+// A match whose offset is smaller than its length overlaps the output it
+// is producing and repeats its last offset bytes, so offset 1 with length
+// 264 writes 264 copies of the previous byte. Decompress always resolves
+// such matches as LZO defines them, one byte after another, never as a
+// single block copy.
+//
 // This function is compatible with data compressed by lzo1z_999_compress()
 // from the liblzo2 library.
 func Decompress(src, dst []byte) (int, error) {
+	return decompressWhole(src, dst, decodeConfig{})
+}
+
+// decompressWhole implements Decompress with cfg.
+func decompressWhole(src, dst []byte, cfg decodeConfig) (int, error) {
+	op, ip, err := decompress(src, dst, cfg)
+	if err != nil {
+		return op, err
+	}
+
+	// Check for unconsumed input after EOF marker (matches C's LZO_E_INPUT_NOT_CONSUMED)
+	if ip < len(src) {
+		return op, errNotConsumed(ip, op)
+	}
+
+	return op, nil
+}
+
+// DecompressAppend decompresses src and appends the output to dst,
+// growing it as needed, and returns the extended slice. It suits callers
+// that do not know the decompressed size; Decompress remains the faster
+// choice when the size is known.
+//
+// Room for 4x the compressed length is reserved first and doubled each
+// time the output does not fit. On error dst is returned unextended.
+func DecompressAppend(dst, src []byte) ([]byte, error) {
+	return DecompressAppendMax(dst, src, 0)
+}
+
+// DecompressAppendMax is DecompressAppend with the output capped at
+// maxOutput bytes, for callers decoding untrusted input. The room it
+// reserves never exceeds maxOutput, and the decoder checks every token
+// against the room before copying it, so a stream that would exceed the
+// limit fails with ErrOutputTooLarge as soon as a token crosses it,
+// having allocated at most maxOutput bytes however far a single extended
+// match would expand. Zero or less means no limit.
+func DecompressAppendMax(dst, src []byte, maxOutput int) ([]byte, error) {
+	base := len(dst)
+	room := 4 * len(src)
+	if room < 64 {
+		room = 64
+	}
+	for {
+		if maxOutput > 0 && room > maxOutput {
+			room = maxOutput
+		}
+		if cap(dst)-base < room {
+			grown := make([]byte, base, base+room)
+			copy(grown, dst)
+			dst = grown
+		}
+		n, err := decompressWhole(src, dst[base:cap(dst)], decodeConfig{guard: true})
+		if errors.Is(err, ErrOutputOverrun) {
+			if maxOutput > 0 && cap(dst)-base >= maxOutput {
+				err.(*DecodeError).Err = ErrOutputTooLarge
+				return dst[:base], err
+			}
+			room = 2 * (cap(dst) - base)
+			continue
+		}
+		if err != nil {
+			return dst[:base], err
+		}
+		return dst[:base+n], nil
+	}
+}
+
+// DecompressAt decompresses an LZO1Z stream that starts at src[offset] and
+// is followed by unrelated data, as in container formats.
+// Returns the number of bytes written to dst and the offset in src just
+// past the stream's EOF marker, where parsing of the container can resume.
+//
+// Unlike Decompress, bytes after the EOF marker are not an error.
+func DecompressAt(src []byte, offset int, dst []byte) (nOut, endOffset int, err error) {
+	if offset < 0 || offset >= len(src) {
+		return 0, offset, &DecodeError{Err: ErrInputOverrun, InputPos: offset}
+	}
+	op, ip, err := decompress(src[offset:], dst, decodeConfig{})
+	if de, ok := err.(*DecodeError); ok {
+		de.InputPos += offset
+	}
+	return op, offset + ip, err
+}
+
+// DecompressN decompresses the LZO1Z stream at the start of src into dst
+// and returns the number of bytes written to dst and the number of bytes
+// of src the stream occupies, up to and including its EOF marker. Data
+// after the stream, such as a protocol trailer, begins at src[nIn:].
+//
+// Unlike Decompress, bytes after the EOF marker are not an error. An
+// empty src decodes to nothing, like Decompress.
+func DecompressN(src, dst []byte) (nOut, nIn int, err error) {
+	return decompress(src, dst, decodeConfig{})
+}
+
+// DecompressAll decompresses src holding several independent LZO1Z streams
+// back to back, as some feeds append them, into dst one after another.
+// Returns the total number of bytes written to dst.
+//
+// Each stream ends at its EOF marker and is decoded on its own, so its
+// matches never reach into the output of the previous one. A DecodeError
+// reports positions relative to the start of src and dst.
+func DecompressAll(src, dst []byte) (int, error) {
+	ip, op := 0, 0
+	for ip < len(src) {
+		n, m, err := decompress(src[ip:], dst[op:], decodeConfig{})
+		if err != nil {
+			de := err.(*DecodeError)
+			de.InputPos += ip
+			de.OutputPos += op
+			return op + n, de
+		}
+		ip += m
+		op += n
+	}
+	return op, nil
+}
+
+// DecompressThen decompresses src into dst like Decompress and, on success,
+// calls transform once with the complete output dst[:n].
+//
+// The transform runs only after every match has been resolved, so matches
+// always copy the original decoded bytes and transform never observes
+// partial output. It is not called when decoding fails.
+func DecompressThen(src, dst []byte, transform func(out []byte)) (int, error) {
+	n, err := Decompress(src, dst)
+	if err != nil {
+		return n, err
+	}
+	transform(dst[:n])
+	return n, nil
+}
+
+// DecompressKnownSize decompresses src into dst, which must be exactly the
+// decompressed size, as recorded by a container format. A stream that
+// decodes to fewer bytes returns ErrCorrupted, one that needs more returns
+// ErrOutputOverrun, and trailing input returns ErrInputNotConsumed.
+//
+// Knowing the size does not let the decoder drop its output checks, which
+// are what reject a malformed stream instead of panicking; they are one
+// comparison per token, a small cost next to the copies. It therefore
+// decodes at the speed of Decompress and adds the exact-length check.
+func DecompressKnownSize(src, dst []byte) error {
+	n, err := Decompress(src, dst)
+	if err != nil {
+		return err
+	}
+	if n != len(dst) {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// DecompressInto decodes the output of CompressInto into dst, copying a
+// stored payload and decompressing a compressed one, and returns the
+// number of bytes written to dst. An empty src returns ErrInputOverrun
+// and an unknown mode byte ErrCorrupted. A dst too small for a stored
+// payload returns ErrOutputOverrun with as much of it as fits; a
+// compressed payload fails like Decompress, with DecodeError input
+// positions counted from the mode byte.
+func DecompressInto(dst, src []byte) (int, error) {
 	if len(src) == 0 {
-		return 0, nil
+		return 0, ErrInputOverrun
 	}
+	switch src[0] {
+	case modeStored:
+		n := copy(dst, src[1:])
+		if n < len(src)-1 {
+			return n, ErrOutputOverrun
+		}
+		return n, nil
+	case modeCompressed:
+		n, err := Decompress(src[1:], dst)
+		if de, ok := err.(*DecodeError); ok {
+			de.InputPos++
+		}
+		return n, err
+	}
+	return 0, ErrCorrupted
+}
 
-	ip := 0 // input position
-	op := 0 // output position
-	inLen := len(src)
-	outLen := len(dst)
-	var lastMOff int // last match offset (LZO1Z feature)
+// decodeConfig selects the format and optional checks applied by
+// decompress. The zero value decodes LZO1Z with all checks disabled.
+type decodeConfig struct {
+	maxMatchLen int               // longest match allowed, 0 means unlimited
+	maxRatio    int               // most output bytes per input byte consumed, 0 means unlimited
+	inBase      int               // stream offset of src[0], for maxRatio
+	lzo1x       bool              // decode the LZO1X opcode layout instead of LZO1Z
+	guard       bool              // report a decoder panic as ErrCorrupted, see decodeFrom
+	split       bool              // copy what fits of a long token that overruns dst, see splitLiterals
+	walk        bool              // check and count the output without writing it, dst is nil
+	ops         func(OpInfo) bool // called for every opcode in walk mode, see report
+	trace       *decodeTrace      // records token boundaries, see panicToken
+}
+
+// report passes info to cfg.ops, if set, and reports whether decoding
+// continues. It is only called in walk mode, once per opcode.
+func (cfg *decodeConfig) report(info OpInfo) bool {
+	return cfg.ops == nil || cfg.ops(info)
+}
+
+// overRatio reports whether out bytes of output from the first in bytes of
+// the stream exceed cfg.maxRatio. Dividing avoids overflowing in*maxRatio.
+func (cfg *decodeConfig) overRatio(out, in int) bool {
+	return cfg.maxRatio > 0 && (out-1)/cfg.maxRatio >= cfg.inBase+in
+}
 
-	// State machine states
-	const (
-		stateStart = iota
-		stateLiteralRun
-		stateFirstLiteralRun
-		stateMatch
-		stateMatchDone
-		stateMatchNext
-		stateEOF
-	)
+// errMissingEOF is reported by decodeStream when the input ends cleanly
+// between two opcodes without an EOF marker. Exported entry points report
+// it as ErrInputOverrun.
+var errMissingEOF = errors.New("lzo1z: missing EOF marker")
+
+// decompress decodes a single stream from the start of src, stopping at its
+// EOF marker. Returns the output length and the input position reached,
+// which is just past the EOF marker on success. Errors are *DecodeError.
+func decompress(src, dst []byte, cfg decodeConfig) (int, int, error) {
+	op, ip, tokIP, tok, err := decodeFrom(src, dst, cfg, decodeState{})
+	if err != nil {
+		if err == errMissingEOF {
+			err = ErrInputOverrun
+		}
+		return op, ip, &DecodeError{Err: err, InputPos: tokIP, OutputPos: tok.op}
+	}
+	return op, ip, nil
+}
+
+// decodeStream is decompress returning the bare sentinel errors, which
+// distinguishes a stream that was cut at an opcode boundary (errMissingEOF)
+// from one cut mid-token.
+func decodeStream(src, dst []byte, cfg decodeConfig) (int, int, error) {
+	op, ip, _, _, err := decodeFrom(src, dst, cfg, decodeState{})
+	return op, ip, err
+}
+
+// Decoder state machine states
+const (
+	stateStart = iota
+	stateLiteralRun
+	stateFirstLiteralRun
+	stateMatch
+	stateMatchDone
+	stateSplitLiterals // rest of a literal run cut by splitLiterals
+	stateSplitMatch    // rest of a match cut by splitMatch
+	stateEOF
+)
+
+// decodeState is the decoder position at a token boundary, from which
+// decoding can resume with the token's input.
+type decodeState struct {
+	state    int // stateStart, stateLiteralRun, stateFirstLiteralRun, stateMatch or a split state
+	op       int // output bytes written before the token
+	lastMOff int // last match offset before the token, 0 before the first match
+	rest     int // bytes of a split token still to copy
+}
+
+// decodeFrom implements decodeStream starting from st, with src beginning
+// at the token st describes. Besides the output and input positions it
+// returns the last token boundary reached and its input position, so a
+// caller that ran out of input (ErrInputOverrun or errMissingEOF) can
+// resume there once more input arrives.
+//
+// With cfg.guard set, a panic while decoding is reported as ErrCorrupted
+// at the token that caused it. Every index is bounds-checked, so a panic
+// can only be a decoder bug, but on untrusted input it would take a server
+// down with it. The guard costs a deferred call per decode, so only the
+// entry points meant for untrusted input set it; Decompress and the other
+// direct decoders, and the fuzz tests, run without it.
+func decodeFrom(src, dst []byte, cfg decodeConfig, st decodeState) (op, ip, tokIP int, tok decodeState, err error) {
+	if !cfg.guard {
+		return decodeTokens(src, dst, cfg, st)
+	}
+	defer func() {
+		if recover() != nil {
+			tokIP, tok = panicToken(src, dst, cfg, st)
+			op, ip, err = tok.op, tokIP, ErrCorrupted
+		}
+	}()
+	return decodeTokens(src, dst, cfg, st)
+}
+
+// decodeTrace records the last token boundary reached by decodeTokens.
+type decodeTrace struct {
+	ip int
+	st decodeState
+}
+
+// panicToken decodes src again after decodeTokens panicked, tracing every
+// token boundary, and returns the last one reached before the panic. The
+// decoder is deterministic, so the second run fails at the same token.
+func panicToken(src, dst []byte, cfg decodeConfig, st decodeState) (tokIP int, tok decodeState) {
+	tr := decodeTrace{st: st}
+	cfg.trace = &tr
+	defer func() {
+		recover()
+		tokIP, tok = tr.ip, tr.st
+	}()
+	decodeTokens(src, dst, cfg, st)
+	return
+}
+
+// decodeTokens is decodeFrom without the panic guard.
+//
+// With cfg.walk set, output that does not fit in dst, which is all of it
+// for a nil dst, is checked and counted but not written. The test sits in
+// the branch that would report ErrOutputOverrun, so decoding into a large
+// enough dst never reaches it.
+func decodeTokens(src, dst []byte, cfg decodeConfig, st decodeState) (op, ip, tokIP int, tok decodeState, err error) {
+	if st.state == stateStart {
+		if len(src) == 0 {
+			return st.op, 0, 0, st, nil
+		}
+		// A stream holding only the EOF marker, as liblzo2 writes for
+		// empty input: 0x11 reaches the M4 path, where offset 0 means EOF
+		if len(src) >= 3 && src[0] == 0x11 && src[1] == 0 && src[2] == 0 && cfg.ops == nil {
+			return st.op, 3, 0, st, nil
+		}
+	}
 
-	state := stateStart
+	ip = 0 // input position
+	op = st.op
+	inLen := len(src)
+	outLen := len(dst)
+	lastMOff := st.lastMOff // last match offset (LZO1Z feature)
+	state := st.state
+	tok = st
 
 	for state != stateEOF {
 		switch state {
 		case stateStart:
 			if ip >= inLen {
-				return op, ErrInputOverrun
+				return op, ip, tokIP, tok, ErrInputOverrun
 			}
 			t := int(src[ip])
 
@@ -74,33 +418,41 @@ func Decompress(src, dst []byte) (int, error) {
 				ip++
 				t -= 17
 				if t < 4 {
-					// Copy t literals, then matchNext
-					if op+t > outLen {
-						return op, ErrOutputOverrun
-					}
+					// Copy t literals, then matchNext. Truncated input is
+					// reported first, whatever the size of dst
 					if ip+t > inLen {
-						return op, ErrInputOverrun
+						return op, ip, tokIP, tok, ErrInputOverrun
 					}
-					for i := 0; i < t; i++ {
-						dst[op] = src[ip]
-						op++
-						ip++
+					if op+t <= outLen {
+						for i := 0; i < t; i++ {
+							dst[op+i] = src[ip+i]
+						}
+					} else if !cfg.walk {
+						return op, ip, tokIP, tok, ErrOutputOverrun
+					} else if !cfg.report(OpInfo{Kind: OpLiteral, InputPos: tokIP, OutputPos: op, Length: t}) {
+						return op, ip, tokIP, tok, errStopOps
 					}
-					state = stateMatchNext
+					op += t
+					ip += t
+					state = stateMatch
 					continue
 				}
 				// Copy t literals
-				if op+t > outLen {
-					return op, ErrOutputOverrun
-				}
 				if ip+t > inLen {
-					return op, ErrInputOverrun
+					return op, ip, tokIP, tok, ErrInputOverrun
 				}
-				for i := 0; i < t; i++ {
-					dst[op] = src[ip]
-					op++
-					ip++
+				if op+t <= outLen {
+					copy(dst[op:op+t], src[ip:ip+t])
+				} else if !cfg.walk {
+					if cfg.split && op < outLen {
+						return splitLiterals(src, dst, op, ip, t, lastMOff)
+					}
+					return op, ip, tokIP, tok, ErrOutputOverrun
+				} else if !cfg.report(OpInfo{Kind: OpLiteral, InputPos: tokIP, OutputPos: op, Length: t}) {
+					return op, ip, tokIP, tok, errStopOps
 				}
+				op += t
+				ip += t
 				state = stateFirstLiteralRun
 				continue
 			}
@@ -108,8 +460,12 @@ func Decompress(src, dst []byte) (int, error) {
 			state = stateLiteralRun
 
 		case stateLiteralRun:
+			tokIP, tok = ip, decodeState{state, op, lastMOff, 0}
+			if cfg.trace != nil {
+				cfg.trace.ip, cfg.trace.st = tokIP, tok
+			}
 			if ip >= inLen {
-				return op, ErrInputOverrun
+				return op, ip, tokIP, tok, errMissingEOF
 			}
 			t := int(src[ip])
 			ip++
@@ -129,7 +485,7 @@ func Decompress(src, dst []byte) (int, error) {
 					ip++
 				}
 				if ip >= inLen {
-					return op, ErrInputOverrun
+					return op, ip, tokIP, tok, ErrInputOverrun
 				}
 				t += 15 + int(src[ip])
 				ip++
@@ -137,22 +493,30 @@ func Decompress(src, dst []byte) (int, error) {
 
 			// Copy (t + 3) literal bytes
 			copyLen := t + 3
-			if op+copyLen > outLen {
-				return op, ErrOutputOverrun
-			}
 			if ip+copyLen > inLen {
-				return op, ErrInputOverrun
+				return op, ip, tokIP, tok, ErrInputOverrun
 			}
-			for i := 0; i < copyLen; i++ {
-				dst[op] = src[ip]
-				op++
-				ip++
+			if op+copyLen <= outLen {
+				copy(dst[op:op+copyLen], src[ip:ip+copyLen])
+			} else if !cfg.walk {
+				if cfg.split && op < outLen {
+					return splitLiterals(src, dst, op, ip, copyLen, lastMOff)
+				}
+				return op, ip, tokIP, tok, ErrOutputOverrun
+			} else if !cfg.report(OpInfo{Kind: OpLiteral, InputPos: tokIP, OutputPos: op, Length: copyLen}) {
+				return op, ip, tokIP, tok, errStopOps
 			}
+			op += copyLen
+			ip += copyLen
 			state = stateFirstLiteralRun
 
 		case stateFirstLiteralRun:
+			tokIP, tok = ip, decodeState{state, op, lastMOff, 0}
+			if cfg.trace != nil {
+				cfg.trace.ip, cfg.trace.st = tokIP, tok
+			}
 			if ip >= inLen {
-				return op, ErrInputOverrun
+				return op, ip, tokIP, tok, errMissingEOF
 			}
 			t := int(src[ip])
 			ip++
@@ -166,28 +530,46 @@ func Decompress(src, dst []byte) (int, error) {
 			// M1 match after first literal run
 			// Offset = (1 + M2_MAX_OFFSET) + (t << 6) + (next_byte >> 2)
 			if ip >= inLen {
-				return op, ErrInputOverrun
+				return op, ip, tokIP, tok, ErrInputOverrun
+			}
+			var mOff int
+			if cfg.lzo1x {
+				mOff = (1 + m2MaxOffsetLZO1X) + (t >> 2) + int(src[ip])<<2
+			} else {
+				mOff = (1 + m2MaxOffset) + (t << 6) + int(src[ip]>>2)
 			}
-			mOff := (1 + m2MaxOffset) + (t << 6) + int(src[ip]>>2)
 			ip++
 			lastMOff = mOff
 
+			if cfg.maxMatchLen > 0 && 3 > cfg.maxMatchLen {
+				return op, ip, tokIP, tok, ErrMatchTooLong
+			}
+			if cfg.overRatio(op+3, ip) {
+				return op, ip, tokIP, tok, ErrCorrupted
+			}
 			if mOff > op {
-				return op, ErrLookbehindOverrun
+				return op, ip, tokIP, tok, ErrLookbehindOverrun
 			}
-			if op+3 > outLen {
-				return op, ErrOutputOverrun
+			if op+3 <= outLen {
+				mPos := op - mOff
+				dst[op] = dst[mPos]
+				dst[op+1] = dst[mPos+1]
+				dst[op+2] = dst[mPos+2]
+			} else if !cfg.walk {
+				return op, ip, tokIP, tok, ErrOutputOverrun
+			} else if !cfg.report(OpInfo{Kind: OpM1, InputPos: tokIP, OutputPos: op, Length: 3, Offset: mOff, TrailingLiterals: trailingLiterals(src, ip, cfg.lzo1x)}) {
+				return op, ip, tokIP, tok, errStopOps
 			}
-			mPos := op - mOff
-			dst[op] = dst[mPos]
-			dst[op+1] = dst[mPos+1]
-			dst[op+2] = dst[mPos+2]
 			op += 3
 			state = stateMatchDone
 
 		case stateMatch:
+			tokIP, tok = ip, decodeState{state, op, lastMOff, 0}
+			if cfg.trace != nil {
+				cfg.trace.ip, cfg.trace.st = tokIP, tok
+			}
 			if ip >= inLen {
-				return op, ErrInputOverrun
+				return op, ip, tokIP, tok, errMissingEOF
 			}
 			t := int(src[ip])
 			ip++
@@ -196,15 +578,24 @@ func Decompress(src, dst []byte) (int, error) {
 				// M2 match
 				off := t & 0x1f
 				var mOff int
-				if off >= 0x1c {
-					// Reuse last match offset (LZO1Z feature)
+				if cfg.lzo1x {
+					// LZO1X: 3 offset bits in the opcode, no offset reuse
+					if ip >= inLen {
+						return op, ip, tokIP, tok, ErrInputOverrun
+					}
+					mOff = 1 + (t>>2)&7 + int(src[ip])<<3
+					ip++
+					lastMOff = mOff
+				} else if off >= 0x1c {
+					// Reuse last match offset (LZO1Z feature). A stream
+					// that asks for it before any match has none to reuse
 					if lastMOff == 0 {
-						return op, ErrLookbehindOverrun
+						return op, ip, tokIP, tok, ErrCorrupted
 					}
 					mOff = lastMOff
 				} else {
 					if ip >= inLen {
-						return op, ErrInputOverrun
+						return op, ip, tokIP, tok, ErrInputOverrun
 					}
 					mOff = 1 + (off << 6) + int(src[ip]>>2)
 					ip++
@@ -213,18 +604,27 @@ func Decompress(src, dst []byte) (int, error) {
 				// Length: (t >> 5) - 1, then copy length + 2 bytes
 				mLen := ((t >> 5) - 1) + 2
 
-				if mOff > op {
-					return op, ErrLookbehindOverrun
+				if cfg.maxMatchLen > 0 && mLen > cfg.maxMatchLen {
+					return op, ip, tokIP, tok, ErrMatchTooLong
 				}
-				if op+mLen > outLen {
-					return op, ErrOutputOverrun
+				if cfg.overRatio(op+mLen, ip) {
+					return op, ip, tokIP, tok, ErrCorrupted
 				}
-				mPos := op - mOff
-				for i := 0; i < mLen; i++ {
-					dst[op] = dst[mPos]
-					op++
-					mPos++
+				if mOff > op {
+					return op, ip, tokIP, tok, ErrLookbehindOverrun
+				}
+				if op+mLen <= outLen {
+					// At most 8 bytes, too few to be worth a call
+					mPos := op - mOff
+					for i := 0; i < mLen; i++ {
+						dst[op+i] = dst[mPos+i]
+					}
+				} else if !cfg.walk {
+					return op, ip, tokIP, tok, ErrOutputOverrun
+				} else if !cfg.report(OpInfo{Kind: OpM2, InputPos: tokIP, OutputPos: op, Length: mLen, Offset: mOff, Reused: off >= 0x1c && !cfg.lzo1x, TrailingLiterals: trailingLiterals(src, ip, cfg.lzo1x)}) {
+					return op, ip, tokIP, tok, errStopOps
 				}
+				op += mLen
 
 			} else if t >= 32 {
 				// M3 match
@@ -236,34 +636,42 @@ func Decompress(src, dst []byte) (int, error) {
 						ip++
 					}
 					if ip >= inLen {
-						return op, ErrInputOverrun
+						return op, ip, tokIP, tok, ErrInputOverrun
 					}
 					mLen += 31 + int(src[ip])
 					ip++
 				}
 
 				if ip+2 > inLen {
-					return op, ErrInputOverrun
+					return op, ip, tokIP, tok, ErrInputOverrun
 				}
-				// LZO1Z offset encoding: (ip[0] << 6) + (ip[1] >> 2)
-				mOff := 1 + int(src[ip])<<6 + int(src[ip+1]>>2)
+				// LZO1Z: (ip[0] << 6) + (ip[1] >> 2), LZO1X: (ip[0] >> 2) + (ip[1] << 6)
+				mOff := 1 + decodeOffset(src[ip], src[ip+1], cfg.lzo1x)
 				ip += 2
 				lastMOff = mOff
 
 				// Copy mLen + 2 bytes
 				mLen += 2
-				if mOff > op {
-					return op, ErrLookbehindOverrun
+				if cfg.maxMatchLen > 0 && mLen > cfg.maxMatchLen {
+					return op, ip, tokIP, tok, ErrMatchTooLong
 				}
-				if op+mLen > outLen {
-					return op, ErrOutputOverrun
+				if cfg.overRatio(op+mLen, ip) {
+					return op, ip, tokIP, tok, ErrCorrupted
 				}
-				mPos := op - mOff
-				for i := 0; i < mLen; i++ {
-					dst[op] = dst[mPos]
-					op++
-					mPos++
+				if mOff > op {
+					return op, ip, tokIP, tok, ErrLookbehindOverrun
+				}
+				if op+mLen <= outLen {
+					copyMatch(dst, op, mOff, mLen)
+				} else if !cfg.walk {
+					if cfg.split && op < outLen {
+						return splitMatch(dst, op, ip, mOff, mLen)
+					}
+					return op, ip, tokIP, tok, ErrOutputOverrun
+				} else if !cfg.report(OpInfo{Kind: OpM3, InputPos: tokIP, OutputPos: op, Length: mLen, Offset: mOff, TrailingLiterals: trailingLiterals(src, ip, cfg.lzo1x)}) {
+					return op, ip, tokIP, tok, errStopOps
 				}
+				op += mLen
 
 			} else if t >= 16 {
 				// M4 match
@@ -276,21 +684,23 @@ func Decompress(src, dst []byte) (int, error) {
 						ip++
 					}
 					if ip >= inLen {
-						return op, ErrInputOverrun
+						return op, ip, tokIP, tok, ErrInputOverrun
 					}
 					mLen += 7 + int(src[ip])
 					ip++
 				}
 
 				if ip+2 > inLen {
-					return op, ErrInputOverrun
+					return op, ip, tokIP, tok, ErrInputOverrun
 				}
-				// LZO1Z offset encoding
-				mOff += int(src[ip])<<6 + int(src[ip+1]>>2)
+				mOff += decodeOffset(src[ip], src[ip+1], cfg.lzo1x)
 				ip += 2
 
 				if mOff == 0 {
 					// EOF marker found
+					if cfg.ops != nil {
+						cfg.ops(OpInfo{Kind: OpEOF, InputPos: tokIP, OutputPos: op})
+					}
 					state = stateEOF
 					continue
 				}
@@ -300,83 +710,210 @@ func Decompress(src, dst []byte) (int, error) {
 
 				// Copy mLen + 2 bytes
 				mLen += 2
-				if mOff > op {
-					return op, ErrLookbehindOverrun
+				if cfg.maxMatchLen > 0 && mLen > cfg.maxMatchLen {
+					return op, ip, tokIP, tok, ErrMatchTooLong
 				}
-				if op+mLen > outLen {
-					return op, ErrOutputOverrun
+				if cfg.overRatio(op+mLen, ip) {
+					return op, ip, tokIP, tok, ErrCorrupted
 				}
-				mPos := op - mOff
-				for i := 0; i < mLen; i++ {
-					dst[op] = dst[mPos]
-					op++
-					mPos++
+				if mOff > op {
+					return op, ip, tokIP, tok, ErrLookbehindOverrun
 				}
+				if op+mLen <= outLen {
+					copyMatch(dst, op, mOff, mLen)
+				} else if !cfg.walk {
+					if cfg.split && op < outLen {
+						return splitMatch(dst, op, ip, mOff, mLen)
+					}
+					return op, ip, tokIP, tok, ErrOutputOverrun
+				} else if !cfg.report(OpInfo{Kind: OpM4, InputPos: tokIP, OutputPos: op, Length: mLen, Offset: mOff, TrailingLiterals: trailingLiterals(src, ip, cfg.lzo1x)}) {
+					return op, ip, tokIP, tok, errStopOps
+				}
+				op += mLen
 
 			} else {
 				// M1 match (t < 16) - copies 2 bytes
 				if ip >= inLen {
-					return op, ErrInputOverrun
+					return op, ip, tokIP, tok, ErrInputOverrun
+				}
+				var mOff int
+				if cfg.lzo1x {
+					mOff = 1 + (t >> 2) + int(src[ip])<<2
+				} else {
+					mOff = 1 + (t << 6) + int(src[ip]>>2)
 				}
-				mOff := 1 + (t << 6) + int(src[ip]>>2)
 				ip++
 				lastMOff = mOff
 
+				if cfg.maxMatchLen > 0 && 2 > cfg.maxMatchLen {
+					return op, ip, tokIP, tok, ErrMatchTooLong
+				}
+				if cfg.overRatio(op+2, ip) {
+					return op, ip, tokIP, tok, ErrCorrupted
+				}
 				if mOff > op {
-					return op, ErrLookbehindOverrun
+					return op, ip, tokIP, tok, ErrLookbehindOverrun
 				}
-				if op+2 > outLen {
-					return op, ErrOutputOverrun
+				if op+2 <= outLen {
+					mPos := op - mOff
+					dst[op] = dst[mPos]
+					dst[op+1] = dst[mPos+1]
+				} else if !cfg.walk {
+					return op, ip, tokIP, tok, ErrOutputOverrun
+				} else if !cfg.report(OpInfo{Kind: OpM1, InputPos: tokIP, OutputPos: op, Length: 2, Offset: mOff, TrailingLiterals: trailingLiterals(src, ip, cfg.lzo1x)}) {
+					return op, ip, tokIP, tok, errStopOps
 				}
-				mPos := op - mOff
-				dst[op] = dst[mPos]
-				dst[op+1] = dst[mPos+1]
 				op += 2
 			}
-			state = stateMatchDone
+			// Skip a pass through the loop for the trailing literals,
+			// which every match is followed by
+			fallthrough
 
 		case stateMatchDone:
-			// Check for trailing literals (encoded in low 2 bits of last offset byte)
-			if ip == 0 || ip > inLen {
+			// Check for trailing literals, encoded in the low 2 bits of the
+			// last offset byte (LZO1Z) or of the byte before it (LZO1X).
+			// This state is only entered straight from the match, and no
+			// token boundary is recorded in between, so those bytes always
+			// belong to the match just decoded, also when resuming
+			stateByte := ip - 1
+			if cfg.lzo1x {
+				stateByte = ip - 2
+			}
+			if stateByte < 0 || ip > inLen {
 				state = stateLiteralRun
 				continue
 			}
-			t := int(src[ip-1]) & 3
+			t := int(src[stateByte]) & 3
 			if t == 0 {
+				// Go straight to the next match when one follows
 				state = stateLiteralRun
+				if ip < inLen && src[ip] >= 16 {
+					state = stateMatch
+				}
 				continue
 			}
-			// Copy t trailing literal bytes
-			if op+t > outLen {
-				return op, ErrOutputOverrun
-			}
+			// Copy t trailing literal bytes, reporting them cut short
+			// first like the other literal runs
 			if ip+t > inLen {
-				return op, ErrInputOverrun
+				return op, ip, tokIP, tok, ErrInputOverrun
 			}
-			for i := 0; i < t; i++ {
-				dst[op] = src[ip]
-				op++
-				ip++
+			if op+t <= outLen {
+				for i := 0; i < t; i++ {
+					dst[op+i] = src[ip+i]
+				}
+			} else if !cfg.walk {
+				return op, ip, tokIP, tok, ErrOutputOverrun
+			} else if !cfg.report(OpInfo{Kind: OpLiteral, InputPos: ip, OutputPos: op, Length: t}) {
+				return op, ip, tokIP, tok, errStopOps
 			}
-			state = stateMatchNext
-
-		case stateMatchNext:
-			// After copying trailing literals, the next opcode is parsed via
-			// the regular match path.
+			op += t
+			ip += t
+			// The next opcode is parsed via the regular match path
 			state = stateMatch
+
+		case stateSplitLiterals:
+			// Only entered from st, with src starting at the literals
+			n := st.rest
+			if n > inLen {
+				return op, ip, tokIP, tok, ErrInputOverrun
+			}
+			if op+n > outLen {
+				if op < outLen {
+					return splitLiterals(src, dst, op, 0, n, lastMOff)
+				}
+				return op, ip, tokIP, tok, ErrOutputOverrun
+			}
+			copy(dst[op:op+n], src[:n])
+			op += n
+			ip = n
+			state = stateFirstLiteralRun
+
+		case stateSplitMatch:
+			// Only entered from st, with src starting at the match's two
+			// offset bytes, where stateMatchDone finds the trailing literals
+			n := st.rest
+			if inLen < 2 {
+				return op, ip, tokIP, tok, ErrInputOverrun
+			}
+			if lastMOff > op {
+				return op, ip, tokIP, tok, ErrLookbehindOverrun
+			}
+			if op+n > outLen {
+				if op < outLen {
+					return splitMatch(dst, op, 2, lastMOff, n)
+				}
+				return op, ip, tokIP, tok, ErrOutputOverrun
+			}
+			copyMatch(dst, op, lastMOff, n)
+			op += n
+			ip = 2
+			state = stateMatchDone
 		}
 	}
 
-	// Check for unconsumed input after EOF marker (matches C's LZO_E_INPUT_NOT_CONSUMED)
-	if ip < inLen {
-		return op, ErrInputNotConsumed
+	return op, ip, tokIP, tok, nil
+}
+
+// splitLiterals copies the n literals at src[ip:] that fit in dst[op:],
+// which must not hold them all, for a decoder with cfg.split set. It
+// returns decodeTokens' results for an ErrOutputOverrun that resumes at
+// the rest of the run, so a caller that flushes dst between calls can
+// decode a run longer than dst.
+func splitLiterals(src, dst []byte, op, ip, n, lastMOff int) (int, int, int, decodeState, error) {
+	k := copy(dst[op:], src[ip:ip+n])
+	return op + k, ip + k, ip + k, decodeState{stateSplitLiterals, op + k, lastMOff, n - k}, ErrOutputOverrun
+}
+
+// splitMatch is splitLiterals for an M3 or M4 match of n bytes at offset
+// mOff, with ip just past its two offset bytes. Decoding resumes at those
+// bytes, which also hold the count of literals that follow the match.
+func splitMatch(dst []byte, op, ip, mOff, n int) (int, int, int, decodeState, error) {
+	k := len(dst) - op
+	copyMatch(dst, op, mOff, k)
+	return op + k, ip, ip - 2, decodeState{stateSplitMatch, op + k, mOff, n - k}, ErrOutputOverrun
+}
+
+// copyMatch copies a match of length bytes from offset bytes back in dst
+// to dst[op:], which the caller has bounds-checked. A match overlapping
+// its own output must see each byte it writes, so copy is only given
+// disjoint ranges: the whole match when offset >= length, otherwise the
+// bytes repeated so far, which double on every pass. Those start as a
+// whole number of periods of at least 8 bytes, copied one byte at a time.
+func copyMatch(dst []byte, op, offset, length int) {
+	mPos := op - offset
+	end := op + length
+	if offset >= length {
+		copy(dst[op:end], dst[mPos:end-offset])
+		return
+	}
+	// dst[mPos:op] repeats with period offset; copying it whole to op is
+	// only correct while op-mPos is a multiple of offset
+	period := offset
+	if period < 8 {
+		period *= (8 + offset - 1) / offset
+	}
+	for op < end && op-mPos < period {
+		dst[op] = dst[op-offset]
+		op++
 	}
+	for op < end {
+		op += copy(dst[op:end], dst[mPos:op])
+	}
+}
 
-	return op, nil
+// decodeOffset decodes a two-byte M3/M4 offset field.
+func decodeOffset(b0, b1 byte, lzo1x bool) int {
+	if lzo1x {
+		return int(b0>>2) + int(b1)<<6
+	}
+	return int(b0)<<6 + int(b1>>2)
 }
 
-// DecompressSafe is an alias for Decompress that emphasizes bounds checking.
-// All bounds are checked in Decompress, so this is functionally identical.
+// DecompressSafe is identical to Decompress.
+//
+// Deprecated: Decompress already checks every input, output and
+// lookbehind bound and never panics on malformed input; there is no
+// separate unchecked variant. Use Decompress.
 func DecompressSafe(src, dst []byte) (int, error) {
 	return Decompress(src, dst)
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"runtime"
 	"testing"
 )
 
@@ -54,7 +56,7 @@ func TestDecompressOutputTooSmall(t *testing.T) {
 		if tc.inputLen > 10 {
 			dst := make([]byte, 5) // Way too small
 			_, err := Decompress(tc.compressed, dst)
-			if err != ErrOutputOverrun {
+			if !errors.Is(err, ErrOutputOverrun) {
 				t.Errorf("Expected ErrOutputOverrun for %s, got: %v", tc.name, err)
 			}
 			break
@@ -112,11 +114,35 @@ func BenchmarkDecompress(b *testing.B) {
 	}
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+func BenchmarkDecompressText(b *testing.B) {
+	// Short and mid-length matches at offsets well above 8
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100)
+	benchmarkDecompressInput(b, input)
+}
+
+func BenchmarkDecompressZeros(b *testing.B) {
+	// Long offset-1 matches, as in mostly-zero sparse binary data
+	benchmarkDecompressInput(b, make([]byte, 1<<20))
+}
+
+func BenchmarkDecompressLiterals(b *testing.B) {
+	// Long literal runs with no matches
+	input := make([]byte, 64<<10)
+	for i := range input {
+		input[i] = byte(i*i*131 + i>>7)
+	}
+	benchmarkDecompressInput(b, input)
+}
+
+func benchmarkDecompressInput(b *testing.B, input []byte) {
+	compressed := MustCompress(input, nil)
+	dst := make([]byte, len(input))
+	b.ResetTimer()
+	b.SetBytes(int64(len(input)))
+
+	for i := 0; i < b.N; i++ {
+		_, _ = Decompress(compressed, dst)
 	}
-	return b
 }
 
 func TestDecompressErrors(t *testing.T) {
@@ -157,6 +183,71 @@ func TestDecompressErrors(t *testing.T) {
 	}
 }
 
+func TestDecompressZeroOffsetFields(t *testing.T) {
+	// Offsets are biased by one, so an all-zero offset field means offset 1
+	// (copy the previous byte) and never a copy from the output position
+	// itself. The only zero-field match is the M4 EOF marker.
+	tests := []struct {
+		name  string
+		match []byte
+		want  string
+	}{
+		{"m1", []byte{0x00, 0x00}, "ABBB"},
+		{"m2", []byte{0x40, 0x00}, "ABBBB"},
+		{"m3", []byte{0x21, 0x00, 0x00}, "ABBBB"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src := append([]byte{0x13, 'A', 'B'}, tc.match...) // 2 literals, then match state
+			src = append(src, 0x11, 0x00, 0x00)
+
+			dst := make([]byte, 16)
+			n, err := Decompress(src, dst)
+			if err != nil {
+				t.Fatalf("Decompress failed: %v", err)
+			}
+			if string(dst[:n]) != tc.want {
+				t.Errorf("got %q, want %q", dst[:n], tc.want)
+			}
+		})
+	}
+}
+
+func TestDecompressMissingEOF(t *testing.T) {
+	// Streams cut right before the EOF marker end on an opcode boundary in
+	// every decoder state; all must be reported, not accepted
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"after_first_literals", []byte{0x15, 'A', 'B', 'C', 'D'}},
+		{"after_short_first_literals", []byte{0x13, 'A', 'B'}},
+		{"after_match", []byte{0x15, 'A', 'B', 'C', 'D', 0x40, 0x00}},
+		{"after_trailing_literals", []byte{0x15, 'A', 'B', 'C', 'D', 0x40, 0x01, 'E'}},
+		{"after_literal_run", []byte{0x15, 'A', 'B', 'C', 'D', 0x40, 0x00, 0x01, 'E', 'F', 'G', 'H'}},
+	}
+
+	check := func(name string, src []byte) {
+		t.Run(name, func(t *testing.T) {
+			dst := make([]byte, 1<<20)
+			if _, err := Decompress(src, dst); !errors.Is(err, ErrInputOverrun) {
+				t.Errorf("expected ErrInputOverrun, got %v", err)
+			}
+		})
+	}
+	for _, tc := range tests {
+		check(tc.name, tc.src)
+	}
+	for _, tc := range interopTestCases {
+		check("interop_"+tc.name, tc.compressed[:len(tc.compressed)-3])
+	}
+
+	if n, err := Decompress([]byte{}, nil); n != 0 || err != nil {
+		t.Errorf("empty input: got (%d, %v), want (0, nil)", n, err)
+	}
+}
+
 func TestDecompressSafe(t *testing.T) {
 	// DecompressSafe should behave identically to Decompress
 	compressed := []byte{0x14, 0x41, 0x42, 0x43, 0x11, 0x00, 0x00}
@@ -195,3 +286,541 @@ func TestDecompressPostLiteralMatch(t *testing.T) {
 		t.Fatalf("decompressed payload hash mismatch: got=%s want=%s", got, want)
 	}
 }
+
+func BenchmarkDecompressPostLiteralMatch(b *testing.B) {
+	// A feed dominated by short matches separated by a few literals
+	src, err := hex.DecodeString(postLiteralMatchCompressedHex)
+	if err != nil {
+		b.Fatalf("decode compressed vector: %v", err)
+	}
+	dst := make([]byte, 574)
+	b.SetBytes(int64(len(dst)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = Decompress(src, dst)
+	}
+}
+
+func BenchmarkDecompressKnownSize(b *testing.B) {
+	src, err := hex.DecodeString(postLiteralMatchCompressedHex)
+	if err != nil {
+		b.Fatalf("decode compressed vector: %v", err)
+	}
+	dst := make([]byte, 574)
+	b.SetBytes(int64(len(dst)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = DecompressKnownSize(src, dst)
+	}
+}
+
+func TestDecompressKnownSize(t *testing.T) {
+	input := bytes.Repeat([]byte("known size "), 50)
+	src := MustCompress(input, nil)
+
+	dst := make([]byte, len(input))
+	if err := DecompressKnownSize(src, dst); err != nil || !bytes.Equal(dst, input) {
+		t.Fatalf("exact size: got %v", err)
+	}
+	if err := DecompressKnownSize(src, make([]byte, len(input)+1)); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("size too large: expected ErrCorrupted, got %v", err)
+	}
+	if err := DecompressKnownSize(src, make([]byte, len(input)-1)); !errors.Is(err, ErrOutputOverrun) {
+		t.Errorf("size too small: expected ErrOutputOverrun, got %v", err)
+	}
+	if err := DecompressKnownSize(append(src, 0), dst); !errors.Is(err, ErrInputNotConsumed) {
+		t.Errorf("trailing input: expected ErrInputNotConsumed, got %v", err)
+	}
+}
+
+func TestDecompressAt(t *testing.T) {
+	input := []byte("Hello, World! Hello, World! Hello, World!")
+	comp := make([]byte, MaxCompressedSize(len(input)))
+	n, err := Compress(input, comp)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	header := []byte("HDR\x00\x01")
+	trailer := []byte("TRAILER")
+	container := append(append(append([]byte{}, header...), comp[:n]...), trailer...)
+
+	dst := make([]byte, len(input)+100)
+	nOut, end, err := DecompressAt(container, len(header), dst)
+	if err != nil {
+		t.Fatalf("DecompressAt failed: %v", err)
+	}
+	if !bytes.Equal(dst[:nOut], input) {
+		t.Errorf("output mismatch: got %q", dst[:nOut])
+	}
+	if end != len(header)+n {
+		t.Errorf("endOffset = %d, want %d", end, len(header)+n)
+	}
+	if !bytes.Equal(container[end:], trailer) {
+		t.Errorf("remaining data = %q, want %q", container[end:], trailer)
+	}
+}
+
+func TestDecompressAtErrors(t *testing.T) {
+	dst := make([]byte, 100)
+	src := []byte{0xff, 0x15, 0x41, 0x42}
+
+	if _, _, err := DecompressAt(src, -1, dst); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("negative offset: expected ErrInputOverrun, got %v", err)
+	}
+	if _, _, err := DecompressAt(src, len(src), dst); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("offset at end: expected ErrInputOverrun, got %v", err)
+	}
+	// Truncated stream at offset 1
+	if _, _, err := DecompressAt(src, 1, dst); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("truncated stream: expected ErrInputOverrun, got %v", err)
+	}
+}
+
+func TestDecompressN(t *testing.T) {
+	trailer := []byte("\x11\x00\x00TRAILER")
+	for _, input := range [][]byte{
+		[]byte("Hello, World! Hello, World! Hello, World!"),
+		[]byte("ab"),
+		bytes.Repeat([]byte{0}, 1000),
+	} {
+		comp := MustCompress(input, nil)
+		src := append(append([]byte{}, comp...), trailer...)
+
+		dst := make([]byte, len(input))
+		nOut, nIn, err := DecompressN(src, dst)
+		if err != nil {
+			t.Fatalf("%d bytes: DecompressN failed: %v", len(input), err)
+		}
+		if !bytes.Equal(dst[:nOut], input) {
+			t.Errorf("%d bytes: output mismatch", len(input))
+		}
+		// The stream ends with its EOF marker
+		if nIn != len(comp) || !bytes.Equal(src[nIn-3:nIn], []byte{0x11, 0x00, 0x00}) {
+			t.Errorf("%d bytes: nIn = %d, want %d, just past the EOF marker", len(input), nIn, len(comp))
+		}
+		if !bytes.Equal(src[nIn:], trailer) {
+			t.Errorf("%d bytes: trailer = %q, want %q", len(input), src[nIn:], trailer)
+		}
+	}
+
+	if nOut, nIn, err := DecompressN(nil, nil); nOut != 0 || nIn != 0 || err != nil {
+		t.Errorf("empty src: DecompressN = (%d, %d, %v), want (0, 0, nil)", nOut, nIn, err)
+	}
+	if _, _, err := DecompressN([]byte{0x15, 0x41, 0x42}, make([]byte, 10)); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("truncated stream: expected ErrInputOverrun, got %v", err)
+	}
+}
+
+func TestDecompressThen(t *testing.T) {
+	const key = 0x5a
+	xor := func(b []byte) {
+		for i := range b {
+			b[i] ^= key
+		}
+	}
+
+	input := bytes.Repeat([]byte("masked payload, "), 40)
+	masked := append([]byte{}, input...)
+	xor(masked)
+
+	comp := make([]byte, MaxCompressedSize(len(masked)))
+	n, err := Compress(masked, comp)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	calls := 0
+	dst := make([]byte, len(input)+100)
+	m, err := DecompressThen(comp[:n], dst, func(out []byte) {
+		calls++
+		if len(out) != len(input) {
+			t.Errorf("transform saw %d bytes, want %d", len(out), len(input))
+		}
+		xor(out)
+	})
+	if err != nil {
+		t.Fatalf("DecompressThen failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("transform called %d times, want 1", calls)
+	}
+	if !bytes.Equal(dst[:m], input) {
+		t.Errorf("unmasked output mismatch")
+	}
+}
+
+func TestDecompressThenError(t *testing.T) {
+	called := false
+	dst := make([]byte, 100)
+	_, err := DecompressThen([]byte{0x15, 0x41, 0x42}, dst, func([]byte) { called = true })
+	if !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("expected ErrInputOverrun, got %v", err)
+	}
+	if called {
+		t.Errorf("transform called on error")
+	}
+}
+
+func TestDecompressAppend(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"zeros_1mb", make([]byte, 1<<20)},
+		{"repeated_A", bytes.Repeat([]byte("A"), 100000)},
+		{"text", bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 500)},
+		{"short", []byte("abc")},
+		{"empty", nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			comp := make([]byte, MaxCompressedSize(len(tc.input)))
+			n, err := Compress(tc.input, comp)
+			if err != nil {
+				t.Fatalf("Compress failed: %v", err)
+			}
+
+			prefix := []byte("prefix:")
+			out, err := DecompressAppend(append([]byte{}, prefix...), comp[:n])
+			if err != nil {
+				t.Fatalf("DecompressAppend failed: %v", err)
+			}
+			if !bytes.Equal(out[:len(prefix)], prefix) {
+				t.Errorf("prefix clobbered: %q", out[:len(prefix)])
+			}
+			if !bytes.Equal(out[len(prefix):], tc.input) {
+				t.Errorf("output mismatch: got %d bytes, want %d", len(out)-len(prefix), len(tc.input))
+			}
+			if len(tc.input) > 0 {
+				t.Logf("%s: %d -> %d bytes (%.0fx)", tc.name, n, len(tc.input), float64(len(tc.input))/float64(n))
+			}
+		})
+	}
+}
+
+func TestDecompressAppendMax(t *testing.T) {
+	// One literal, then a single extended-length match repeating it 16 MiB
+	const matchLen = 16 << 20
+	stream := make([]byte, MaxCompressedSize(matchLen))
+	n, _ := emitPendingLiterals([]byte{'a'}, stream, nil)
+	m, err := emitMatch(stream[n:], 1, matchLen)
+	if err != nil {
+		t.Fatalf("emitMatch failed: %v", err)
+	}
+	stream = append(stream[:n+m], 0x11, 0x00, 0x00)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	out, err := DecompressAppendMax([]byte("keep"), stream, 1<<20)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Errorf("expected ErrOutputTooLarge, got %v", err)
+	}
+	if string(out) != "keep" {
+		t.Errorf("dst extended on error: %q", out[:min(len(out), 16)])
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 2<<20 {
+		t.Errorf("allocated %d bytes under a 1 MiB limit", alloc)
+	}
+
+	// A limit the output just fits in is no limit at all
+	out, err = DecompressAppendMax(nil, stream, 1+matchLen)
+	if err != nil || len(out) != 1+matchLen {
+		t.Errorf("limit equal to the output: got %d bytes, %v", len(out), err)
+	}
+}
+
+func TestDecompressAppendUsesSpareCapacity(t *testing.T) {
+	compressed := []byte{0x14, 0x41, 0x42, 0x43, 0x11, 0x00, 0x00}
+	dst := make([]byte, 2, 1024)
+
+	out, err := DecompressAppend(dst, compressed)
+	if err != nil {
+		t.Fatalf("DecompressAppend failed: %v", err)
+	}
+	if &out[0] != &dst[0] {
+		t.Errorf("DecompressAppend reallocated despite spare capacity")
+	}
+	if string(out[2:]) != "ABC" {
+		t.Errorf("got %q, want %q", out[2:], "ABC")
+	}
+}
+
+func TestDecompressAppendError(t *testing.T) {
+	dst := []byte("keep")
+	out, err := DecompressAppend(dst, []byte{0x15, 0x41, 0x42})
+	if !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("expected ErrInputOverrun, got %v", err)
+	}
+	if string(out) != "keep" {
+		t.Errorf("dst modified on error: %q", out)
+	}
+}
+
+func TestDecodeErrorPosition(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     []byte
+		dstLen  int
+		wantErr error
+		inPos   int
+		outPos  int
+	}{
+		// 4 literals, then an M3 match reaching far before the output
+		{"lookbehind", []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}, 64, ErrLookbehindOverrun, 5, 4},
+		// 4 literals, then an M2 reusing an offset no match has set
+		{"reuse_without_offset", []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x7c, 0x11, 0x00, 0x00}, 64, ErrCorrupted, 5, 4},
+		{"cut_in_literals", []byte{0x15, 0x41, 0x42}, 64, ErrInputOverrun, 0, 0},
+		{"missing_eof", []byte{0x15, 0x41, 0x42, 0x43, 0x44}, 64, ErrInputOverrun, 5, 4},
+		{"output_overrun", []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x40, 0x00, 0x11, 0x00, 0x00}, 5, ErrOutputOverrun, 5, 4},
+		{"trailing_garbage", []byte{0x12, 0x41, 0x11, 0x00, 0x00, 0x00}, 64, ErrInputNotConsumed, 5, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decompress(tc.src, make([]byte, tc.dstLen))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("error %v is not a *DecodeError", err)
+			}
+			if de.InputPos != tc.inPos || de.OutputPos != tc.outPos {
+				t.Errorf("got input %d output %d, want input %d output %d",
+					de.InputPos, de.OutputPos, tc.inPos, tc.outPos)
+			}
+		})
+	}
+}
+
+func TestDecodeErrorPositionAt(t *testing.T) {
+	src := append([]byte("header"), 0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00)
+	_, _, err := DecompressAt(src, 6, make([]byte, 64))
+
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrLookbehindOverrun {
+		t.Fatalf("expected a DecodeError for ErrLookbehindOverrun, got %v", err)
+	}
+	if de.InputPos != 11 || de.OutputPos != 4 {
+		t.Errorf("got input %d output %d, want input 11 output 4", de.InputPos, de.OutputPos)
+	}
+}
+
+func TestDecompressAll(t *testing.T) {
+	var all, want []byte
+	for _, tc := range append(testCases, interopTestCases...) {
+		if len(tc.compressed) == 0 {
+			continue
+		}
+		double := append(append([]byte{}, tc.compressed...), tc.compressed...)
+		dst := make([]byte, 2*tc.inputLen)
+		n, err := DecompressAll(double, dst)
+		if err != nil || n != 2*tc.inputLen {
+			t.Fatalf("%s: DecompressAll = (%d, %v), want (%d, nil)", tc.name, n, err, 2*tc.inputLen)
+		}
+		if !bytes.Equal(dst[:tc.inputLen], tc.input) || !bytes.Equal(dst[tc.inputLen:], tc.input) {
+			t.Errorf("%s: output mismatch", tc.name)
+		}
+		all = append(all, tc.compressed...)
+		want = append(want, tc.input...)
+	}
+
+	dst := make([]byte, len(want))
+	if n, err := DecompressAll(all, dst); err != nil || !bytes.Equal(dst[:n], want) {
+		t.Errorf("all vectors: DecompressAll failed: %v", err)
+	}
+	if n, err := DecompressAll(nil, nil); n != 0 || err != nil {
+		t.Errorf("empty: got (%d, %v), want (0, nil)", n, err)
+	}
+}
+
+func TestDecompressAllErrors(t *testing.T) {
+	first := []byte{0x14, 0x41, 0x42, 0x43, 0x11, 0x00, 0x00}
+
+	// A match in the second stream cannot reach into the first one's output
+	second := []byte{0x12, 0x44, 0x21, 0x00, 0x08, 0x11, 0x00, 0x00}
+	n, err := DecompressAll(append(first, second...), make([]byte, 64))
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrLookbehindOverrun || de.InputPos != 9 || de.OutputPos != 4 {
+		t.Errorf("lookbehind: expected ErrLookbehindOverrun at input 9 output 4, got %v", err)
+	}
+	if n != 4 {
+		t.Errorf("lookbehind: got %d bytes, want 4", n)
+	}
+
+	// A trailing partial stream is truncated input
+	if _, err := DecompressAll(append(first, 0x12), make([]byte, 64)); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("truncated: expected ErrInputOverrun, got %v", err)
+	}
+}
+
+func TestDecompressZeroAllocs(t *testing.T) {
+	for _, tc := range interopTestCases {
+		dst := make([]byte, tc.inputLen)
+		allocs := testing.AllocsPerRun(20, func() {
+			_, _ = Decompress(tc.compressed, dst)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: Decompress allocated %.0f times per call, want 0", tc.name, allocs)
+		}
+	}
+}
+
+func TestDecodeOffsetMaxima(t *testing.T) {
+	// The offset fields are too narrow to encode anything beyond each
+	// match type's legal maximum, so no range check is needed: all-ones
+	// fields decode to exactly the maximum
+	hist := make([]byte, 0xc000)
+	for i := range hist {
+		hist[i] = byte(i*131 + i>>8)
+	}
+
+	tests := []struct {
+		name   string
+		match  []byte
+		offset int
+	}{
+		{"m2", []byte{0x5b, 0xfc}, 0x700},        // M2 len 3, offset field 27 (28+ reuses the offset)
+		{"m3", []byte{0x21, 0xff, 0xfc}, 0x4000}, // M3 len 3
+		{"m4", []byte{0x19, 0xff, 0xfc}, 0xbfff}, // M4 len 3, high offset bit set
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src := make([]byte, MaxCompressedSize(len(hist)))
+			n, err := emitLiterals(hist, src, true)
+			if err != nil {
+				t.Fatalf("emitLiterals failed: %v", err)
+			}
+			src = append(append(src[:n], tc.match...), 0x11, 0x00, 0x00)
+
+			dst := make([]byte, len(hist)+3)
+			m, err := Decompress(src, dst)
+			if err != nil || m != len(dst) {
+				t.Fatalf("Decompress = (%d, %v), want (%d, nil)", m, err, len(dst))
+			}
+			want := hist[len(hist)-tc.offset : len(hist)-tc.offset+3]
+			if !bytes.Equal(dst[len(hist):], want) {
+				t.Errorf("copied %x, want %x from offset %#x", dst[len(hist):], want, tc.offset)
+			}
+		})
+	}
+}
+
+func TestDecompressEOFOnly(t *testing.T) {
+	eof := []byte{0x11, 0x00, 0x00}
+	dst := make([]byte, 8)
+
+	if n, err := Decompress(eof, dst); n != 0 || err != nil {
+		t.Errorf("Decompress = (%d, %v), want (0, nil)", n, err)
+	}
+	if n, err := DecompressedSize(eof); n != 0 || err != nil {
+		t.Errorf("DecompressedSize = (%d, %v), want (0, nil)", n, err)
+	}
+	if n, err := NewDecompressor().Decompress(eof, dst); n != 0 || err != nil {
+		t.Errorf("Decompressor = (%d, %v), want (0, nil)", n, err)
+	}
+	if n, end, err := DecompressAt(append([]byte{0xaa}, eof...), 1, dst); n != 0 || end != 4 || err != nil {
+		t.Errorf("DecompressAt = (%d, %d, %v), want (0, 4, nil)", n, end, err)
+	}
+
+	// Data after the marker is still rejected, where it starts
+	_, err := Decompress(append(eof, 0x00), dst)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrInputNotConsumed || de.InputPos != 3 {
+		t.Errorf("trailing byte: got %v, want ErrInputNotConsumed at input 3", err)
+	}
+	if _, err := DecompressedSize(append(eof, 0x00)); !errors.Is(err, ErrInputNotConsumed) {
+		t.Errorf("DecompressedSize with trailing byte: got %v", err)
+	}
+
+	// A leading M4 with a nonzero offset is a real match, still decoded
+	if _, err := Decompress([]byte{0x11, 0x00, 0x04, 0x11, 0x00, 0x00}, dst); !errors.Is(err, ErrLookbehindOverrun) {
+		t.Errorf("leading M4 match: expected ErrLookbehindOverrun, got %v", err)
+	}
+}
+
+func BenchmarkDecompressEmptyFrames(b *testing.B) {
+	eof := []byte{0x11, 0x00, 0x00}
+	var dst [16]byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = Decompress(eof, dst[:])
+	}
+}
+
+func TestDecompressOverlappingMatch(t *testing.T) {
+	// Matches shorter in offset than in length must see the bytes they
+	// have just written; a block copy from the source position would
+	// repeat stale output instead
+	tests := []struct {
+		name   string
+		hist   int // literal bytes before the match
+		offset int
+		length int
+	}{
+		{"m2 offset 1", 1, 1, 4},
+		{"m3 offset 1", 1, 1, 264},
+		{"m3 offset 1 extended twice", 1, 1, 600},
+		{"m3 offset 3", 3, 3, 1000},
+		{"m3 max offset", 0x4000, 0x4000, 0x4100},
+		{"m4 offset 0x4001", 0x4001, 0x4001, 0x8000},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hist := make([]byte, tc.hist)
+			for i := range hist {
+				hist[i] = byte(i*7 + 1)
+			}
+			src := make([]byte, MaxCompressedSize(tc.hist)+tc.length/255+16)
+			n, err := emitLiterals(hist, src, true)
+			if err != nil {
+				t.Fatalf("emitLiterals failed: %v", err)
+			}
+			m, err := emitMatch(src[n:], tc.offset, tc.length)
+			if err != nil {
+				t.Fatalf("emitMatch failed: %v", err)
+			}
+			src = append(src[:n+m], 0x11, 0x00, 0x00)
+
+			want := append([]byte(nil), hist...)
+			for i := 0; i < tc.length; i++ {
+				want = append(want, want[len(want)-tc.offset])
+			}
+			dst := make([]byte, len(want))
+			got, err := Decompress(src, dst)
+			if err != nil || got != len(want) {
+				t.Fatalf("Decompress = (%d, %v), want (%d, nil)", got, err, len(want))
+			}
+			if !bytes.Equal(dst, want) {
+				t.Errorf("overlapping match decoded incorrectly")
+			}
+		})
+	}
+}
+
+func TestDecodePanicGuard(t *testing.T) {
+	// Five first literals, then a match reusing the last offset. A state
+	// no exported entry point can produce makes that offset reach past
+	// dst, standing in for a decoder bug at the second token.
+	src := []byte{0x16, 0x41, 0x42, 0x43, 0x44, 0x45, 0x5c, 0x11, 0x00, 0x00}
+	st := decodeState{lastMOff: -100}
+
+	op, ip, tokIP, tok, err := decodeFrom(src, make([]byte, 64), decodeConfig{guard: true}, st)
+	if err != ErrCorrupted {
+		t.Fatalf("decodeFrom error = %v, want ErrCorrupted", err)
+	}
+	if tokIP != 6 || tok.op != 5 || op != 5 || ip != 6 {
+		t.Errorf("decodeFrom = (op %d, ip %d, token %d at output %d), want (5, 6, 6 at 5)", op, ip, tokIP, tok.op)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("unguarded decodeFrom did not panic")
+		}
+	}()
+	decodeFrom(src, make([]byte, 64), decodeConfig{}, st)
+}
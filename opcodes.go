@@ -0,0 +1,89 @@
+package lzo1z
+
+import "errors"
+
+// OpKind identifies the kind of an opcode reported by DecodeOpcodes.
+type OpKind int
+
+const (
+	OpLiteral OpKind = iota // literal bytes copied from the input
+	OpM1                    // 2- or 3-byte match with a short offset
+	OpM2                    // 3- to 8-byte match, offset up to 0x700
+	OpM3                    // match with offset up to 0x4000
+	OpM4                    // match with offset from 0x4001 to 0xbfff
+	OpEOF                   // end of stream marker
+)
+
+var opKindNames = [...]string{"literal", "M1", "M2", "M3", "M4", "EOF"}
+
+func (k OpKind) String() string {
+	if k < 0 || int(k) >= len(opKindNames) {
+		return "unknown"
+	}
+	return opKindNames[k]
+}
+
+// OpInfo describes one opcode of a stream.
+type OpInfo struct {
+	Kind      OpKind
+	InputPos  int  // offset in src of the opcode, or of the literal bytes it carries
+	OutputPos int  // output bytes produced before the opcode
+	Length    int  // bytes the opcode produces, 0 for OpEOF
+	Offset    int  // distance back of a match's source, 0 otherwise
+	Reused    bool // an M2 match that repeats the previous match's offset
+
+	// TrailingLiterals holds the 0-3 literal bytes a match carries in the
+	// low two bits of its last offset byte, nil for none. It aliases src.
+	// The same bytes are also reported as the OpLiteral that follows.
+	TrailingLiterals []byte
+}
+
+// trailingLiterals returns the literal bytes following the match whose
+// opcode ends just before ip, as stateMatchDone finds them, or nil if
+// there are none or src ends before them.
+func trailingLiterals(src []byte, ip int, lzo1x bool) []byte {
+	stateByte := ip - 1
+	if lzo1x {
+		stateByte = ip - 2
+	}
+	if stateByte < 0 || ip > len(src) {
+		return nil
+	}
+	t := int(src[stateByte]) & 3
+	if t == 0 || ip+t > len(src) {
+		return nil
+	}
+	return src[ip : ip+t : ip+t]
+}
+
+// errStopOps is returned by decodeTokens when cfg.ops asks to stop.
+var errStopOps = errors.New("lzo1z: opcode walk stopped")
+
+// DecodeOpcodes walks the opcodes of the LZO1Z stream src and calls fn for
+// each one in order, without decoding any output. It stops early, returning
+// nil, when fn returns false.
+//
+// Literal runs of 1-3 bytes that follow a match are encoded in the match's
+// last byte rather than by an opcode of their own; they are reported in
+// the match's TrailingLiterals, and again as OpLiteral with InputPos at
+// the literal bytes.
+//
+// A malformed stream is reported like Decompress, with a *DecodeError at
+// the opcode that failed after fn has seen every opcode before it.
+func DecodeOpcodes(src []byte, fn func(op OpInfo) bool) error {
+	// Without the panic guard of decodeFrom, so that a panic in fn
+	// reaches the caller rather than becoming ErrCorrupted
+	op, ip, tokIP, tok, err := decodeTokens(src, nil, decodeConfig{walk: true, ops: fn}, decodeState{})
+	switch err {
+	case nil:
+		if ip < len(src) {
+			return errNotConsumed(ip, op)
+		}
+		return nil
+	case errStopOps:
+		return nil
+	case errMissingEOF:
+		err = ErrInputOverrun
+	}
+	return &DecodeError{Err: err, InputPos: tokIP, OutputPos: tok.op}
+}
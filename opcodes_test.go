@@ -0,0 +1,130 @@
+package lzo1z
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeOpcodes(t *testing.T) {
+	src := []byte{
+		0x12, 'a', // first literal run of 1
+		0x40, 0x02, 'b', 'c', // M2 offset 1 length 3, 2 trailing literals
+		0x00, 0x04, // M1 offset 2
+		0x23, 0x00, 0x08, // M3 offset 3 length 5
+		0x01, 'w', 'x', 'y', 'z', // literal run of 4
+		0x5c,             // M2 length 3 reusing offset 3
+		0x11, 0x00, 0x00, // EOF
+	}
+	want := []OpInfo{
+		{Kind: OpLiteral, InputPos: 0, OutputPos: 0, Length: 1},
+		{Kind: OpM2, InputPos: 2, OutputPos: 1, Length: 3, Offset: 1, TrailingLiterals: []byte("bc")},
+		{Kind: OpLiteral, InputPos: 4, OutputPos: 4, Length: 2},
+		{Kind: OpM1, InputPos: 6, OutputPos: 6, Length: 2, Offset: 2},
+		{Kind: OpM3, InputPos: 8, OutputPos: 8, Length: 5, Offset: 3},
+		{Kind: OpLiteral, InputPos: 11, OutputPos: 13, Length: 4},
+		{Kind: OpM2, InputPos: 16, OutputPos: 17, Length: 3, Offset: 3, Reused: true},
+		{Kind: OpEOF, InputPos: 17, OutputPos: 20},
+	}
+
+	var got []OpInfo
+	if err := DecodeOpcodes(src, func(op OpInfo) bool {
+		got = append(got, op)
+		return true
+	}); err != nil {
+		t.Fatalf("DecodeOpcodes failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("opcodes:\n got %+v\nwant %+v", got, want)
+	}
+	if n, err := Decompress(src, make([]byte, 20)); n != 20 || err != nil {
+		t.Errorf("Decompress = (%d, %v), want (20, nil)", n, err)
+	}
+
+	// Returning false stops the walk
+	got = got[:0]
+	if err := DecodeOpcodes(src, func(op OpInfo) bool {
+		got = append(got, op)
+		return op.Kind != OpM1
+	}); err != nil || len(got) != 4 {
+		t.Errorf("stopped walk: got %d opcodes and %v, want 4 and nil", len(got), err)
+	}
+}
+
+func TestDecodeOpcodesMatchesCompress(t *testing.T) {
+	for _, tc := range interopTestCases {
+		out := 0
+		err := DecodeOpcodes(tc.compressed, func(op OpInfo) bool {
+			if op.OutputPos != out {
+				t.Fatalf("%s: opcode at output %d, want %d", tc.name, op.OutputPos, out)
+			}
+			out += op.Length
+			return true
+		})
+		if err != nil || out != tc.inputLen {
+			t.Errorf("%s: walk = (%d bytes, %v), want (%d, nil)", tc.name, out, err, tc.inputLen)
+		}
+	}
+}
+
+func TestDecodeOpcodesTrailingLiterals(t *testing.T) {
+	// Matches followed by 1, 2 and 3 trailing literals, one reusing the
+	// offset whose count sits in the opcode itself, and one with none
+	src := []byte{
+		0x03, 'a', 'b', 'c', 'd', 'e', 'f', // literal run of 6
+		0x40, 0x11, 'X', // M2 offset 5 length 3, 1 trailing
+		0x23, 0x00, 0x1a, 'Y', 'Z', // M3 offset 7 length 5, 2 trailing
+		0x00, 0x07, '1', '2', '3', // M1 offset 2, 3 trailing
+		0x5f, 'p', 'q', 'r', // M2 length 3 reusing offset 2, 3 trailing
+		0x40, 0x04, // M2 offset 2 length 3, none
+		0x11, 0x00, 0x00, // EOF
+	}
+	want := map[int]string{7: "X", 10: "YZ", 15: "123", 20: "pqr", 24: ""}
+
+	got := map[int]string{}
+	err := DecodeOpcodes(src, func(op OpInfo) bool {
+		if op.Kind != OpLiteral && op.Kind != OpEOF {
+			if op.TrailingLiterals != nil && len(op.TrailingLiterals) == 0 {
+				t.Errorf("match at %d: empty non-nil TrailingLiterals", op.InputPos)
+			}
+			got[op.InputPos] = string(op.TrailingLiterals)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("DecodeOpcodes failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("trailing literals by match:\n got %q\nwant %q", got, want)
+	}
+	if n, err := Decompress(src, make([]byte, 31)); n != 31 || err != nil {
+		t.Errorf("Decompress = (%d, %v), want (31, nil)", n, err)
+	}
+}
+
+func TestDecodeOpcodesErrors(t *testing.T) {
+	nop := func(OpInfo) bool { return true }
+	tests := []struct {
+		name    string
+		src     []byte
+		wantErr error
+		wantIP  int
+	}{
+		{"truncated_literal", []byte{0x15, 'a'}, ErrInputOverrun, 0},
+		{"lookbehind", []byte{0x12, 'a', 0x40, 0x04}, ErrLookbehindOverrun, 2},
+		{"missing_eof", []byte{0x12, 'a', 0x40, 0x00}, ErrInputOverrun, 4},
+		{"trailing_data", []byte{0x11, 0x00, 0x00, 0x00}, ErrInputNotConsumed, 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := DecodeOpcodes(tc.src, nop)
+			var de *DecodeError
+			if !errors.As(err, &de) || de.Err != tc.wantErr || de.InputPos != tc.wantIP {
+				t.Errorf("expected %v at input %d, got %v", tc.wantErr, tc.wantIP, err)
+			}
+			if _, derr := Decompress(tc.src, make([]byte, 64)); derr.Error() != err.Error() {
+				t.Errorf("Decompress reports %v, DecodeOpcodes %v", derr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package lzo1z
+
+// DecodeOptions configures DecompressWithOptions.
+// The zero value behaves exactly like Decompress.
+type DecodeOptions struct {
+	// ZeroOnError zeroes dst beyond the bytes written when decoding fails,
+	// so no stale buffer contents leak to callers that ignore the error.
+	ZeroOnError bool
+
+	// MaxMatchLen rejects any match that copies more than this many bytes
+	// with ErrMatchTooLong, e.g. to pre-validate streams for decoders with
+	// a hardware copy limit. Zero means no limit.
+	MaxMatchLen int
+}
+
+// DecompressWithOptions decompresses src into dst like Decompress,
+// applying the behavior selected by opts.
+func DecompressWithOptions(src, dst []byte, opts DecodeOptions) (int, error) {
+	n, ip, err := decompress(src, dst, decodeConfig{maxMatchLen: opts.MaxMatchLen})
+	if err == nil && ip < len(src) {
+		err = errNotConsumed(ip, n)
+	}
+	if err != nil && opts.ZeroOnError {
+		clear(dst[n:])
+	}
+	return n, err
+}
@@ -0,0 +1,134 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecompressWithOptionsZeroOnError(t *testing.T) {
+	// 10 literals, then EOF; dst only fits 5
+	compressed := []byte{0x1b, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x11, 0x00, 0x00}
+
+	dst := bytes.Repeat([]byte{0xee}, 5)
+	n, err := DecompressWithOptions(compressed, dst, DecodeOptions{ZeroOnError: true})
+	if !errors.Is(err, ErrOutputOverrun) {
+		t.Fatalf("expected ErrOutputOverrun, got %v", err)
+	}
+	for i, b := range dst[n:] {
+		if b != 0 {
+			t.Fatalf("dst[%d] = 0x%02x, want 0", n+i, b)
+		}
+	}
+
+	// Without the option the stale bytes are left alone
+	dst = bytes.Repeat([]byte{0xee}, 5)
+	n, _ = DecompressWithOptions(compressed, dst, DecodeOptions{})
+	if !bytes.Equal(dst[n:], bytes.Repeat([]byte{0xee}, 5-n)) {
+		t.Errorf("dst tail modified without ZeroOnError")
+	}
+}
+
+func TestDecompressWithOptionsZeroOnErrorLookbehind(t *testing.T) {
+	compressed := []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}
+
+	dst := bytes.Repeat([]byte{0xee}, 100)
+	n, err := DecompressWithOptions(compressed, dst, DecodeOptions{ZeroOnError: true})
+	if !errors.Is(err, ErrLookbehindOverrun) {
+		t.Fatalf("expected ErrLookbehindOverrun, got %v", err)
+	}
+	if string(dst[:n]) != "ABCD" {
+		t.Errorf("partial output = %q, want %q", dst[:n], "ABCD")
+	}
+	if !bytes.Equal(dst[n:], make([]byte, len(dst)-n)) {
+		t.Errorf("dst tail not zeroed")
+	}
+}
+
+func TestDecompressWithOptionsSuccessKeepsTail(t *testing.T) {
+	compressed := []byte{0x14, 0x41, 0x42, 0x43, 0x11, 0x00, 0x00}
+
+	dst := bytes.Repeat([]byte{0xee}, 10)
+	n, err := DecompressWithOptions(compressed, dst, DecodeOptions{ZeroOnError: true})
+	if err != nil {
+		t.Fatalf("DecompressWithOptions failed: %v", err)
+	}
+	if string(dst[:n]) != "ABC" {
+		t.Errorf("got %q, want %q", dst[:n], "ABC")
+	}
+	if !bytes.Equal(dst[n:], bytes.Repeat([]byte{0xee}, 10-n)) {
+		t.Errorf("dst tail modified on success")
+	}
+}
+
+func TestDecompressWithOptionsMaxMatchLen(t *testing.T) {
+	// 'A' followed by a 500-byte M3 match at offset 1
+	compressed := []byte{
+		0x12, 0x41, // 1 literal 'A'
+		0x20, 0x00, 0xd4, // M3 extended length: 255 + 31 + 212 + 2 = 500
+		0x00, 0x00, // offset 1
+		0x11, 0x00, 0x00, // EOF
+	}
+	want := bytes.Repeat([]byte("A"), 501)
+
+	dst := make([]byte, 600)
+	_, err := DecompressWithOptions(compressed, dst, DecodeOptions{MaxMatchLen: 264})
+	if !errors.Is(err, ErrMatchTooLong) {
+		t.Errorf("cap 264: expected ErrMatchTooLong, got %v", err)
+	}
+
+	n, err := DecompressWithOptions(compressed, dst, DecodeOptions{MaxMatchLen: 512})
+	if err != nil {
+		t.Fatalf("cap 512: unexpected error: %v", err)
+	}
+	if !bytes.Equal(dst[:n], want) {
+		t.Errorf("cap 512: output mismatch")
+	}
+
+	n, err = DecompressWithOptions(compressed, dst, DecodeOptions{})
+	if err != nil || n != len(want) {
+		t.Errorf("no cap: got (%d, %v), want (%d, nil)", n, err, len(want))
+	}
+}
+
+func TestDecompressWithOptionsMaxMatchLenMatchTypes(t *testing.T) {
+	eof := []byte{0x11, 0x00, 0x00}
+	tests := []struct {
+		name   string
+		stream []byte
+		mLen   int
+	}{
+		{"m1", []byte{0x12, 0x41, 0x00, 0x00}, 2},
+		{"m1_after_literal_run", append(literalRun(1800), 0x00, 0x00), 3},
+		{"m2", []byte{0x12, 0x41, 0x40, 0x00}, 3},
+		{"m3", []byte{0x12, 0x41, 0x21, 0x00, 0x00}, 3},
+		{"m4", append(literalRun(16390), 0x11, 0x00, 0x04), 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := append(tc.stream, eof...)
+			dst := make([]byte, 20000)
+
+			_, err := DecompressWithOptions(stream, dst, DecodeOptions{MaxMatchLen: tc.mLen - 1})
+			if !errors.Is(err, ErrMatchTooLong) {
+				t.Errorf("cap %d: expected ErrMatchTooLong, got %v", tc.mLen-1, err)
+			}
+			if _, err := DecompressWithOptions(stream, dst, DecodeOptions{MaxMatchLen: tc.mLen}); err != nil {
+				t.Errorf("cap %d: unexpected error: %v", tc.mLen, err)
+			}
+		})
+	}
+}
+
+// literalRun encodes n >= 19 literal bytes as a leading extended literal run.
+func literalRun(n int) []byte {
+	b := []byte{0x00}
+	t := n - 3 - 15
+	for t > 255 {
+		b = append(b, 0x00)
+		t -= 255
+	}
+	b = append(b, byte(t))
+	return append(b, bytes.Repeat([]byte{'L'}, n)...)
+}
@@ -0,0 +1,254 @@
+package lzo1z
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// MaxParallelCompressedSize returns the largest output CompressParallel
+// can produce for n input bytes split into blocks of blockSize bytes.
+// Sizes below 1 use DefaultBlockSize.
+func MaxParallelCompressedSize(n, blockSize int) int {
+	if blockSize < 1 {
+		blockSize = DefaultBlockSize
+	}
+	size := blockHeaderLen // terminator
+	for ; n > blockSize; n -= blockSize {
+		size += blockHeaderLen + MaxCompressedSize(blockSize)
+	}
+	if n > 0 {
+		size += blockHeaderLen + MaxCompressedSize(n)
+	}
+	return size
+}
+
+// CompressParallel splits src into blocks of blockSize bytes, compresses
+// them on up to workers goroutines and writes them to dst in order, in
+// the block format produced by Writer (so NewReader can also decode it).
+// Returns the number of bytes written to dst.
+//
+// Every block is compressed independently, trading a little ratio for
+// throughput. The output depends only on src and blockSize, never on the
+// number of workers. dst must be sized with MaxParallelCompressedSize.
+// A blockSize below 1 uses DefaultBlockSize, and workers below 1 uses
+// GOMAXPROCS.
+func CompressParallel(src, dst []byte, blockSize, workers int) (int, error) {
+	if blockSize < 1 {
+		blockSize = DefaultBlockSize
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	nBlocks := (len(src) + blockSize - 1) / blockSize
+	blocks := make([][]byte, nBlocks)
+	errs := make([]error, nBlocks)
+
+	parallel(nBlocks, workers, func(next func() (int, bool)) {
+		c := NewCompressor()
+		for i, ok := next(); ok; i, ok = next() {
+			raw := src[i*blockSize : min((i+1)*blockSize, len(src))]
+			buf := make([]byte, MaxCompressedSize(len(raw)))
+			n, err := c.Compress(raw, buf)
+			blocks[i], errs[i] = buf[:n], err
+		}
+	})
+
+	op := 0
+	for i, block := range blocks {
+		if errs[i] != nil {
+			return op, errs[i]
+		}
+		if op+blockHeaderLen+len(block) > len(dst) {
+			return op, ErrOutputOverrun
+		}
+		rawLen := min(blockSize, len(src)-i*blockSize)
+		binary.BigEndian.PutUint32(dst[op:], uint32(rawLen))
+		binary.BigEndian.PutUint32(dst[op+4:], uint32(len(block)))
+		op += blockHeaderLen
+		op += copy(dst[op:], block)
+	}
+
+	if op+blockHeaderLen > len(dst) {
+		return op, ErrOutputOverrun
+	}
+	clear(dst[op : op+blockHeaderLen])
+	return op + blockHeaderLen, nil
+}
+
+// CompressReaderAt compresses the size bytes of r into w in the block
+// format produced by Writer, so NewReader can decode it. Blocks of
+// blockSize bytes are read with ReadAt and compressed on GOMAXPROCS
+// goroutines, a batch of up to one block per goroutine at a time, so
+// memory stays bounded however large the input is.
+//
+// The output is identical to a Writer from NewWriterSize with the same
+// blockSize. A blockSize below 1 uses DefaultBlockSize. An input shorter
+// than size returns ErrInputOverrun; errors from r and w are returned as is.
+func CompressReaderAt(r io.ReaderAt, size int64, w io.Writer, blockSize int) error {
+	if size < 0 {
+		return fmt.Errorf("lzo1z: invalid size: %d", size)
+	}
+	if blockSize < 1 {
+		blockSize = DefaultBlockSize
+	}
+	bs := int64(blockSize)
+	nBlocks := (size + bs - 1) / bs
+
+	type slot struct {
+		c   *Compressor
+		raw []byte
+		out []byte // header + compressed block
+		n   int    // length of out written
+		err error
+	}
+	workers := runtime.GOMAXPROCS(0)
+	if nBlocks < int64(workers) {
+		workers = int(nBlocks)
+	}
+	slots := make([]slot, workers)
+
+	for first := int64(0); first < nBlocks; first += int64(workers) {
+		batch := workers
+		if left := nBlocks - first; left < int64(batch) {
+			batch = int(left)
+		}
+		parallel(batch, batch, func(next func() (int, bool)) {
+			for i, ok := next(); ok; i, ok = next() {
+				s := &slots[i]
+				if s.c == nil {
+					s.c = NewCompressor()
+					s.raw = make([]byte, blockSize)
+					s.out = make([]byte, blockHeaderLen+MaxCompressedSize(blockSize))
+				}
+				off := (first + int64(i)) * bs
+				raw := s.raw
+				if left := size - off; left < bs {
+					raw = raw[:left]
+				}
+				if s.err = readFullAt(r, raw, off); s.err != nil {
+					continue
+				}
+				var n int
+				n, s.err = s.c.Compress(raw, s.out[blockHeaderLen:])
+				binary.BigEndian.PutUint32(s.out[0:], uint32(len(raw)))
+				binary.BigEndian.PutUint32(s.out[4:], uint32(n))
+				s.n = blockHeaderLen + n
+			}
+		})
+
+		for i := 0; i < batch; i++ {
+			if slots[i].err != nil {
+				return slots[i].err
+			}
+			if _, err := w.Write(slots[i].out[:slots[i].n]); err != nil {
+				return err
+			}
+		}
+	}
+
+	var end [blockHeaderLen]byte
+	_, err := w.Write(end[:])
+	return err
+}
+
+// DecompressParallel decodes a block stream produced by CompressParallel
+// or Writer into dst, decoding blocks on up to workers goroutines.
+// Returns the total number of bytes written to dst.
+//
+// The block headers are validated before any decoding: a truncated stream
+// returns ErrInputOverrun, bytes after the terminator ErrInputNotConsumed,
+// and a corrupt header ErrCorrupted. If several blocks fail to decode,
+// the error of the first is returned. Workers below 1 uses GOMAXPROCS.
+func DecompressParallel(src, dst []byte, workers int) (int, error) {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	type block struct {
+		comp []byte
+		out  []byte
+	}
+	var blocks []block
+
+	ip, op := 0, 0
+	for {
+		if ip+blockHeaderLen > len(src) {
+			return 0, ErrInputOverrun
+		}
+		rawLen := uint64(binary.BigEndian.Uint32(src[ip:]))
+		compLen := uint64(binary.BigEndian.Uint32(src[ip+4:]))
+		ip += blockHeaderLen
+
+		if rawLen == 0 {
+			if compLen != 0 {
+				return 0, ErrCorrupted
+			}
+			break
+		}
+		if rawLen > 255*compLen {
+			return 0, ErrCorrupted
+		}
+		if compLen > uint64(len(src)-ip) {
+			return 0, ErrInputOverrun
+		}
+		if rawLen > uint64(len(dst)-op) {
+			return 0, ErrOutputOverrun
+		}
+		blocks = append(blocks, block{src[ip : ip+int(compLen)], dst[op : op+int(rawLen)]})
+		ip += int(compLen)
+		op += int(rawLen)
+	}
+	if ip < len(src) {
+		return 0, ErrInputNotConsumed
+	}
+
+	errs := make([]error, len(blocks))
+	parallel(len(blocks), workers, func(next func() (int, bool)) {
+		for i, ok := next(); ok; i, ok = next() {
+			n, err := Decompress(blocks[i].comp, blocks[i].out)
+			if err == nil && n != len(blocks[i].out) {
+				err = ErrCorrupted
+			}
+			errs[i] = err
+		}
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	return op, nil
+}
+
+// parallel runs up to workers goroutines, each calling work with a
+// function that hands out the indexes 0..n-1 until they are exhausted.
+func parallel(n, workers int, work func(next func() (int, bool))) {
+	workers = min(workers, n)
+
+	var mu sync.Mutex
+	i := 0
+	next := func() (int, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if i >= n {
+			return 0, false
+		}
+		i++
+		return i - 1, true
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			work(next)
+		}()
+	}
+	wg.Wait()
+}
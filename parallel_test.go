@@ -0,0 +1,200 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func parallelInput() []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < 300000; i++ {
+		fmt.Fprintf(&b, "record %d: value=%d status=%s\n", i, i*i%1000, []string{"ok", "retry", "fail"}[i%3])
+	}
+	return b.Bytes()
+}
+
+func TestCompressParallelDeterministic(t *testing.T) {
+	input := parallelInput()
+
+	for _, blockSize := range []int{1000, 4096, 65536, 1 << 20} {
+		dst := make([]byte, MaxParallelCompressedSize(len(input), blockSize))
+		var first []byte
+		for _, workers := range []int{1, 2, 3, 8} {
+			n, err := CompressParallel(input, dst, blockSize, workers)
+			if err != nil {
+				t.Fatalf("block %d workers %d: CompressParallel failed: %v", blockSize, workers, err)
+			}
+			if first == nil {
+				first = append([]byte{}, dst[:n]...)
+			} else if !bytes.Equal(dst[:n], first) {
+				t.Errorf("block %d: output with %d workers differs from 1 worker", blockSize, workers)
+			}
+		}
+
+		// The stream is the Writer block format
+		if got := decodeBlocks(t, first); !bytes.Equal(got, input) {
+			t.Errorf("block %d: Writer-format decode mismatch", blockSize)
+		}
+
+		for _, workers := range []int{1, 4} {
+			out := make([]byte, len(input))
+			n, err := DecompressParallel(first, out, workers)
+			if err != nil {
+				t.Fatalf("block %d workers %d: DecompressParallel failed: %v", blockSize, workers, err)
+			}
+			if !bytes.Equal(out[:n], input) {
+				t.Errorf("block %d workers %d: roundtrip mismatch", blockSize, workers)
+			}
+		}
+	}
+}
+
+func TestCompressParallelMatchesWriter(t *testing.T) {
+	input := parallelInput()
+
+	var buf bytes.Buffer
+	w := NewWriterSize(&buf, 10000)
+	if _, err := w.Write(input); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	dst := make([]byte, MaxParallelCompressedSize(len(input), 10000))
+	n, err := CompressParallel(input, dst, 10000, 4)
+	if err != nil {
+		t.Fatalf("CompressParallel failed: %v", err)
+	}
+	if !bytes.Equal(dst[:n], buf.Bytes()) {
+		t.Errorf("CompressParallel output differs from Writer")
+	}
+
+	got, err := io.ReadAll(NewReader(bytes.NewReader(dst[:n])))
+	if err != nil || !bytes.Equal(got, input) {
+		t.Errorf("Reader roundtrip failed: %v", err)
+	}
+}
+
+func TestCompressParallelEmpty(t *testing.T) {
+	dst := make([]byte, MaxParallelCompressedSize(0, 0))
+	n, err := CompressParallel(nil, dst, 0, 0)
+	if err != nil || n != blockHeaderLen {
+		t.Fatalf("CompressParallel(nil) = (%d, %v), want (%d, nil)", n, err, blockHeaderLen)
+	}
+	if m, err := DecompressParallel(dst[:n], nil, 0); m != 0 || err != nil {
+		t.Errorf("DecompressParallel(empty) = (%d, %v), want (0, nil)", m, err)
+	}
+}
+
+func TestCompressParallelOutputOverrun(t *testing.T) {
+	input := parallelInput()
+	dst := make([]byte, 100)
+	if _, err := CompressParallel(input, dst, 4096, 2); !errors.Is(err, ErrOutputOverrun) {
+		t.Errorf("expected ErrOutputOverrun, got %v", err)
+	}
+}
+
+func TestCompressReaderAt(t *testing.T) {
+	input := bytes.Repeat(parallelInput(), 10)
+	for _, blockSize := range []int{0, 10000, 1 << 20} {
+		var want bytes.Buffer
+		w := NewWriterSize(&want, blockSize)
+		w.Write(input)
+		if err := w.Close(); err != nil {
+			t.Fatalf("block size %d: Close failed: %v", blockSize, err)
+		}
+
+		var got bytes.Buffer
+		if err := CompressReaderAt(bytes.NewReader(input), int64(len(input)), &got, blockSize); err != nil {
+			t.Fatalf("block size %d: CompressReaderAt failed: %v", blockSize, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("block size %d: output differs from Writer", blockSize)
+		}
+		out, err := io.ReadAll(NewReader(&got))
+		if err != nil || !bytes.Equal(out, input) {
+			t.Errorf("block size %d: Reader roundtrip failed: %v", blockSize, err)
+		}
+	}
+}
+
+type failReaderAt struct{ err error }
+
+func (f failReaderAt) ReadAt([]byte, int64) (int, error) { return 0, f.err }
+
+func TestCompressReaderAtErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := CompressReaderAt(bytes.NewReader(nil), 0, &buf, 0); err != nil || buf.Len() != blockHeaderLen {
+		t.Errorf("empty input: got %d bytes, %v", buf.Len(), err)
+	}
+
+	input := parallelInput()
+	if err := CompressReaderAt(bytes.NewReader(input), int64(len(input))+1, io.Discard, 4096); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("short input: expected ErrInputOverrun, got %v", err)
+	}
+	errBoom := errors.New("boom")
+	if err := CompressReaderAt(failReaderAt{errBoom}, 100, io.Discard, 0); err != errBoom {
+		t.Errorf("failing ReaderAt: expected errBoom, got %v", err)
+	}
+	if err := CompressReaderAt(bytes.NewReader(input), int64(len(input)), failWriter{errBoom}, 0); err != errBoom {
+		t.Errorf("failing Writer: expected errBoom, got %v", err)
+	}
+	if err := CompressReaderAt(bytes.NewReader(input), -1, io.Discard, 0); err == nil {
+		t.Errorf("negative size: expected an error")
+	}
+}
+
+func TestDecompressParallelErrors(t *testing.T) {
+	input := parallelInput()
+	comp := make([]byte, MaxParallelCompressedSize(len(input), 4096))
+	n, err := CompressParallel(input, comp, 4096, 4)
+	if err != nil {
+		t.Fatalf("CompressParallel failed: %v", err)
+	}
+	comp = comp[:n]
+
+	corruptBlock := append([]byte{}, comp...)
+	corruptBlock[blockHeaderLen+4096] ^= 0xff // inside the first block's data
+
+	tests := []struct {
+		name    string
+		src     []byte
+		dstLen  int
+		wantErr error
+	}{
+		{"truncated_terminator", comp[:len(comp)-1], len(input), ErrInputOverrun},
+		{"truncated_block", comp[:100], len(input), ErrInputOverrun},
+		{"trailing_garbage", append(append([]byte{}, comp...), 0), len(input), ErrInputNotConsumed},
+		{"dst_too_small", comp, len(input) - 1, ErrOutputOverrun},
+		{"bad_terminator", append(append([]byte{}, comp[:len(comp)-1]...), 1), len(input), ErrCorrupted},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecompressParallel(tc.src, make([]byte, tc.dstLen), 4)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := DecompressParallel(corruptBlock, make([]byte, len(input)), 4); err == nil {
+		t.Errorf("corrupt block: expected an error")
+	}
+}
+
+func BenchmarkCompressParallel(b *testing.B) {
+	input := bytes.Repeat(parallelInput(), 10)
+	dst := make([]byte, MaxParallelCompressedSize(len(input), DefaultBlockSize))
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				_, _ = CompressParallel(input, dst, DefaultBlockSize, workers)
+			}
+		})
+	}
+}
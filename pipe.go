@@ -0,0 +1,17 @@
+package lzo1z
+
+import "io"
+
+// Pipe returns a Writer and a Reader connected by an in-memory io.Pipe:
+// data written to the Writer is compressed in blocks, and the Reader
+// serves it decompressed. It suits tests and in-process transforms that
+// should exercise the block stream format.
+//
+// As with io.Pipe, a write blocks until the Reader has read the blocks it
+// completes, so the two ends must be used from different goroutines. The
+// Reader sees io.EOF once the Writer is closed. Flush the Writer to hand
+// the Reader a partial block early.
+func Pipe() (*Writer, *Reader) {
+	pr, pw := io.Pipe()
+	return NewWriter(pw), NewReader(pr)
+}
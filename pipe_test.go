@@ -0,0 +1,37 @@
+package lzo1z
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	input := bytes.Repeat(parallelInput(), 5)
+	w, r := Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		// Uneven writes, so blocks span several of them
+		for p := input; len(p) > 0; {
+			n := min(len(p), 12345)
+			if _, err := w.Write(p[:n]); err != nil {
+				done <- err
+				return
+			}
+			p = p[n:]
+		}
+		done <- w.Close()
+	}()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("writing failed: %v", err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Errorf("got %d bytes, want %d", len(got), len(input))
+	}
+}
@@ -0,0 +1,57 @@
+package lzo1z
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// pooledBuf is a reusable decode buffer. gen counts its releases; each
+// handout's release func records the count it was issued at, so a late
+// second call from an earlier handout cannot return the buffer while a
+// later caller holds it.
+type pooledBuf struct {
+	b   []byte
+	gen atomic.Uint64
+}
+
+var decodePool = sync.Pool{New: func() any { return new(pooledBuf) }}
+
+// releaser returns the release func for the current handout of pb.
+func (pb *pooledBuf) releaser() func() {
+	gen := pb.gen.Load()
+	return func() {
+		if pb.gen.CompareAndSwap(gen, gen+1) {
+			decodePool.Put(pb)
+		}
+	}
+}
+
+// DecompressPooled decompresses src into a buffer taken from an internal
+// pool, sized exactly to the decompressed length.
+// Returns the output and a release function that hands the buffer back to
+// the pool; the output must not be used after release is called. Calls
+// to release after the first are ignored, even once the buffer has been
+// handed to another caller, so a deferred release is safe alongside an
+// explicit one.
+//
+// The decompressed size is computed with a validating walk over the stream
+// before decoding, so once the pool holds buffers large enough for the
+// workload the only allocation per call is the small release func.
+func DecompressPooled(src []byte) ([]byte, func(), error) {
+	size, err := DecompressedSize(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pb := decodePool.Get().(*pooledBuf)
+	if cap(pb.b) < size {
+		pb.b = make([]byte, size)
+	}
+
+	n, err := Decompress(src, pb.b[:size])
+	if err != nil {
+		decodePool.Put(pb)
+		return nil, nil, err
+	}
+	return pb.b[:n], pb.releaser(), nil
+}
@@ -0,0 +1,165 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecompressPooled(t *testing.T) {
+	for _, tc := range interopTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, release, err := DecompressPooled(tc.compressed)
+			if err != nil {
+				t.Fatalf("DecompressPooled failed: %v", err)
+			}
+			if !bytes.Equal(out, tc.input) {
+				t.Errorf("output mismatch: got %d bytes, want %d", len(out), len(tc.input))
+			}
+			if release != nil {
+				release()
+			}
+		})
+	}
+}
+
+func TestDecompressPooledReuse(t *testing.T) {
+	a := []byte{0x1b, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x11, 0x00, 0x00}
+	b := []byte{0x14, 0x42, 0x42, 0x42, 0x11, 0x00, 0x00}
+
+	out, release, err := DecompressPooled(a)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+	if string(out) != "AAAAAAAAAA" {
+		t.Fatalf("got %q", out)
+	}
+	release()
+
+	// A released buffer may be handed out again; its new contents must
+	// reflect only the new stream
+	out, release, err = DecompressPooled(b)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+	if string(out) != "BBB" {
+		t.Errorf("got %q, want %q", out, "BBB")
+	}
+	release()
+}
+
+func TestDecompressPooledDoubleRelease(t *testing.T) {
+	a := []byte{0x1b, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x11, 0x00, 0x00}
+	b := []byte{0x14, 0x42, 0x42, 0x42, 0x11, 0x00, 0x00}
+
+	_, release, err := DecompressPooled(a)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+	release()
+	release()
+
+	// Had the buffer gone back to the pool twice, both of these could be
+	// handed the same one
+	outA, releaseA, err := DecompressPooled(a)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+	outB, releaseB, err := DecompressPooled(b)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+	if string(outA) != "AAAAAAAAAA" || string(outB) != "BBB" {
+		t.Errorf("buffers shared after double release: got %q and %q", outA, outB)
+	}
+	releaseA()
+	releaseB()
+}
+
+func TestDecompressPooledStaleRelease(t *testing.T) {
+	a := []byte{0x1b, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x11, 0x00, 0x00}
+	b := []byte{0x14, 0x42, 0x42, 0x42, 0x11, 0x00, 0x00}
+
+	_, releaseA, err := DecompressPooled(a)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+	releaseA()
+	outB, releaseB, err := DecompressPooled(b)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+
+	// A late release from the first handout must not return the buffer
+	// B is still using
+	releaseA()
+	outC, releaseC, err := DecompressPooled(a)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+	if &outB[0] == &outC[0] {
+		t.Errorf("stale release handed B's buffer to C")
+	}
+	if string(outB) != "BBB" || string(outC) != "AAAAAAAAAA" {
+		t.Errorf("got %q and %q", outB, outC)
+	}
+	releaseB()
+	releaseC()
+}
+
+func TestDecompressPooledErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"truncated", []byte{0x15, 0x41, 0x42}, ErrInputOverrun},
+		{"lookbehind", []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}, ErrLookbehindOverrun},
+		{"trailing_garbage", []byte{0x12, 0x41, 0x11, 0x00, 0x00, 0x00}, ErrInputNotConsumed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, release, err := DecompressPooled(tc.src)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+			if release != nil {
+				t.Errorf("release func returned with error")
+			}
+		})
+	}
+}
+
+func TestDecompressPooledAllocs(t *testing.T) {
+	src, _ := Canonicalize(interopTestCases[0].compressed, 0)
+
+	// Warm the pool
+	_, release, err := DecompressPooled(src)
+	if err != nil {
+		t.Fatalf("DecompressPooled failed: %v", err)
+	}
+	release()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, release, _ := DecompressPooled(src)
+		release()
+	})
+	// The release func is the only allocation; the buffer is reused
+	if allocs > 1 {
+		t.Errorf("DecompressPooled allocated %.1f times per call, want 1", allocs)
+	}
+}
+
+func BenchmarkDecompressPooled(b *testing.B) {
+	src, err := Canonicalize(interopTestCases[0].compressed, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, release, _ := DecompressPooled(src)
+		release()
+	}
+}
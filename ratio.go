@@ -0,0 +1,37 @@
+package lzo1z
+
+// RatioProfile compresses src in consecutive windows of the given size and
+// returns the compressed-to-original size ratio of each window (lower is
+// better). The final window may be shorter than window.
+//
+// Each window is compressed independently, so the profile shows how well
+// each region compresses on its own. Returns nil if src is empty or
+// window is not positive.
+func RatioProfile(src []byte, window int) []float64 {
+	if len(src) == 0 || window <= 0 {
+		return nil
+	}
+
+	n := (len(src) + window - 1) / window
+	ratios := make([]float64, 0, n)
+
+	scratchLen := window
+	if len(src) < scratchLen {
+		scratchLen = len(src)
+	}
+	scratch := make([]byte, MaxCompressedSize(scratchLen))
+
+	for start := 0; start < len(src); start += window {
+		end := start + window
+		if end > len(src) {
+			end = len(src)
+		}
+		compLen, err := Compress(src[start:end], scratch)
+		if err != nil {
+			// Cannot happen: scratch is sized for the worst case
+			panic("lzo1z: " + err.Error())
+		}
+		ratios = append(ratios, float64(compLen)/float64(end-start))
+	}
+	return ratios
+}
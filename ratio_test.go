@@ -0,0 +1,57 @@
+package lzo1z
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestRatioProfileSplit(t *testing.T) {
+	const half = 8192
+	input := make([]byte, 2*half)
+	copy(input, bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), half/45+1))
+	rand.New(rand.NewSource(1)).Read(input[half:])
+
+	ratios := RatioProfile(input, 1024)
+	if len(ratios) != 16 {
+		t.Fatalf("got %d windows, want 16", len(ratios))
+	}
+
+	for i, r := range ratios[:8] {
+		if r > 0.2 {
+			t.Errorf("window %d (repetitive): ratio %.3f, want < 0.2", i, r)
+		}
+	}
+	for i, r := range ratios[8:] {
+		if r < 0.95 {
+			t.Errorf("window %d (random): ratio %.3f, want >= 0.95", i+8, r)
+		}
+	}
+}
+
+func TestRatioProfilePartialWindow(t *testing.T) {
+	input := bytes.Repeat([]byte("A"), 2500)
+
+	ratios := RatioProfile(input, 1000)
+	if len(ratios) != 3 {
+		t.Fatalf("got %d windows, want 3", len(ratios))
+	}
+
+	dst := make([]byte, MaxCompressedSize(500))
+	n, err := Compress(input[2000:], dst)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	if want := float64(n) / 500; ratios[2] != want {
+		t.Errorf("last window ratio %.4f, want %.4f", ratios[2], want)
+	}
+}
+
+func TestRatioProfileInvalid(t *testing.T) {
+	if r := RatioProfile(nil, 1024); r != nil {
+		t.Errorf("RatioProfile(nil) = %v, want nil", r)
+	}
+	if r := RatioProfile([]byte("abc"), 0); r != nil {
+		t.Errorf("RatioProfile(window=0) = %v, want nil", r)
+	}
+}
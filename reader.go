@@ -0,0 +1,205 @@
+package lzo1z
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+)
+
+// Reader is an io.Reader that decompresses the block stream produced by
+// Writer.
+type Reader struct {
+	// MaxOutputLen caps the decompressed size of the stream: the first
+	// block whose header would take the output past it fails with
+	// ErrOutputTooLarge, before the block is read or its buffer allocated.
+	// The blocks before it are still served. Zero means no limit.
+	MaxOutputLen int64
+
+	r    io.Reader
+	comp []byte // compressed block scratch
+	buf  []byte // current decompressed block
+	pos  int    // read position in buf
+	err  error  // sticky error, io.EOF after the stream terminator
+
+	hdrLen int    // blockHeaderLen, or checksumHeaderLen to verify block CRCs
+	block  int    // index of the next block
+	out    int64  // decompressed bytes in the blocks decoded so far
+	dict   []byte // dictionary every block was compressed against, or nil
+	window []byte // dictionary and block scratch for decompressDict
+}
+
+// NewReader returns a Reader that decompresses the block stream read
+// from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, hdrLen: blockHeaderLen}
+}
+
+// NewReadCloser is NewReader in the form of gzip.NewReader, for pipelines
+// that expect an io.ReadCloser and an error. It decodes the first block
+// before returning, so input that does not start a valid block stream is
+// reported here instead of on the first Read.
+func NewReadCloser(r io.Reader) (io.ReadCloser, error) {
+	z := NewReader(r)
+	z.err = z.readBlock()
+	if z.err != nil && z.err != io.EOF {
+		return nil, z.err
+	}
+	return z, nil
+}
+
+// NewReaderChecksum returns a Reader for the stream written by a Writer
+// from NewWriterChecksum. It verifies the CRC-32 of every block as it
+// decodes it and stops at the first block that fails, before serving any
+// of its bytes, with a *BlockError wrapping ErrChecksumMismatch. Other
+// errors decoding a block are reported in a *BlockError too.
+func NewReaderChecksum(r io.Reader) *Reader {
+	return &Reader{r: r, hdrLen: checksumHeaderLen}
+}
+
+// NewReaderDict returns a Reader for the stream written by a Writer from
+// NewWriterDict with the same dictionary. dict is copied.
+func NewReaderDict(r io.Reader, dict []byte) *Reader {
+	return &Reader{r: r, hdrLen: blockHeaderLen, dict: slices.Clone(dictWindow(dict))}
+}
+
+// BlockError reports the block of a stream that failed to decode.
+type BlockError struct {
+	Block int   // index of the block, from 0
+	Err   error // why it failed
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("lzo1z: block %d: %v", e.Block, e.Err)
+}
+
+func (e *BlockError) Unwrap() error { return e.Err }
+
+// Reset discards any buffered data and error state and makes z read a new
+// stream from r, keeping its buffers and MaxOutputLen.
+func (z *Reader) Reset(r io.Reader) {
+	z.r = r
+	z.buf = z.buf[:0]
+	z.pos = 0
+	z.err = nil
+	z.block = 0
+	z.out = 0
+}
+
+// Close makes Reader an io.ReadCloser, as the compress/flate readers are.
+// It does not close the underlying reader and always returns nil.
+func (z *Reader) Close() error {
+	return nil
+}
+
+// Read serves decompressed bytes, decoding the next block whenever the
+// current one is exhausted. It returns io.EOF only after the stream
+// terminator; a stream that ends early yields ErrInputOverrun.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.pos == len(z.buf) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.readBlock()
+	}
+
+	n := copy(p, z.buf[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+// readBlock reads and decompresses the next block into z.buf.
+func (z *Reader) readBlock() error {
+	var hdr [checksumHeaderLen]byte
+	if _, err := io.ReadFull(z.r, hdr[:z.hdrLen]); err != nil {
+		return readErr(err)
+	}
+	rawLen := binary.BigEndian.Uint32(hdr[0:])
+	compLen := binary.BigEndian.Uint32(hdr[4:])
+	sum := binary.BigEndian.Uint32(hdr[8:])
+
+	if rawLen == 0 {
+		if compLen != 0 || sum != 0 {
+			return ErrCorrupted
+		}
+		return io.EOF
+	}
+	if !validLens(rawLen, compLen) {
+		return ErrCorrupted
+	}
+	if z.MaxOutputLen > 0 && z.out+int64(rawLen) > z.MaxOutputLen {
+		return ErrOutputTooLarge
+	}
+
+	var err error
+	z.comp, err = readPayload(z.r, z.comp, int(compLen))
+	if err != nil {
+		return readErr(err)
+	}
+
+	if uint32(cap(z.buf)) < rawLen {
+		z.buf = make([]byte, rawLen)
+	}
+	z.buf = z.buf[:rawLen]
+	z.pos = 0
+
+	n, err := decompressDict(z.comp, z.buf, z.dict, &z.window, decodeConfig{guard: true})
+	if err == nil && n != int(rawLen) {
+		err = ErrCorrupted
+	}
+	if err == nil && z.hdrLen == checksumHeaderLen && crc32.ChecksumIEEE(z.buf) != sum {
+		err = ErrChecksumMismatch
+	}
+	if err != nil {
+		z.buf = z.buf[:0]
+		if z.hdrLen == checksumHeaderLen {
+			err = &BlockError{Block: z.block, Err: err}
+		}
+		return err
+	}
+	z.block++
+	z.out += int64(rawLen)
+	return nil
+}
+
+// validLens reports whether a header's decompressed and compressed lengths
+// could have been written together: each input byte expands to at most
+// 255 output bytes, and no compressor output exceeds MaxCompressedSize.
+// Together they bound the memory a corrupt header can make a reader
+// allocate.
+func validLens(rawLen, compLen uint32) bool {
+	if uint64(rawLen) > 255*uint64(compLen) || uint64(rawLen) > math.MaxInt {
+		return false
+	}
+	bound, ok := maxCompressedSize(int(rawLen), math.MaxInt)
+	return ok && uint64(compLen) <= uint64(bound)
+}
+
+// readPayload reads n bytes from r into buf, reusing its capacity, and
+// returns them. Beyond that capacity it grows buf as the bytes arrive
+// rather than allocating n up front, so a length claiming more than r
+// holds costs no more memory than r supplies.
+func readPayload(r io.Reader, buf []byte, n int) ([]byte, error) {
+	buf = buf[:0]
+	for len(buf) < n {
+		if len(buf) == cap(buf) {
+			buf = slices.Grow(buf, min(n-len(buf), max(len(buf), 64<<10)))
+		}
+		m, err := io.ReadFull(r, buf[len(buf):min(n, cap(buf))])
+		buf = buf[:len(buf)+m]
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// readErr maps a short read of the block stream to ErrInputOverrun.
+func readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInputOverrun
+	}
+	return err
+}
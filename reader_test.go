@@ -0,0 +1,307 @@
+package lzo1z
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// compressStream writes input through a Writer with the given block size.
+func compressStream(t *testing.T, input []byte, blockSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriterSize(&buf, blockSize)
+	if _, err := w.Write(input); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReaderOneByteAtATime(t *testing.T) {
+	input := bytes.Repeat([]byte("Lorem ipsum dolor sit amet, consectetur adipiscing elit. "), 300)
+	stream := compressStream(t, input, 1000)
+
+	r := NewReader(bytes.NewReader(stream))
+	var got []byte
+	p := make([]byte, 1)
+	for {
+		n, err := r.Read(p)
+		got = append(got, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+	if !bytes.Equal(got, input) {
+		t.Errorf("roundtrip mismatch: got %d bytes, want %d", len(got), len(input))
+	}
+}
+
+func TestReaderReadAll(t *testing.T) {
+	input := make([]byte, 200000)
+	for i := range input {
+		input[i] = byte(i * i >> 7)
+	}
+	stream := compressStream(t, input, DefaultBlockSize)
+
+	got, err := io.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Errorf("roundtrip mismatch")
+	}
+}
+
+func TestReaderEmptyStream(t *testing.T) {
+	stream := compressStream(t, nil, DefaultBlockSize)
+
+	n, err := NewReader(bytes.NewReader(stream)).Read(make([]byte, 10))
+	if n != 0 || err != io.EOF {
+		t.Errorf("Read = (%d, %v), want (0, io.EOF)", n, err)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	input := bytes.Repeat([]byte("Hello, World! "), 100)
+	stream := compressStream(t, input, 500)
+
+	// Cut inside the terminator, inside a block header and inside a block
+	for _, cut := range []int{len(stream) - 1, len(stream) - blockHeaderLen, blockHeaderLen + 3, 5, 0} {
+		_, err := io.ReadAll(NewReader(bytes.NewReader(stream[:cut])))
+		if !errors.Is(err, ErrInputOverrun) {
+			t.Errorf("cut at %d: expected ErrInputOverrun, got %v", cut, err)
+		}
+	}
+}
+
+func TestReaderCorrupted(t *testing.T) {
+	input := bytes.Repeat([]byte("Hello, World! "), 100)
+	stream := compressStream(t, input, 500)
+
+	tests := []struct {
+		name   string
+		mutate func(b []byte)
+	}{
+		{"raw_len_too_large", func(b []byte) { b[3]++ }},
+		{"raw_len_exceeds_ratio", func(b []byte) { b[0] = 0xff }},
+		{"terminator_with_payload", func(b []byte) { b[len(b)-1] = 1 }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bad := append([]byte{}, stream...)
+			tc.mutate(bad)
+			_, err := io.ReadAll(NewReader(bytes.NewReader(bad)))
+			if !errors.Is(err, ErrCorrupted) {
+				t.Errorf("expected ErrCorrupted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReaderHostileHeader(t *testing.T) {
+	// Headers claiming gigabytes with no payload behind them must fail
+	// without allocating what they claim
+	tests := []struct {
+		name    string
+		hdr     []byte
+		wantErr error
+	}{
+		{"both_lengths_max", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, ErrInputOverrun},
+		{"comp_len_above_bound", []byte{0x10, 0x00, 0x00, 0x00, 0x7f, 0xff, 0xff, 0xff}, ErrCorrupted},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			_, err := io.ReadAll(NewReader(bytes.NewReader(tc.hdr)))
+			runtime.ReadMemStats(&after)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+				t.Errorf("allocated %d bytes for an 8-byte stream", alloc)
+			}
+		})
+	}
+}
+
+func TestReaderMaxOutputLen(t *testing.T) {
+	input := bytes.Repeat([]byte("bounded output; "), 1000)
+	stream := compressStream(t, input, 500)
+
+	for _, limit := range []int64{1, 499, 500, 4321, int64(len(input))} {
+		r := NewReader(bytes.NewReader(stream))
+		r.MaxOutputLen = limit
+		got, err := io.ReadAll(r)
+		if limit == int64(len(input)) {
+			if err != nil || !bytes.Equal(got, input) {
+				t.Errorf("limit %d: roundtrip failed: %v", limit, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrOutputTooLarge) {
+			t.Errorf("limit %d: expected ErrOutputTooLarge, got %v", limit, err)
+		}
+		// Every whole block within the limit is served
+		if want := limit / 500 * 500; !bytes.Equal(got, input[:want]) {
+			t.Errorf("limit %d: got %d bytes, want %d", limit, len(got), want)
+		}
+	}
+}
+
+func TestReaderBlockDecodeError(t *testing.T) {
+	input := bytes.Repeat([]byte("Hello, World! "), 100)
+	stream := compressStream(t, input, 500)
+
+	// Drop the block's EOF marker but keep the header's compressed length
+	bad := append([]byte{}, stream...)
+	compLen := int(bad[7])
+	bad[blockHeaderLen+compLen-3] = 0x00
+
+	_, err := io.ReadAll(NewReader(bytes.NewReader(bad)))
+	if err == nil || err == io.EOF {
+		t.Errorf("expected a decode error, got %v", err)
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	inputs := [][]byte{
+		bytes.Repeat([]byte("first stream "), 1000),
+		{},
+		[]byte("third"),
+	}
+
+	// Start on a truncated stream, so Reset must also clear the error
+	first := compressStream(t, inputs[0], 100)
+	var r io.ReadCloser = NewReader(bytes.NewReader(first[:len(first)/2]))
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrInputOverrun) {
+		t.Fatalf("truncated stream: expected ErrInputOverrun, got %v", err)
+	}
+
+	z := r.(*Reader)
+	for i, input := range inputs {
+		z.Reset(bytes.NewReader(compressStream(t, input, 100)))
+		got, err := io.ReadAll(z)
+		if err != nil {
+			t.Fatalf("stream %d: ReadAll failed: %v", i, err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("stream %d: roundtrip mismatch", i)
+		}
+		if err := z.Close(); err != nil {
+			t.Errorf("stream %d: Close returned %v", i, err)
+		}
+	}
+}
+
+func TestReaderChecksum(t *testing.T) {
+	// Noise, so each block ends in literals that still decode when flipped
+	input := make([]byte, 3000)
+	x := uint32(7)
+	for i := range input {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		input[i] = byte(x)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriterChecksum(&buf)
+	for i := 0; i < len(input); i += 1000 {
+		w.Write(input[i : i+1000])
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	stream := buf.Bytes()
+
+	got, err := io.ReadAll(NewReaderChecksum(bytes.NewReader(stream)))
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("roundtrip failed: %v", err)
+	}
+
+	// Flip the last literal of the middle block
+	bad := append([]byte{}, stream...)
+	end := 0
+	for block := 0; block < 2; block++ {
+		end += checksumHeaderLen + int(binary.BigEndian.Uint32(bad[end+4:]))
+	}
+	bad[end-4] ^= 0x01
+
+	r := NewReaderChecksum(bytes.NewReader(bad))
+	got, err = io.ReadAll(r)
+	var be *BlockError
+	if !errors.As(err, &be) || be.Block != 1 || !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch in block 1, got %v", err)
+	}
+	if !bytes.Equal(got, input[:1000]) {
+		t.Errorf("served %d bytes before the bad block, want 1000", len(got))
+	}
+
+	// Block indexes restart with the stream
+	r.Reset(bytes.NewReader(bad))
+	if _, err := io.ReadAll(r); !errors.As(err, &be) || be.Block != 1 {
+		t.Errorf("after Reset: expected an error in block 1, got %v", err)
+	}
+
+	// The plain format has no checksums to check
+	if _, err := io.ReadAll(NewReaderChecksum(bytes.NewReader(compressStream(t, input, 1000)))); err == nil {
+		t.Errorf("plain stream read as checksummed: expected an error")
+	}
+}
+
+func TestNewReadCloser(t *testing.T) {
+	input := bytes.Repeat([]byte("read closer "), 1000)
+	for _, in := range [][]byte{input, {}} {
+		rc, err := NewReadCloser(bytes.NewReader(compressStream(t, in, 100)))
+		if err != nil {
+			t.Fatalf("NewReadCloser failed: %v", err)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Errorf("roundtrip mismatch: got %d bytes, want %d", len(got), len(in))
+		}
+		if err := rc.Close(); err != nil {
+			t.Errorf("Close returned %v", err)
+		}
+	}
+
+	// A stream whose first block is bad fails before any Read
+	stream := compressStream(t, input, 100)
+	tests := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrInputOverrun},
+		{"truncated", stream[:20], ErrInputOverrun},
+		{"corrupt_header", append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, stream...), ErrCorrupted},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc, err := NewReadCloser(bytes.NewReader(tc.src))
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+			if rc != nil {
+				t.Errorf("reader returned with error")
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package lzo1z
+
+// eofMarker terminates every LZO1Z stream (an M4 match with zero offset).
+var eofMarker = [3]byte{0x11, 0x00, 0x00}
+
+// RepairEOF restores the EOF marker of a stream whose last three bytes were
+// lost in transport.
+//
+// If src decodes cleanly up to an opcode boundary but ends without an EOF
+// marker, a copy of src with the marker appended is returned. A stream
+// that is already valid is returned unchanged. A stream that is truncated
+// mid-token, or is otherwise corrupted, cannot be repaired and the decode
+// error is returned. The stream is checked without producing its output,
+// so the memory used does not depend on the decompressed size.
+func RepairEOF(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+
+	// Walk the stream without producing output, so a damaged stream
+	// claiming a huge decompressed size costs nothing to check
+	_, ip, err := decodeStream(src, nil, decodeConfig{walk: true, guard: true})
+	switch err {
+	case nil:
+		if ip < len(src) {
+			return nil, ErrInputNotConsumed
+		}
+		return src, nil
+	case errMissingEOF:
+		fixed := make([]byte, len(src)+len(eofMarker))
+		copy(fixed, src)
+		copy(fixed[len(src):], eofMarker[:])
+		return fixed, nil
+	default:
+		return nil, err
+	}
+}
@@ -0,0 +1,102 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestRepairEOF(t *testing.T) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 20)
+	comp := make([]byte, MaxCompressedSize(len(input)))
+	n, err := Compress(input, comp)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	comp = comp[:n]
+
+	truncated := comp[:n-3]
+	out := make([]byte, len(input)+100)
+	if _, err := Decompress(truncated, out); !errors.Is(err, ErrInputOverrun) {
+		t.Fatalf("truncated stream: expected ErrInputOverrun, got %v", err)
+	}
+
+	repaired, err := RepairEOF(truncated)
+	if err != nil {
+		t.Fatalf("RepairEOF failed: %v", err)
+	}
+	if !bytes.Equal(repaired, comp) {
+		t.Errorf("repaired stream differs from original")
+	}
+	m, err := Decompress(repaired, out)
+	if err != nil {
+		t.Fatalf("Decompress(repaired) failed: %v", err)
+	}
+	if !bytes.Equal(out[:m], input) {
+		t.Errorf("repaired stream decodes to wrong output")
+	}
+}
+
+func TestRepairEOFNoOutputAlloc(t *testing.T) {
+	// One literal, then a single extended-length match repeating it 16
+	// MiB, with the EOF marker lost
+	const matchLen = 16 << 20
+	stream := make([]byte, MaxCompressedSize(matchLen))
+	n, _ := emitPendingLiterals([]byte{'a'}, stream, nil)
+	m, err := emitMatch(stream[n:], 1, matchLen)
+	if err != nil {
+		t.Fatalf("emitMatch failed: %v", err)
+	}
+	stream = stream[:n+m]
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	repaired, err := RepairEOF(stream)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("RepairEOF failed: %v", err)
+	}
+	if !bytes.Equal(repaired, append(stream[:len(stream):len(stream)], eofMarker[:]...)) {
+		t.Errorf("repaired stream is not the input plus the EOF marker")
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 2*uint64(len(stream))+4096 {
+		t.Errorf("allocated %d bytes repairing a %d-byte stream", alloc, len(stream))
+	}
+}
+
+func TestRepairEOFValidUnchanged(t *testing.T) {
+	for _, tc := range interopTestCases {
+		repaired, err := RepairEOF(tc.compressed)
+		if err != nil {
+			t.Errorf("%s: RepairEOF failed: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(repaired, tc.compressed) {
+			t.Errorf("%s: valid stream was modified", tc.name)
+		}
+	}
+}
+
+func TestRepairEOFMidToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"truncated_literals", []byte{0x15, 0x41, 0x42}, ErrInputOverrun},
+		{"truncated_m3_offset", []byte{0x12, 0x41, 0x21, 0x00}, ErrInputOverrun},
+		{"partial_eof", []byte{0x12, 0x41, 0x11, 0x00}, ErrInputOverrun},
+		{"m4_length_only", []byte{0x12, 0x41, 0x10}, ErrInputOverrun},
+		{"lookbehind", []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff}, ErrLookbehindOverrun},
+		{"trailing_garbage", []byte{0x12, 0x41, 0x11, 0x00, 0x00, 0x00}, ErrInputNotConsumed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := RepairEOF(tc.src); !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package lzo1z
+
+import (
+	"hash/crc32"
+	"io"
+)
+
+// sinkChunk is how many bytes DecompressTo decodes past its window before
+// flushing to the writer, and the most it passes to one Write.
+const sinkChunk = 64 << 10
+
+// DecompressTo decompresses src and writes the output to w, returning the
+// number of bytes written.
+//
+// Only the last maxOffset bytes of output, the furthest back a match can
+// reach, are kept for lookbehind, plus room to decode ahead, so memory
+// stays around 112 KiB however large the output is. Literal runs and
+// matches longer than that room are decoded in pieces, flushing between
+// them.
+//
+// Decoding errors are reported like Decompress, with the output of every
+// opcode before the failing one written to w.
+func DecompressTo(w io.Writer, src []byte) (int, error) {
+	return decompressTo(w, src, make([]byte, DefaultWindowSize), nil, maxOffset, true)
+}
+
+// DecompressToWriterCRC is DecompressTo that also returns the CRC-32
+// (IEEE) of the output, computed as it is written, as gzip and many other
+// containers record it. The CRC covers exactly the n bytes written to w,
+// so on error it is that of the output written so far.
+func DecompressToWriterCRC(src []byte, w io.Writer) (n int, crc uint32, err error) {
+	h := crc32.NewIEEE()
+	n, err = DecompressTo(io.MultiWriter(w, h), src)
+	return n, h.Sum32(), err
+}
+
+// DefaultWindowSize is the size of the buffer DecompressTo starts with,
+// and a good size for DecompressToWindow.
+const DefaultWindowSize = maxOffset + sinkChunk
+
+// DecompressToWindow is DecompressTo decoding in the caller's window
+// buffer instead of allocating one, so repeated calls do not allocate.
+//
+// The window holds the last maxOffset (49151) bytes of output for
+// lookbehind plus room to decode ahead; DefaultWindowSize leaves 64 KiB
+// for that. Literal runs and M3 and M4 matches are decoded in pieces when
+// they do not fit, but the short matches and literal runs of up to 8
+// bytes are not, so a window of at least maxOffset+8 bytes decodes any
+// stream. A window of at most maxOffset bytes fails with ErrWindowTooSmall
+// before decoding; a smaller one than maxOffset+8 may fail with it,
+// wrapped in a DecodeError, at the first short opcode that does not fit.
+func DecompressToWindow(w io.Writer, src, window []byte) (int, error) {
+	if len(window) <= maxOffset {
+		return 0, ErrWindowTooSmall
+	}
+	return decompressTo(w, src, window, nil, maxOffset, false)
+}
+
+// DecompressCallback decompresses src and passes the output to emit as it
+// is decoded, in chunks of up to 64 KiB, whose concatenation is the output
+// of Decompress. Long literal runs and matches are split across chunks,
+// so memory stays around 224 KiB, two DefaultWindowSize buffers, however
+// large the output is. It otherwise matches DecompressTo: an error from emit
+// stops decoding and is returned as is, and decoding errors are reported
+// after emitting the output of every opcode before the failing one.
+//
+// A chunk is a view of the decoder's window: emit must not modify it, and
+// it stays valid until the next call of emit, so emit may hand it to
+// another goroutine that is done with it by then. Empty chunks are never
+// emitted.
+func DecompressCallback(src []byte, emit func(chunk []byte) error) error {
+	_, err := decompressTo(emitWriter(emit), src, make([]byte, DefaultWindowSize),
+		make([]byte, DefaultWindowSize), maxOffset, true)
+	return err
+}
+
+// emitWriter adapts the callback of DecompressCallback to io.Writer.
+type emitWriter func(chunk []byte) error
+
+func (f emitWriter) Write(p []byte) (int, error) {
+	if err := f(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// decompressTo implements DecompressTo in buf, keeping keep bytes of
+// lookbehind and decoding into the rest. Long tokens are split to fit,
+// and a short one that does not fit grows buf if grow is set and fails
+// with ErrWindowTooSmall otherwise. Streams whose matches reach further
+// back than keep fail with ErrLookbehindOverrun.
+//
+// With a spare buffer, the lookbehind is moved into spare after each flush
+// and the two are swapped, so the bytes passed to w stay untouched until
+// the next Write.
+func decompressTo(w io.Writer, src, buf, spare []byte, keep int, grow bool) (int, error) {
+	var st decodeState
+	ip := 0      // input position of the token st describes
+	written := 0 // output bytes flushed to w
+
+	// flush writes buf[:n] in writes of at most sinkChunk bytes
+	flush := func(n int) error {
+		for p := buf[:n]; len(p) > 0; {
+			k := min(len(p), sinkChunk)
+			if _, err := w.Write(p[:k]); err != nil {
+				return err
+			}
+			written += k
+			p = p[k:]
+		}
+		return nil
+	}
+
+	for {
+		op, n, tokIP, tok, err := decodeFrom(src[ip:], buf, decodeConfig{split: true}, st)
+		switch err {
+		case nil:
+			if err := flush(op); err != nil {
+				return written, err
+			}
+			if ip+n < len(src) {
+				return written, errNotConsumed(ip+n, written)
+			}
+			return written, nil
+
+		case ErrOutputOverrun:
+			// Flush everything the window no longer needs and retry the
+			// token that did not fit
+			if drop := tok.op - min(keep, tok.op); drop > 0 {
+				if err := flush(drop); err != nil {
+					return written, err
+				}
+				if spare != nil {
+					if len(spare) < len(buf) {
+						spare = make([]byte, len(buf))
+					}
+					copy(spare, buf[drop:tok.op])
+					buf, spare = spare, buf
+				} else {
+					copy(buf, buf[drop:tok.op])
+				}
+				tok.op -= drop
+			} else if grow {
+				buf = append(buf, make([]byte, len(buf))...)
+			} else {
+				if err := flush(tok.op); err != nil {
+					return written, err
+				}
+				return written, &DecodeError{Err: ErrWindowTooSmall, InputPos: ip + tokIP, OutputPos: written}
+			}
+			st = tok
+			ip += tokIP
+
+		default:
+			if err == errMissingEOF {
+				err = ErrInputOverrun
+			}
+			if ferr := flush(tok.op); ferr != nil {
+				return written, ferr
+			}
+			return written, &DecodeError{Err: err, InputPos: ip + tokIP, OutputPos: written}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func TestDecompressTo(t *testing.T) {
+	inputs := [][]byte{{}, []byte("hello"), parallelInput()}
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+
+	for i, input := range inputs {
+		compressed := MustCompress(input, nil)
+		var buf bytes.Buffer
+		n, err := DecompressTo(&buf, compressed)
+		if err != nil || n != len(input) {
+			t.Fatalf("input %d: DecompressTo = (%d, %v), want (%d, nil)", i, n, err, len(input))
+		}
+		if !bytes.Equal(buf.Bytes(), input) {
+			t.Errorf("input %d: output mismatch", i)
+		}
+	}
+}
+
+func TestDecompressToSmallWindow(t *testing.T) {
+	// Random 20-byte blocks, each repeated, so every match reaches at most
+	// 20 bytes back and crosses flush boundaries of a tiny window
+	rng := rand.New(rand.NewSource(1))
+	var input []byte
+	for len(input) < 20000 {
+		block := make([]byte, 20)
+		rng.Read(block)
+		input = append(input, block...)
+		input = append(input, block...)
+	}
+	// A long run makes a single match larger than the buffer
+	input = append(input, bytes.Repeat([]byte("z"), 5000)...)
+	compressed := MustCompress(input, nil)
+
+	for _, chunk := range []int{1, 7, 100} {
+		var buf bytes.Buffer
+		n, err := decompressTo(&buf, compressed, make([]byte, 64+chunk), nil, 64, true)
+		if err != nil || n != len(input) {
+			t.Fatalf("chunk %d: decompressTo = (%d, %v), want (%d, nil)", chunk, n, err, len(input))
+		}
+		if !bytes.Equal(buf.Bytes(), input) {
+			t.Errorf("chunk %d: output mismatch", chunk)
+		}
+	}
+}
+
+// sumWriter checksums what is written to it and records the longest write.
+type sumWriter struct {
+	crc      uint32
+	n        int
+	maxWrite int
+}
+
+func (w *sumWriter) Write(p []byte) (int, error) {
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, p)
+	w.n += len(p)
+	w.maxWrite = max(w.maxWrite, len(p))
+	return len(p), nil
+}
+
+func TestDecompressToLongTokens(t *testing.T) {
+	// Zeros compress to one long match after another, and noise to a
+	// single literal run; both are decoded in pieces within the window
+	noise := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	for _, input := range [][]byte{make([]byte, 10<<20), noise} {
+		compressed := MustCompress(input, nil)
+		var w sumWriter
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		n, err := DecompressTo(&w, compressed)
+		runtime.ReadMemStats(&after)
+		if err != nil || n != len(input) || w.n != len(input) || w.crc != crc32.ChecksumIEEE(input) {
+			t.Fatalf("%d bytes: DecompressTo = (%d, %v), wrote %d bytes", len(input), n, err, w.n)
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 2*DefaultWindowSize {
+			t.Errorf("%d bytes: allocated %d bytes, want at most %d", len(input), alloc, 2*DefaultWindowSize)
+		}
+		if w.maxWrite > DefaultWindowSize {
+			t.Errorf("%d bytes: wrote %d bytes at once, more than the window", len(input), w.maxWrite)
+		}
+	}
+}
+
+func TestDecompressToErrors(t *testing.T) {
+	input := parallelInput()
+	compressed := MustCompress(input, nil)
+
+	// A decode error writes the output of the opcodes before it
+	var buf bytes.Buffer
+	src := []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}
+	n, err := DecompressTo(&buf, src)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrLookbehindOverrun || de.InputPos != 5 || de.OutputPos != 4 {
+		t.Errorf("expected ErrLookbehindOverrun at input 5 output 4, got %v", err)
+	}
+	if n != 4 || buf.String() != "ABCD" {
+		t.Errorf("got (%d, %q), want (4, \"ABCD\")", n, buf.String())
+	}
+
+	if _, err := DecompressTo(&buf, compressed[:len(compressed)-1]); !errors.Is(err, ErrInputOverrun) {
+		t.Errorf("truncated: expected ErrInputOverrun, got %v", err)
+	}
+	if _, err := DecompressTo(&buf, append(compressed, 0)); !errors.Is(err, ErrInputNotConsumed) {
+		t.Errorf("trailing: expected ErrInputNotConsumed, got %v", err)
+	}
+
+	// Writer errors are returned as is
+	errBoom := errors.New("boom")
+	if n, err := DecompressTo(failWriter{errBoom}, compressed); err != errBoom || n != 0 {
+		t.Errorf("failing writer: got (%d, %v), want (0, errBoom)", n, err)
+	}
+}
+
+func TestDecompressToWriterCRC(t *testing.T) {
+	long := append(make([]byte, 1<<20), parallelInput()...)
+	inputs := [][]byte{{}, []byte("hello"), parallelInput(), long}
+	for i, input := range inputs {
+		var buf bytes.Buffer
+		n, crc, err := DecompressToWriterCRC(MustCompress(input, nil), &buf)
+		if err != nil || n != len(input) || !bytes.Equal(buf.Bytes(), input) {
+			t.Fatalf("input %d: DecompressToWriterCRC = (%d, %v), want (%d, nil)", i, n, err, len(input))
+		}
+		if want := crc32.ChecksumIEEE(input); crc != want {
+			t.Errorf("input %d: CRC %08x, want %08x", i, crc, want)
+		}
+	}
+
+	// On a decode error the CRC covers the output written
+	src := []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}
+	n, crc, err := DecompressToWriterCRC(src, io.Discard)
+	if !errors.Is(err, ErrLookbehindOverrun) || n != 4 || crc != crc32.ChecksumIEEE([]byte("ABCD")) {
+		t.Errorf("decode error: got (%d, %08x, %v)", n, crc, err)
+	}
+}
+
+func TestDecompressCallback(t *testing.T) {
+	corpus := deterministicCorpus()
+	inputs := [][]byte{{}, []byte("hello"), parallelInput(), corpus[3]}
+	// A run decoded as matches longer than the window
+	inputs = append(inputs, append(append([]byte{}, corpus[3][:100000]...), make([]byte, 300000)...))
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+
+	for i, input := range inputs {
+		var got, prev, prevCopy []byte
+		err := DecompressCallback(MustCompress(input, nil), func(chunk []byte) error {
+			if len(chunk) == 0 {
+				t.Errorf("input %d: empty chunk", i)
+			}
+			// The previous chunk is still intact
+			if !bytes.Equal(prev, prevCopy) {
+				t.Errorf("input %d: chunk at %d changed before the next callback", i, len(got)-len(prev))
+			}
+			got = append(got, chunk...)
+			prev, prevCopy = chunk, append(prevCopy[:0], chunk...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("input %d: DecompressCallback failed: %v", i, err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("input %d: concatenated chunks differ from the input", i)
+		}
+	}
+}
+
+func TestDecompressCallbackLongTokens(t *testing.T) {
+	noise := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	for _, input := range [][]byte{make([]byte, 10<<20), noise} {
+		compressed := MustCompress(input, nil)
+		var w sumWriter
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := DecompressCallback(compressed, func(chunk []byte) error {
+			_, err := w.Write(chunk)
+			return err
+		})
+		runtime.ReadMemStats(&after)
+		if err != nil || w.n != len(input) || w.crc != crc32.ChecksumIEEE(input) {
+			t.Fatalf("%d bytes: DecompressCallback = %v after %d bytes", len(input), err, w.n)
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 3*DefaultWindowSize {
+			t.Errorf("%d bytes: allocated %d bytes, want at most %d", len(input), alloc, 3*DefaultWindowSize)
+		}
+		if w.maxWrite > 64<<10 {
+			t.Errorf("%d bytes: emitted a chunk of %d bytes, more than 64 KiB", len(input), w.maxWrite)
+		}
+	}
+}
+
+func TestDecompressCallbackErrors(t *testing.T) {
+	// A decode error comes after the output of the opcodes before it
+	var got []byte
+	src := []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}
+	err := DecompressCallback(src, func(chunk []byte) error {
+		got = append(got, chunk...)
+		return nil
+	})
+	if !errors.Is(err, ErrLookbehindOverrun) || string(got) != "ABCD" {
+		t.Errorf("got (%q, %v), want (\"ABCD\", ErrLookbehindOverrun)", got, err)
+	}
+
+	// Callback errors stop decoding and are returned as is
+	errStop := errors.New("stop")
+	calls := 0
+	err = DecompressCallback(MustCompress(parallelInput(), nil), func([]byte) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("failing callback: got %v after %d calls, want errStop after 1", err, calls)
+	}
+}
+
+func TestDecompressToWindow(t *testing.T) {
+	// maxOffset literals, then a match at offset 1: the window holds
+	// maxOffset bytes of lookbehind, and a long match is decoded through
+	// whatever room is left while a short one must fit whole
+	hist := make([]byte, maxOffset)
+	rand.New(rand.NewSource(1)).Read(hist)
+	stream := func(length int) (src []byte, matchIP int) {
+		src = make([]byte, MaxCompressedSize(len(hist))+16)
+		n, err := emitLiterals(hist, src, true)
+		if err != nil {
+			t.Fatalf("emitLiterals failed: %v", err)
+		}
+		m, err := emitMatch(src[n:], 1, length)
+		if err != nil {
+			t.Fatalf("emitMatch failed: %v", err)
+		}
+		return append(src[:n+m], 0x11, 0x00, 0x00), n
+	}
+
+	for _, tc := range []struct{ length, window int }{
+		{500, maxOffset + 500},
+		{500, maxOffset + 8},
+		{500, maxOffset + 1},
+		{4, maxOffset + 4},
+	} {
+		src, _ := stream(tc.length)
+		want := append(append([]byte{}, hist...), bytes.Repeat(hist[len(hist)-1:], tc.length)...)
+		var buf bytes.Buffer
+		n, err := DecompressToWindow(&buf, src, make([]byte, tc.window))
+		if err != nil || n != len(want) || !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%d-byte match, window maxOffset+%d: got (%d, %v), want (%d, nil)",
+				tc.length, tc.window-maxOffset, n, err, len(want))
+		}
+	}
+
+	// A 4-byte M2 match is not split
+	src, matchIP := stream(4)
+	var buf bytes.Buffer
+	n, err := DecompressToWindow(&buf, src, make([]byte, maxOffset+3))
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Err != ErrWindowTooSmall || de.InputPos != matchIP || de.OutputPos != maxOffset {
+		t.Errorf("undersized window: expected ErrWindowTooSmall at input %d output %d, got %v", matchIP, maxOffset, err)
+	}
+	if n != maxOffset || !bytes.Equal(buf.Bytes(), hist) {
+		t.Errorf("undersized window: wrote %d bytes, want the %d literals", n, maxOffset)
+	}
+
+	if n, err := DecompressToWindow(&buf, src, make([]byte, maxOffset)); n != 0 || !errors.Is(err, ErrWindowTooSmall) {
+		t.Errorf("window without room: got (%d, %v), want (0, ErrWindowTooSmall)", n, err)
+	}
+}
+
+func TestDecompressToWindowZeroAllocs(t *testing.T) {
+	compressed := MustCompress(parallelInput(), nil)
+	window := make([]byte, DefaultWindowSize)
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, err := DecompressToWindow(io.Discard, compressed, window); err != nil {
+			t.Fatalf("DecompressToWindow failed: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("DecompressToWindow allocated %.0f times per call, want 0", allocs)
+	}
+}
@@ -0,0 +1,47 @@
+package lzo1z
+
+// DecompressedSize returns the exact number of bytes Decompress would
+// produce for src, so dst can be allocated exactly. It runs the decoder
+// without writing any output, adding up literal and match lengths, which
+// is considerably cheaper than decoding into a throwaway buffer.
+//
+// It applies the same input, lookbehind and trailing-data checks as
+// Decompress and returns the same errors, so a nil error means Decompress
+// into a buffer of the returned size succeeds.
+func DecompressedSize(src []byte) (int, error) {
+	op, ip, err := decodeStream(src, nil, decodeConfig{walk: true, guard: true})
+	if err == errMissingEOF {
+		err = ErrInputOverrun
+	}
+	if err == nil && ip < len(src) {
+		err = ErrInputNotConsumed
+	}
+	return op, err
+}
+
+// Verify checks that compressed is a complete, valid LZO1Z stream and
+// returns the length it decompresses to, without needing an output buffer.
+//
+// Matches can only fail by reaching before the start of the output, which
+// DecompressedSize checks without writing any, so no scratch window
+// is needed: Verify succeeds exactly when Decompress into a large enough
+// buffer would.
+func Verify(compressed []byte) (int, error) {
+	return DecompressedSize(compressed)
+}
+
+// DecompressAlloc decompresses src into a newly allocated slice of exactly
+// the decompressed length, for callers that do not track the size. It
+// runs DecompressedSize first, so an invalid stream is
+// rejected with that function's errors before anything is allocated.
+func DecompressAlloc(src []byte) ([]byte, error) {
+	n, err := DecompressedSize(src)
+	if err != nil {
+		return nil, err
+	}
+	dst := make([]byte, n)
+	if _, err := Decompress(src, dst); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
@@ -0,0 +1,101 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecompressedSize(t *testing.T) {
+	for _, tc := range interopTestCases {
+		n, err := DecompressedSize(tc.compressed)
+		if err != nil {
+			t.Errorf("%s: DecompressedSize failed: %v", tc.name, err)
+			continue
+		}
+		if n != tc.inputLen {
+			t.Errorf("%s: DecompressedSize = %d, want %d", tc.name, n, tc.inputLen)
+		}
+	}
+	for _, tc := range testCases {
+		n, err := DecompressedSize(tc.compressed)
+		if err != nil || n != tc.inputLen {
+			t.Errorf("%s: DecompressedSize = (%d, %v), want (%d, nil)", tc.name, n, err, tc.inputLen)
+		}
+	}
+
+	// Exact sizing: a dst of the returned length is enough
+	input := bytes.Repeat([]byte("exact size "), 100)
+	compressed := MustCompress(input, nil)
+	n, err := DecompressedSize(compressed)
+	if err != nil || n != len(input) {
+		t.Fatalf("DecompressedSize = (%d, %v), want (%d, nil)", n, err, len(input))
+	}
+	dst := make([]byte, n)
+	if m, err := Decompress(compressed, dst); err != nil || m != n {
+		t.Errorf("Decompress into exact buffer = (%d, %v)", m, err)
+	}
+}
+
+func TestDecompressedSizeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"empty", []byte{}, nil},
+		{"truncated", []byte{0x15, 0x41, 0x42}, ErrInputOverrun},
+		{"missing_eof", []byte{0x15, 0x41, 0x42, 0x43, 0x44}, ErrInputOverrun},
+		{"lookbehind", []byte{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00}, ErrLookbehindOverrun},
+		{"trailing_garbage", []byte{0x12, 0x41, 0x11, 0x00, 0x00, 0x00}, ErrInputNotConsumed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecompressedSize(tc.src)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+			if _, derr := Decompress(tc.src, make([]byte, 64)); !errors.Is(derr, err) {
+				t.Errorf("Decompress returned %v, DecompressedSize %v", derr, err)
+			}
+		})
+	}
+}
+
+func TestDecompressAlloc(t *testing.T) {
+	for _, tc := range append(interopTestCases, testCases...) {
+		out, err := DecompressAlloc(tc.compressed)
+		if err != nil {
+			t.Errorf("%s: DecompressAlloc failed: %v", tc.name, err)
+			continue
+		}
+		if len(out) != tc.inputLen || cap(out) != tc.inputLen || !bytes.Equal(out, tc.input) {
+			t.Errorf("%s: got len %d cap %d, want exactly %d matching bytes", tc.name, len(out), cap(out), tc.inputLen)
+		}
+	}
+
+	for _, src := range [][]byte{
+		{0x15, 0x41, 0x42},
+		{0x15, 0x41, 0x42, 0x43, 0x44, 0x21, 0xff, 0xff, 0x11, 0x00, 0x00},
+		{0x12, 0x41, 0x11, 0x00, 0x00, 0x00},
+	} {
+		_, want := DecompressedSize(src)
+		if out, err := DecompressAlloc(src); out != nil || err != want {
+			t.Errorf("% x: got (%v, %v), want (nil, %v)", src, out, err, want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, tc := range interopTestCases {
+		if n, err := Verify(tc.compressed); err != nil || n != tc.inputLen {
+			t.Errorf("%s: Verify = (%d, %v), want (%d, nil)", tc.name, n, err, tc.inputLen)
+		}
+	}
+	for _, tc := range testCases {
+		if n, err := Verify(tc.compressed); err != nil || n != tc.inputLen {
+			t.Errorf("%s: Verify = (%d, %v), want (%d, nil)", tc.name, n, err, tc.inputLen)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package lzo1z
+
+import (
+	"io"
+	"slices"
+)
+
+// sourceChunk is how many bytes CompressFrom reads ahead of its window
+// before searching them for matches.
+const sourceChunk = 64 << 10
+
+// CompressFrom compresses everything read from r into dst as a single
+// LZO1Z stream, returning the number of bytes written to dst.
+//
+// The input is read in chunks, keeping only the last maxOffset bytes
+// (48 KiB), the furthest back a match can reach, plus the chunk being
+// compressed. Pending literals are kept as well, so long incompressible
+// stretches grow the buffer until the next match. dst must be large
+// enough for the output, e.g. MaxCompressedSize of the input length.
+//
+// The output depends only on the data read, not on how r splits it
+// across Read calls, and equals Compress for inputs of up to 64 KiB.
+// Longer inputs can compress slightly differently, as matches do not
+// extend across the end of a chunk.
+func CompressFrom(r io.Reader, dst []byte) (int, error) {
+	return compressFrom(r, dst, sourceChunk)
+}
+
+// compressFrom implements CompressFrom, reading chunk bytes at a time.
+func compressFrom(r io.Reader, dst []byte, chunk int) (int, error) {
+	var hashTable [hashSize]int
+	base := 1
+	var s compressState
+	var buf []byte
+	scanned := false
+
+	for {
+		// Always fill whole chunks, so the output is independent of how
+		// r splits its data
+		n := len(buf)
+		buf = slices.Grow(buf, chunk)[:n+chunk]
+		m, err := io.ReadFull(r, buf[n:])
+		buf = buf[:n+m]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return s.op, err
+		}
+
+		if err := compressScan(buf, dst, hashTable[:], base, compressConfig{}, &s, len(buf)-minMatch); err != nil {
+			return s.op, err
+		}
+		scanned = true
+
+		// Drop input that is neither pending nor within match reach;
+		// raising base by the same amount keeps hash table entries valid
+		if drop := min(s.litStart, s.ip-maxOffset); drop > 0 {
+			buf = buf[:copy(buf, buf[drop:])]
+			s.ip -= drop
+			s.litStart -= drop
+			base += drop
+		}
+	}
+
+	// Input that fit in one chunk is compressed exactly like Compress
+	if !scanned {
+		return compressBlock(buf, dst, hashTable[:hashTableLen(len(buf))], base, compressConfig{})
+	}
+	if err := compressScan(buf, dst, hashTable[:], base, compressConfig{}, &s, len(buf)-minMatch); err != nil {
+		return s.op, err
+	}
+	return compressFinish(buf, dst, &s, nil)
+}
@@ -0,0 +1,75 @@
+package lzo1z
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCompressFromReadSizes(t *testing.T) {
+	random := make([]byte, 30000)
+	rand.New(rand.NewSource(1)).Read(random)
+	input := append(parallelInput(), random...)
+	input = append(input, parallelInput()[:50000]...)
+
+	readers := map[string]func() io.Reader{
+		"one_read":  func() io.Reader { return bytes.NewReader(input) },
+		"one_byte":  func() io.Reader { return iotest.OneByteReader(bytes.NewReader(input)) },
+		"half_read": func() io.Reader { return iotest.HalfReader(bytes.NewReader(input)) },
+		"data_err":  func() io.Reader { return iotest.DataErrReader(bytes.NewReader(input)) },
+	}
+
+	for _, chunk := range []int{100, 4096, sourceChunk} {
+		var want []byte
+		for name, reader := range readers {
+			dst := make([]byte, MaxCompressedSize(len(input)))
+			n, err := compressFrom(reader(), dst, chunk)
+			if err != nil {
+				t.Fatalf("chunk %d %s: compressFrom failed: %v", chunk, name, err)
+			}
+			if want == nil {
+				want = dst[:n]
+			} else if !bytes.Equal(dst[:n], want) {
+				t.Errorf("chunk %d %s: output differs", chunk, name)
+			}
+
+			out := make([]byte, len(input))
+			if m, err := Decompress(dst[:n], out); err != nil || !bytes.Equal(out[:m], input) {
+				t.Errorf("chunk %d %s: roundtrip failed: %v", chunk, name, err)
+			}
+		}
+	}
+}
+
+func TestCompressFromMatchesCompress(t *testing.T) {
+	inputs := [][]byte{{}, []byte("ab"), []byte("hello hello hello"), parallelInput()[:sourceChunk]}
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+
+	for i, input := range inputs {
+		want := MustCompress(input, nil)
+		dst := make([]byte, MaxCompressedSize(len(input)))
+		n, err := CompressFrom(iotest.OneByteReader(bytes.NewReader(input)), dst)
+		if err != nil || !bytes.Equal(dst[:n], want) {
+			t.Errorf("input %d: CompressFrom differs from Compress (err %v)", i, err)
+		}
+	}
+}
+
+func TestCompressFromErrors(t *testing.T) {
+	input := parallelInput()
+
+	errBoom := errors.New("boom")
+	r := io.MultiReader(bytes.NewReader(input), iotest.ErrReader(errBoom))
+	if _, err := CompressFrom(r, make([]byte, MaxCompressedSize(len(input)))); err != errBoom {
+		t.Errorf("read error: expected errBoom, got %v", err)
+	}
+
+	if _, err := CompressFrom(bytes.NewReader(input), make([]byte, 100)); !errors.Is(err, ErrOutputOverrun) {
+		t.Errorf("small dst: expected ErrOutputOverrun, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package lzo1z
+
+// Stats describes the opcodes a compression run emitted.
+type Stats struct {
+	M1, M2, M3, M4 int // matches emitted of each kind
+
+	LiteralRuns  int // literal runs emitted
+	LiteralBytes int // input bytes stored as literals
+	MatchBytes   int // input bytes covered by matches
+
+	// MatchLengths counts matches by length
+	MatchLengths map[int]int
+}
+
+// CompressStats is Compress that also reports what it emitted, to help
+// understand why some data compresses poorly. The output is identical to
+// Compress; LiteralBytes+MatchBytes always equals len(src).
+//
+// Gathering the statistics costs a little speed, so Compress itself does
+// not collect them.
+func CompressStats(src, dst []byte) (int, Stats, error) {
+	st := Stats{MatchLengths: make(map[int]int)}
+	n, err := compressNew(src, dst, compressConfig{stats: &st})
+	return n, st, err
+}
+
+// addLiterals records a literal run of n bytes.
+func (s *Stats) addLiterals(n int) {
+	s.LiteralRuns++
+	s.LiteralBytes += n
+}
+
+// addMatch records a match; the kind follows the branches of emitMatch,
+// keep the two in sync.
+func (s *Stats) addMatch(offset, length int) {
+	switch {
+	case length == 2:
+		s.M1++
+	case length <= 4 && offset <= 0x700:
+		s.M2++
+	case offset <= 0x4000:
+		s.M3++
+	default:
+		s.M4++
+	}
+	s.MatchBytes += length
+	s.MatchLengths[length]++
+}
@@ -0,0 +1,59 @@
+package lzo1z
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressStats(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	inputs := [][]byte{
+		{},
+		[]byte("ab"),
+		[]byte("hello hello hello"),
+		bytes.Repeat([]byte("A"), 100000),
+		random,
+		parallelInput(),
+	}
+	for _, tc := range interopTestCases {
+		inputs = append(inputs, tc.input)
+	}
+
+	for i, input := range inputs {
+		want := make([]byte, MaxCompressedSize(len(input)))
+		wn, err := Compress(input, want)
+		if err != nil {
+			t.Fatalf("input %d: Compress failed: %v", i, err)
+		}
+
+		dst := make([]byte, MaxCompressedSize(len(input)))
+		n, st, err := CompressStats(input, dst)
+		if err != nil {
+			t.Fatalf("input %d: CompressStats failed: %v", i, err)
+		}
+		if !bytes.Equal(dst[:n], want[:wn]) {
+			t.Errorf("input %d: output differs from Compress", i)
+		}
+
+		if st.LiteralBytes+st.MatchBytes != len(input) {
+			t.Errorf("input %d: %d literal + %d match bytes, want %d",
+				i, st.LiteralBytes, st.MatchBytes, len(input))
+		}
+
+		matches, matchBytes := 0, 0
+		for length, count := range st.MatchLengths {
+			matches += count
+			matchBytes += length * count
+		}
+		if matches != st.M1+st.M2+st.M3+st.M4 || matchBytes != st.MatchBytes {
+			t.Errorf("input %d: histogram has %d matches covering %d bytes, stats %+v",
+				i, matches, matchBytes, st)
+		}
+		if st.LiteralRuns > st.LiteralBytes {
+			t.Errorf("input %d: %d literal runs for %d literal bytes", i, st.LiteralRuns, st.LiteralBytes)
+		}
+	}
+}
@@ -8,11 +8,18 @@
  *
  * Output: Go source code for testdata_interop_test.go
  *
+ * With the "lzo1x" argument it instead compresses the inputs built by
+ * lzo1xMixedInput and lzo1xFarInput in lzo1x_test.go with
+ * lzo1x_999_compress and prints the lzo1xMixedCompressed and
+ * lzo1xFarCompressed declarations found there.
+ *
  * Build: gcc -o gen_vectors gen_vectors.c -llzo2
  * Run:   ./gen_vectors > ../interop_vectors.go
+ *        ./gen_vectors lzo1x
  */
 
 #include <lzo/lzoconf.h>
+#include <lzo/lzo1x.h>
 #include <lzo/lzo1z.h>
 #include <stdio.h>
 #include <stdlib.h>
@@ -369,10 +376,93 @@ static void gen_eof_patterns(void) {
     emit_vector("tiny_6_with_match", p, 6);
 }
 
-int main(int argc, char *argv[]) {
+/* LZO1X vectors for lzo1x_test.go */
+
+static const char *lzo1x_text = "The quick brown fox jumps over the lazy dog. ";
+
+static lzo_uint put_str(lzo_bytep p, const char *s) {
+    lzo_uint n = strlen(s);
+    memcpy(p, s, n);
+    return n;
+}
+
+/* Same bytes as lzo1xMixedInput in lzo1x_test.go */
+static lzo_uint lzo1x_mixed_input(lzo_bytep p) {
+    lzo_uint n = 0;
+    int i;
+
+    for (i = 0; i < 40; i++) {
+        p[n++] = 'a';
+        p[n++] = 'b';
+        p[n++] = (lzo_byte)('a' + i % 7);
+        p[n++] = 'x';
+        p[n++] = 'y';
+        p[n++] = (lzo_byte)('A' + i % 5);
+    }
+    for (i = 0; i < 4; i++) n += put_str(p + n, lzo1x_text);
+    for (i = 0; i < 60; i++) {
+        p[n++] = (lzo_byte)(i * 7 % 251);
+        if (i % 3 == 0) n += put_str(p + n, "zq");
+    }
+    for (i = 0; i < 2; i++) n += put_str(p + n, lzo1x_text);
+    return n;
+}
+
+/* Same bytes as lzo1xFarInput in lzo1x_test.go */
+static lzo_uint lzo1x_far_input(lzo_bytep p) {
+    static const char *head = "FAR-MATCH-HEADER:0123456789abcdefghijklmnopqrstuv";
+    lzo_byte block[200];
+    lzo_uint32_t x = 1;
+    lzo_uint n = 0;
+    int i;
+
+    n += put_str(p + n, head);
+    for (i = 0; i < 200; i++) {
+        x = x * 1103515245u + 12345u;
+        block[i] = (lzo_byte)(x >> 16);
+    }
+    while (n < 17000) {
+        memcpy(p + n, block, sizeof block);
+        n += sizeof block;
+    }
+    n += put_str(p + n, head);
+    return n;
+}
+
+static int emit_lzo1x(const char *name, const lzo_bytep input, lzo_uint in_len) {
+    lzo_uint out_len = MAX_OUTPUT;
+    lzo_uint i;
     int r;
 
-    (void)argc; (void)argv;
+    r = lzo1x_999_compress(input, in_len, out_buf, &out_len, wrkmem);
+    if (r != LZO_E_OK) {
+        fprintf(stderr, "compression failed for %s: %d\n", name, r);
+        return -1;
+    }
+
+    printf("\t%s = []byte{", name);
+    for (i = 0; i < out_len; i++) {
+        if (i % 16 == 0) printf("\n\t\t");
+        else printf(" ");
+        printf("0x%02x,", out_buf[i]);
+    }
+    printf("\n\t}\n");
+    return 0;
+}
+
+static int gen_lzo1x(void) {
+    printf("// liblzo2 %s\n", lzo_version_string());
+    printf("var (\n");
+    if (emit_lzo1x("lzo1xMixedCompressed", in_buf, lzo1x_mixed_input(in_buf)) != 0)
+        return 1;
+    if (emit_lzo1x("lzo1xFarCompressed", in_buf, lzo1x_far_input(in_buf)) != 0)
+        return 1;
+    printf(")\n");
+    return 0;
+}
+
+int main(int argc, char *argv[]) {
+    int r;
 
     if (lzo_init() != LZO_E_OK) {
         fprintf(stderr, "lzo_init() failed\n");
@@ -381,12 +471,21 @@ int main(int argc, char *argv[]) {
 
     in_buf = (lzo_bytep) malloc(MAX_INPUT);
     out_buf = (lzo_bytep) malloc(MAX_OUTPUT);
-    wrkmem = (lzo_bytep) malloc(LZO1Z_999_MEM_COMPRESS);
+    wrkmem = (lzo_bytep) malloc(LZO1Z_999_MEM_COMPRESS > LZO1X_999_MEM_COMPRESS
+        ? LZO1Z_999_MEM_COMPRESS : LZO1X_999_MEM_COMPRESS);
     if (!in_buf || !out_buf || !wrkmem) {
         fprintf(stderr, "out of memory\n");
         return 1;
     }
 
+    if (argc > 1 && strcmp(argv[1], "lzo1x") == 0) {
+        r = gen_lzo1x();
+        free(in_buf);
+        free(out_buf);
+        free(wrkmem);
+        return r;
+    }
+
     printf("package lzo1z\n\n");
     printf("// Code generated by testdata/gen/gen_vectors.c using liblzo2. DO NOT EDIT.\n");
     printf("// Regenerate: cd testdata/gen && docker build -t lzo1z-gen . && docker run --rm lzo1z-gen > ../../interop_vectors_test.go\n\n");
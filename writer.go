@@ -0,0 +1,276 @@
+package lzo1z
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+)
+
+// DefaultBlockSize is the block size used by NewWriter.
+const DefaultBlockSize = 64 << 10
+
+// blockHeaderLen is the size of the header preceding every block in the
+// stream format produced by Writer:
+//
+//	uint32 big-endian: decompressed length of the block
+//	uint32 big-endian: compressed length of the block
+//
+// followed by the compressed block, a complete LZO1Z stream. A header with
+// both lengths zero terminates the stream.
+const blockHeaderLen = 8
+
+// checksumHeaderLen is the size of the block header written by
+// NewWriterChecksum, which appends to the lengths
+//
+//	uint32 big-endian: CRC-32 (IEEE) of the decompressed block
+//
+// The terminator is a header of that size with every field zero.
+const checksumHeaderLen = blockHeaderLen + 4
+
+// ErrClosed is returned when writing to a Writer after Close.
+var ErrClosed = errors.New("lzo1z: write to closed Writer")
+
+// Writer is an io.WriteCloser that compresses data written to it in
+// independent blocks, each framed with a length-prefixed header so a
+// Reader can recover block boundaries.
+//
+// Each block is compressed on its own: matches never reach into a previous
+// block, so blocks can be decoded independently.
+//
+// An error from the underlying writer is sticky: every later call returns
+// it until Reset. The block being written when it failed stays buffered
+// and uncounted, so Committed reports exactly the input a Reader recovers
+// from what reached the writer, the blocks before the torn one, after
+// which it reports ErrInputOverrun. To resume on another writer, Reset
+// onto it and write the input again from Committed.
+type Writer struct {
+	w      io.Writer
+	c      *Compressor // reused across blocks, so small flushed blocks stay cheap
+	buf    []byte      // pending uncompressed bytes, len < block size
+	out    []byte      // header + compressed block scratch
+	err    error       // sticky error from the underlying writer
+	closed bool
+	done   int64 // input bytes in blocks written in full, see Committed
+
+	hdrLen int    // blockHeaderLen, or checksumHeaderLen to write block CRCs
+	dict   []byte // dictionary every block is compressed against, or nil
+	joined []byte // dictionary and block scratch for Compressor.compressDict
+}
+
+// NewWriter returns a Writer that compresses to w in blocks of
+// DefaultBlockSize bytes.
+func NewWriter(w io.Writer) *Writer {
+	return NewWriterSize(w, DefaultBlockSize)
+}
+
+// NewWriterSize returns a Writer that compresses to w in blocks of size
+// bytes. Sizes below 1 use DefaultBlockSize.
+func NewWriterSize(w io.Writer, size int) *Writer {
+	if size < 1 {
+		size = DefaultBlockSize
+	}
+	return &Writer{
+		w:      w,
+		c:      NewCompressor(),
+		buf:    make([]byte, 0, size),
+		out:    make([]byte, checksumHeaderLen+MaxCompressedSize(size)),
+		hdrLen: blockHeaderLen,
+	}
+}
+
+// NewWriterChecksum returns a Writer like NewWriter whose block headers
+// also carry the CRC-32 of each block, for a Reader from NewReaderChecksum
+// to verify. The two stream formats are not interchangeable.
+func NewWriterChecksum(w io.Writer) *Writer {
+	z := NewWriter(w)
+	z.hdrLen = checksumHeaderLen
+	return z
+}
+
+// NewWriterLevel returns a Writer like NewWriter that compresses its blocks
+// at the given level, as CompressLevel does. It returns an error if level
+// is not between 1 and 3.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < 1 || level > 3 {
+		return nil, fmt.Errorf("lzo1z: invalid compression level: %d", level)
+	}
+	z := NewWriter(w)
+	z.c.Lazy = level >= 2
+	if level >= 3 {
+		z.c.SearchDepth = DefaultSearchDepth
+	}
+	return z, nil
+}
+
+// NewWriterDict returns a Writer like NewWriter whose blocks are each
+// compressed with CompressWithDict against dict, so even the first block
+// of a short stream can match it. The stream decodes only with a Reader
+// from NewReaderDict given the same dictionary. dict is copied.
+func NewWriterDict(w io.Writer, dict []byte) *Writer {
+	z := NewWriter(w)
+	z.dict = slices.Clone(dictWindow(dict))
+	return z
+}
+
+// Reset discards any buffered data and error state and makes z write a new
+// stream to w, keeping its block size, level, format, dictionary and
+// buffers. It does not write the terminator of the previous stream; Close
+// it first to finish it.
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.buf = z.buf[:0]
+	z.err = nil
+	z.closed = false
+	z.done = 0
+}
+
+// Committed returns the number of input bytes in blocks written in full to
+// the underlying writer since NewWriter or Reset. After an error it is the
+// length of the prefix a Reader decodes before the torn block.
+func (z *Writer) Committed() int64 {
+	return z.done
+}
+
+// Write buffers p and compresses every block it completes.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, ErrClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(z.buf) == cap(z.buf) {
+			if err := z.writeBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// WriteString is Write for a string, copying s into the block buffer
+// without converting it to a []byte first. It implements io.StringWriter.
+func (z *Writer) WriteString(s string) (int, error) {
+	if z.closed {
+		return 0, ErrClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	// Keep in sync with Write
+	written := 0
+	for len(s) > 0 {
+		n := copy(z.buf[len(z.buf):cap(z.buf)], s)
+		z.buf = z.buf[:len(z.buf)+n]
+		s = s[n:]
+		written += n
+
+		if len(z.buf) == cap(z.buf) {
+			if err := z.writeBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush compresses and writes any buffered data as a block of its own, so
+// a Reader on the other end can read everything written so far without
+// waiting for a full block. Flushing with nothing buffered writes nothing.
+// It does not flush the underlying writer.
+func (z *Writer) Flush() error {
+	if z.closed {
+		return ErrClosed
+	}
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buf) == 0 {
+		return nil
+	}
+	return z.writeBlock()
+}
+
+// Close compresses any buffered data, writes the stream terminator and
+// marks the Writer closed. It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+
+	if len(z.buf) > 0 {
+		if err := z.writeBlock(); err != nil {
+			return err
+		}
+	}
+
+	var end [checksumHeaderLen]byte
+	if _, err := z.w.Write(end[:z.hdrLen]); err != nil {
+		z.err = err
+		return err
+	}
+	return nil
+}
+
+// CompressPull compresses the chunks returned by next, until it returns
+// false, to w as one block stream, as a Writer from NewWriter would. It is
+// the pull-based counterpart of Writer, for generators that produce their
+// input on demand. The chunks need not align with blocks, and each may be
+// reused by next once the following call is made.
+func CompressPull(next func() ([]byte, bool), w io.Writer) error {
+	z := NewWriter(w)
+	for {
+		p, ok := next()
+		if !ok {
+			break
+		}
+		if _, err := z.Write(p); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// writeBlock compresses and writes the buffered block, then empties it.
+func (z *Writer) writeBlock() error {
+	var n int
+	var err error
+	if z.dict != nil {
+		n, err = z.c.compressDict(z.buf, z.out[z.hdrLen:], z.dict, &z.joined)
+	} else {
+		n, err = z.c.Compress(z.buf, z.out[z.hdrLen:])
+	}
+	if err != nil {
+		z.err = err
+		return err
+	}
+	binary.BigEndian.PutUint32(z.out[0:], uint32(len(z.buf)))
+	binary.BigEndian.PutUint32(z.out[4:], uint32(n))
+	if z.hdrLen == checksumHeaderLen {
+		binary.BigEndian.PutUint32(z.out[8:], crc32.ChecksumIEEE(z.buf))
+	}
+
+	if _, err := z.w.Write(z.out[:z.hdrLen+n]); err != nil {
+		z.err = err
+		return err
+	}
+	z.done += int64(len(z.buf))
+	z.buf = z.buf[:0]
+	return nil
+}
@@ -0,0 +1,444 @@
+package lzo1z
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// decodeBlocks parses the Writer block format by hand and returns the
+// concatenated decompressed blocks.
+func decodeBlocks(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var out []byte
+	for {
+		if len(data) < blockHeaderLen {
+			t.Fatalf("truncated block header")
+		}
+		rawLen := int(binary.BigEndian.Uint32(data[0:]))
+		compLen := int(binary.BigEndian.Uint32(data[4:]))
+		data = data[blockHeaderLen:]
+		if rawLen == 0 && compLen == 0 {
+			break
+		}
+		if len(data) < compLen {
+			t.Fatalf("truncated block")
+		}
+
+		block := make([]byte, rawLen)
+		n, err := Decompress(data[:compLen], block)
+		if err != nil {
+			t.Fatalf("Decompress block failed: %v", err)
+		}
+		if n != rawLen {
+			t.Fatalf("block decoded to %d bytes, header says %d", n, rawLen)
+		}
+		out = append(out, block...)
+		data = data[compLen:]
+	}
+	if len(data) != 0 {
+		t.Fatalf("%d bytes after stream terminator", len(data))
+	}
+	return out
+}
+
+func TestWriterSmallWrites(t *testing.T) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 5000)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for i := 0; i < len(input); i += 7 {
+		end := i + 7
+		if end > len(input) {
+			end = len(input)
+		}
+		n, err := w.Write(input[i:end])
+		if err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if n != end-i {
+			t.Fatalf("Write returned %d, want %d", n, end-i)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if got := decodeBlocks(t, buf.Bytes()); !bytes.Equal(got, input) {
+		t.Errorf("roundtrip mismatch: got %d bytes, want %d", len(got), len(input))
+	}
+	if buf.Len() >= len(input)/10 {
+		t.Errorf("compressed to %d bytes, expected < %d", buf.Len(), len(input)/10)
+	}
+}
+
+func TestWriterBlockBoundaries(t *testing.T) {
+	input := make([]byte, 10000)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+
+	for _, size := range []int{1, 3, 100, 4096, 9999, 10000, 10001} {
+		var buf bytes.Buffer
+		w := NewWriterSize(&buf, size)
+		if _, err := w.Write(input); err != nil {
+			t.Fatalf("size %d: Write failed: %v", size, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("size %d: Close failed: %v", size, err)
+		}
+		if got := decodeBlocks(t, buf.Bytes()); !bytes.Equal(got, input) {
+			t.Errorf("size %d: roundtrip mismatch", size)
+		}
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), make([]byte, blockHeaderLen)) {
+		t.Errorf("empty stream = %x, want terminator only", buf.Bytes())
+	}
+}
+
+func TestWriterWriteAfterClose(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := w.Write([]byte("x")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close returned %v", err)
+	}
+}
+
+func TestWriterWriteString(t *testing.T) {
+	var _ io.StringWriter = (*Writer)(nil)
+
+	input := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 500)
+	for _, size := range []int{1, 100, 4096} {
+		var want, got bytes.Buffer
+		zw := NewWriterSize(&want, size)
+		zs := NewWriterSize(&got, size)
+		for i := 0; i < len(input); i += 7 {
+			end := min(i+7, len(input))
+			if _, err := zw.Write([]byte(input[i:end])); err != nil {
+				t.Fatalf("size %d: Write failed: %v", size, err)
+			}
+			n, err := zs.WriteString(input[i:end])
+			if err != nil {
+				t.Fatalf("size %d: WriteString failed: %v", size, err)
+			}
+			if n != end-i {
+				t.Fatalf("size %d: WriteString returned %d, want %d", size, n, end-i)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("size %d: Close failed: %v", size, err)
+		}
+		if err := zs.Close(); err != nil {
+			t.Fatalf("size %d: Close failed: %v", size, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("size %d: WriteString output differs from Write", size)
+		}
+	}
+
+	w := NewWriter(io.Discard)
+	line := "2024-05-01T12:00:00Z INFO request served path=/api/v1/items status=200"
+	if allocs := testing.AllocsPerRun(100, func() { _, _ = w.WriteString(line) }); allocs != 0 {
+		t.Errorf("WriteString allocated %v times per call, want 0", allocs)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := w.WriteString("x"); !errors.Is(err, ErrClosed) {
+		t.Errorf("WriteString after Close: expected ErrClosed, got %v", err)
+	}
+}
+
+type failWriter struct{ err error }
+
+func (f failWriter) Write([]byte) (int, error) { return 0, f.err }
+
+func TestWriterUnderlyingError(t *testing.T) {
+	errBoom := errors.New("boom")
+	w := NewWriterSize(failWriter{errBoom}, 16)
+
+	n, err := w.Write(bytes.Repeat([]byte("a"), 20))
+	if err != errBoom {
+		t.Fatalf("expected errBoom, got %v", err)
+	}
+	if n != 16 {
+		t.Errorf("Write returned %d, want 16", n)
+	}
+	if _, err := w.Write([]byte("a")); err != errBoom {
+		t.Errorf("error not sticky: got %v", err)
+	}
+	if err := w.Close(); err != errBoom {
+		t.Errorf("Close: expected errBoom, got %v", err)
+	}
+}
+
+// failAfterWriter accepts the first n bytes into buf, then fails.
+type failAfterWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (f *failAfterWriter) Write(p []byte) (int, error) {
+	if len(p) > f.n {
+		f.buf.Write(p[:f.n])
+		written := f.n
+		f.n = 0
+		return written, errors.New("link down")
+	}
+	f.n -= len(p)
+	return f.buf.Write(p)
+}
+
+func TestWriterCommitted(t *testing.T) {
+	input := bytes.Repeat([]byte("committed blocks survive a failed write; "), 500)
+
+	// Learn the full stream length, then fail at every point of it
+	var full bytes.Buffer
+	w := NewWriterSize(&full, 1000)
+	w.Write(input)
+	w.Close()
+	if w.Committed() != int64(len(input)) {
+		t.Fatalf("Committed = %d after Close, want %d", w.Committed(), len(input))
+	}
+
+	for limit := 0; limit < full.Len(); limit += 7 {
+		fw := &failAfterWriter{n: limit}
+		w.Reset(fw)
+		_, err := w.Write(input)
+		if err == nil {
+			err = w.Close()
+		}
+		if err == nil {
+			t.Fatalf("limit %d: no error", limit)
+		}
+		committed := w.Committed()
+
+		// The receiver decodes exactly the committed prefix
+		got, err := io.ReadAll(NewReader(&fw.buf))
+		if !errors.Is(err, ErrInputOverrun) {
+			t.Errorf("limit %d: expected ErrInputOverrun, got %v", limit, err)
+		}
+		if !bytes.Equal(got, input[:committed]) {
+			t.Fatalf("limit %d: decoded %d bytes, committed %d", limit, len(got), committed)
+		}
+
+		// The input can be resumed from there on a new stream
+		var rest bytes.Buffer
+		w.Reset(&rest)
+		w.Write(input[committed:])
+		if err := w.Close(); err != nil {
+			t.Fatalf("limit %d: resumed Close failed: %v", limit, err)
+		}
+		tail, err := io.ReadAll(NewReader(&rest))
+		if err != nil || !bytes.Equal(append(got, tail...), input) {
+			t.Fatalf("limit %d: resumed stream does not complete the input: %v", limit, err)
+		}
+	}
+}
+
+func TestCompressPull(t *testing.T) {
+	// Chunks of every size up to well past a block, reusing one buffer
+	var want []byte
+	buf := make([]byte, 3*DefaultBlockSize)
+	size := 0
+	next := func() ([]byte, bool) {
+		if size > len(buf) {
+			return nil, false
+		}
+		chunk := buf[:size]
+		for i := range chunk {
+			chunk[i] = byte(size + i%7)
+		}
+		want = append(want, chunk...)
+		size = size*2 + 1
+		return chunk, true
+	}
+
+	var stream bytes.Buffer
+	if err := CompressPull(next, &stream); err != nil {
+		t.Fatalf("CompressPull failed: %v", err)
+	}
+	got, err := io.ReadAll(NewReader(&stream))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("roundtrip failed: got %d bytes, want %d: %v", len(got), len(want), err)
+	}
+
+	errBoom := errors.New("boom")
+	if err := CompressPull(func() ([]byte, bool) { return nil, false }, failWriter{errBoom}); err != errBoom {
+		t.Errorf("expected errBoom from the terminator write, got %v", err)
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	pr, pw := io.Pipe()
+	w := NewWriter(pw)
+	r := NewReader(pr)
+
+	messages := []string{"hello", "how are you?", strings.Repeat("long message ", 100)}
+
+	// Each message must be readable as soon as it is flushed, long before
+	// the 64 KiB block fills up
+	done := make(chan error, 1)
+	go func() {
+		for _, msg := range messages {
+			if _, err := w.Write([]byte(msg)); err != nil {
+				done <- err
+				return
+			}
+			if err := w.Flush(); err != nil {
+				done <- err
+				return
+			}
+			// Nothing pending: no empty block is written
+			if err := w.Flush(); err != nil {
+				done <- err
+				return
+			}
+		}
+		if err := w.Close(); err != nil {
+			done <- err
+			return
+		}
+		done <- pw.Close()
+	}()
+
+	for _, msg := range messages {
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("reading %q: %v", msg, err)
+		}
+		if string(got) != msg {
+			t.Errorf("got %q, want %q", got, msg)
+		}
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("after last message: got (%d, %v), want (0, io.EOF)", n, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("writer failed: %v", err)
+	}
+}
+
+func TestWriterFlushEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.Flush(); err != nil || buf.Len() != 0 {
+		t.Errorf("Flush with nothing buffered: err %v, wrote %d bytes", err, buf.Len())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := w.Flush(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Flush after Close: expected ErrClosed, got %v", err)
+	}
+}
+
+func BenchmarkWriterFlushSmall(b *testing.B) {
+	msg := []byte("user42: see you at the standup in 5 minutes")
+	w := NewWriter(io.Discard)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = w.Write(msg)
+		_ = w.Flush()
+	}
+}
+
+func BenchmarkWriterWriteString(b *testing.B) {
+	// Built at run time, like a formatted log line; a constant converts
+	// without allocating
+	line := strings.Repeat("INFO request served path=/api/v1/items ", 2)
+	b.Run("WriteString", func(b *testing.B) {
+		benchmarkLogLines(b, NewWriter(io.Discard), line, func(w io.Writer, s string) {
+			_, _ = io.WriteString(w, s)
+		})
+	})
+	b.Run("Write", func(b *testing.B) {
+		benchmarkLogLines(b, NewWriter(io.Discard), line, func(w io.Writer, s string) {
+			_, _ = w.Write([]byte(s))
+		})
+	})
+}
+
+// benchmarkLogLines writes line to w with write, through an io.Writer as
+// a logger would hold it. It is not inlined so the compiler cannot see the
+// concrete Writer and keep []byte(line) off the heap.
+//
+//go:noinline
+func benchmarkLogLines(b *testing.B, w io.Writer, line string, write func(io.Writer, string)) {
+	b.SetBytes(int64(len(line)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		write(w, line)
+	}
+}
+
+func TestWriterLevel(t *testing.T) {
+	input := bytes.Repeat([]byte("abcdefgh, abcdXfgh; abcdefgY "), 500)
+	for level := 1; level <= 3; level++ {
+		var buf bytes.Buffer
+		w, err := NewWriterLevel(&buf, level)
+		if err != nil {
+			t.Fatalf("level %d: NewWriterLevel failed: %v", level, err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatalf("level %d: Write failed: %v", level, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("level %d: Close failed: %v", level, err)
+		}
+		if got := decodeBlocks(t, buf.Bytes()); !bytes.Equal(got, input) {
+			t.Errorf("level %d: roundtrip mismatch", level)
+		}
+	}
+
+	for _, level := range []int{-1, 0, 4} {
+		if _, err := NewWriterLevel(io.Discard, level); err == nil {
+			t.Errorf("level %d: expected an error", level)
+		}
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	w, err := NewWriterLevel(failWriter{errors.New("boom")}, 2)
+	if err != nil {
+		t.Fatalf("NewWriterLevel failed: %v", err)
+	}
+	w.Write(bytes.Repeat([]byte("x"), DefaultBlockSize)) // fails, leaving a sticky error
+
+	inputs := [][]byte{
+		[]byte("first stream, first stream"),
+		{},
+		bytes.Repeat([]byte("third stream "), 10000),
+	}
+	for i, input := range inputs {
+		var buf bytes.Buffer
+		w.Reset(&buf)
+		if _, err := w.Write(input); err != nil {
+			t.Fatalf("stream %d: Write failed: %v", i, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("stream %d: Close failed: %v", i, err)
+		}
+		if got := decodeBlocks(t, buf.Bytes()); !bytes.Equal(got, input) {
+			t.Errorf("stream %d: roundtrip mismatch", i)
+		}
+	}
+}